@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/joshvanl/go-whisper/pkg/uid"
+)
+
+var acceptKeyCmd = &cobra.Command{
+	Use:   "accept-key [uid]",
+	Short: "Trust the new key of a contact whose key has changed",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(RootCmd)
+
+		n, err := uid.Parse(args[0])
+		if err != nil {
+			log.Fatalf("invalid uid: %v", err)
+		}
+
+		c := LocalClient(log)
+
+		if err := c.AcceptKeyChange(uid.Key(n)); err != nil {
+			log.Fatalf("failed to accept key: %v", err)
+		}
+
+		fmt.Printf("Accepted the new key of %s. Compare safety numbers with them again using safety-number.\n", uid.Format(n))
+	},
+}
+
+func init() { RootCmd.AddCommand(acceptKeyCmd) }
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/joshvanl/go-whisper/pkg/client"
+	"github.com/joshvanl/go-whisper/pkg/uid"
+)
+
+const FlagForce = "force"
+
+var backupCmd = &cobra.Command{
+	Use:   "backup [file]",
+	Short: "Export your identity, config and contacts to a passphrase encrypted file",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(RootCmd)
+
+		c := LocalClient(log)
+
+		passphrase, err := readPassphrase("Backup passphrase: ")
+		if err != nil {
+			log.Fatalf("failed to read passphrase: %v", err)
+		}
+
+		confirm, err := readPassphrase("Confirm backup passphrase: ")
+		if err != nil {
+			log.Fatalf("failed to read passphrase: %v", err)
+		}
+
+		if !bytes.Equal(passphrase, confirm) {
+			log.Fatalf("passphrases do not match")
+		}
+
+		data, err := c.Backup(passphrase)
+		if err != nil {
+			log.Fatalf("failed to create backup: %v", err)
+		}
+
+		if err := ioutil.WriteFile(args[0], data, 0600); err != nil {
+			log.Fatalf("failed to write backup: %v", err)
+		}
+
+		fmt.Printf("Backed up uid %s to %s.\n", uid.Format(c.UID()), args[0])
+	},
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore [file]",
+	Short: "Restore your identity from a backup file",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(RootCmd)
+
+		force, err := cmd.Flags().GetBool(FlagForce)
+		if err != nil {
+			log.Fatalf("failed to resolve force flag: %v", err)
+		}
+
+		_, dir := AddrDir(RootCmd, log)
+
+		data, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			log.Fatalf("failed to read backup: %v", err)
+		}
+
+		passphrase, err := readPassphrase("Backup passphrase: ")
+		if err != nil {
+			log.Fatalf("failed to read passphrase: %v", err)
+		}
+
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			log.Fatalf("failed to create go-whisper directory: %v", err)
+		}
+
+		info, err := client.RestoreBackup(dir, data, passphrase, force)
+		if err == client.ErrBackupExists {
+			log.Fatalf("%s already holds an identity, use --%s to overwrite it", dir, FlagForce)
+		}
+		if err != nil {
+			log.Fatalf("failed to restore backup: %v", err)
+		}
+
+		fmt.Printf("Restored uid %s from backup taken %s (%d files).\n", uid.Format(info.UID), info.Created.Format("2006-01-02 15:04"), info.Files)
+	},
+}
+
+func init() {
+	restoreCmd.Flags().Bool(FlagForce, false, "Overwrite an existing identity")
+	RootCmd.AddCommand(backupCmd, restoreCmd)
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/joshvanl/go-whisper/pkg/uid"
+)
+
+const FlagYes = "yes"
+
+var deleteAccountCmd = &cobra.Command{
+	Use:   "delete-account",
+	Short: "Permanently delete your account and uid from the server",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(RootCmd)
+
+		yes, err := cmd.Flags().GetBool(FlagYes)
+		if err != nil {
+			log.Fatalf("failed to resolve yes flag: %v", err)
+		}
+
+		c := HeadlessClient(log)
+		id := uid.Format(c.UID())
+
+		if !yes {
+			fmt.Printf("This will permanently delete uid %s. Type the uid to confirm: ", id)
+
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil {
+				log.Fatalf("failed to read confirmation: %v", err)
+			}
+
+			n, err := uid.Parse(strings.TrimSpace(line))
+			if err != nil || n != c.UID() {
+				log.Fatalf("uid did not match, not deleting account")
+			}
+		}
+
+		if err := c.DeleteAccount(); err != nil {
+			log.Fatalf("failed to delete account: %v", err)
+		}
+
+		fmt.Printf("Deleted account %s.\n", id)
+	},
+}
+
+func init() {
+	deleteAccountCmd.Flags().Bool(FlagYes, false, "Do not ask for confirmation")
+	RootCmd.AddCommand(deleteAccountCmd)
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/joshvanl/go-whisper/pkg/uid"
+)
+
+var linkDeviceCmd = &cobra.Command{
+	Use:   "link-device [name]",
+	Short: "Link this client to an existing account as a new device",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(RootCmd)
+
+		c := LocalClient(log)
+
+		err := c.LinkDevice(args[0], func(code, fingerprint string) {
+			fmt.Printf("Run add-device %s on a device already on your account.\n", code)
+			fmt.Printf("Check it shows this device's fingerprint:\n%s\n", fingerprint)
+		})
+		if err != nil {
+			log.Fatalf("failed to link device: %v", err)
+		}
+
+		fmt.Printf("Linked this device to uid %s.\n", uid.Format(c.UID()))
+	},
+}
+
+var addDeviceCmd = &cobra.Command{
+	Use:   "add-device [code]",
+	Short: "Approve a new device showing a link code to join your account",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(RootCmd)
+
+		yes, err := cmd.Flags().GetBool(FlagYes)
+		if err != nil {
+			log.Fatalf("failed to resolve yes flag: %v", err)
+		}
+
+		c := HeadlessClient(log)
+
+		id, err := c.AddDevice(args[0], func(name, fingerprint string) bool {
+			fmt.Printf("Device %q has fingerprint:\n%s\n", name, fingerprint)
+			if yes {
+				return true
+			}
+
+			fmt.Print("Link it to your account? [y/N]: ")
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil {
+				return false
+			}
+
+			return strings.ToLower(strings.TrimSpace(line)) == "y"
+		})
+		if err != nil {
+			log.Fatalf("failed to add device: %v", err)
+		}
+
+		fmt.Printf("Added device %s.\n", id)
+	},
+}
+
+var devicesCmd = &cobra.Command{
+	Use:   "devices",
+	Short: "List the devices linked to your account",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(RootCmd)
+
+		c := HeadlessClient(log)
+
+		devices, err := c.Devices()
+		if err != nil {
+			log.Fatalf("failed to list devices: %v", err)
+		}
+
+		if len(devices) == 0 {
+			fmt.Println("No linked devices.")
+			return
+		}
+
+		for _, d := range devices {
+			fmt.Printf("%s\t%s\tadded %s\n", d.ID, d.Name, d.Added.Format("2006-01-02 15:04"))
+		}
+	},
+}
+
+var revokeDeviceCmd = &cobra.Command{
+	Use:   "revoke-device [id]",
+	Short: "Unlink a device from your account",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(RootCmd)
+
+		c := HeadlessClient(log)
+
+		if err := c.RevokeDevice(args[0]); err != nil {
+			log.Fatalf("failed to revoke device: %v", err)
+		}
+
+		fmt.Printf("Revoked device %s.\n", args[0])
+	},
+}
+
+func init() {
+	addDeviceCmd.Flags().Bool(FlagYes, false, "Do not ask for confirmation")
+	RootCmd.AddCommand(linkDeviceCmd, addDeviceCmd, devicesCmd, revokeDeviceCmd)
+}
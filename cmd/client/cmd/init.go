@@ -2,18 +2,26 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 
 	"github.com/mitchellh/go-homedir"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
 	"github.com/joshvanl/go-whisper/pkg/client"
+	"github.com/joshvanl/go-whisper/pkg/logging"
+	"github.com/joshvanl/go-whisper/pkg/uid"
 )
 
 const FlagLogLevel = "log-level"
+const FlagLogFormat = "log-format"
 const FlagServerAddr = "server-address"
 const FlagConfigDir = "config"
+const FlagRequestUID = "request-uid"
+const FlagInvite = "invite"
+const FlagLogFile = "log-file"
 
 var RootCmd = &cobra.Command{
 	Use:   "client",
@@ -22,34 +30,38 @@ var RootCmd = &cobra.Command{
 
 		log := LogLevel(cmd)
 
-		addr, err := cmd.PersistentFlags().GetString(FlagServerAddr)
-		if err != nil {
-			log.Fatalf("failed to resolve server address: %v", err)
-		}
+		addr, dir := AddrDir(cmd, log)
 
-		dir, err := cmd.PersistentFlags().GetString(FlagConfigDir)
+		// The GUI owns the terminal, so the client logs to a file instead.
+		guiLog := GUILog(cmd, dir, log)
+
+		requested, err := cmd.PersistentFlags().GetString(FlagRequestUID)
 		if err != nil {
-			log.Fatalf("failed to resolve configAdirectory flag: %v", err)
+			log.Fatalf("failed to resolve requested uid flag: %v", err)
 		}
 
-		if dir == "." {
-			dir, err = os.Getwd()
+		var requestedUID uint64
+		if requested != "" {
+			requestedUID, err = uid.ParseValid(requested)
 			if err != nil {
-				log.Fatalf("failed to get working directory: %v", err)
-			}
-		} else {
-			dir, err = homedir.Expand(dir)
-			if err != nil {
-				log.Fatalf("failed to expand go-whipser config directory: %v", err)
+				log.Fatalf("invalid requested uid: %v", err)
 			}
 		}
 
-		c, err := client.New(addr, dir)
+		invite, err := cmd.PersistentFlags().GetString(FlagInvite)
+		if err != nil {
+			log.Fatalf("failed to resolve invite flag: %v", err)
+		}
+
+		c, err := client.New(addr, dir, Passphrase(dir, log), guiLog)
 		if err != nil {
 			c.Close()
 			log.Fatalf("error creating client: %v", err)
 		}
 
+		c.RequestUID(requestedUID)
+		c.Invite(invite)
+
 		if err := c.Connect(); err != nil {
 			c.Close()
 			log.Fatalf("error running client: %v", err)
@@ -62,8 +74,12 @@ var RootCmd = &cobra.Command{
 
 func init() {
 	RootCmd.PersistentFlags().IntP(FlagLogLevel, "l", 1, "Set the log level of output. 0-Fatal 1-Info 2-Debug")
+	RootCmd.PersistentFlags().String(FlagLogFormat, logging.FormatText, "Set the format of log output, text or json")
 	RootCmd.PersistentFlags().StringP(FlagServerAddr, "s", "", "Set the address of the server (default 127.0.0.1:6667 in config)")
 	RootCmd.PersistentFlags().StringP(FlagConfigDir, "c", "~/.go-whisper", "Directory of go-whipser directory")
+	RootCmd.PersistentFlags().String(FlagRequestUID, "", "Ask the server for this uid when registering (the server must allow chosen uids)")
+	RootCmd.PersistentFlags().String(FlagLogFile, "", "File to write logs to while the GUI is running (default client.log in the config directory)")
+	RootCmd.PersistentFlags().String(FlagInvite, "", "Invite code to register with, for servers where registration is invite only")
 }
 
 func Execute() {
@@ -73,24 +89,100 @@ func Execute() {
 	}
 }
 
+// AddrDir resolves the server address and go-whisper directory flags.
+func AddrDir(cmd *cobra.Command, log *logrus.Entry) (addr, dir string) {
+	addr, err := cmd.PersistentFlags().GetString(FlagServerAddr)
+	if err != nil {
+		log.Fatalf("failed to resolve server address: %v", err)
+	}
+
+	dir, err = cmd.PersistentFlags().GetString(FlagConfigDir)
+	if err != nil {
+		log.Fatalf("failed to resolve configAdirectory flag: %v", err)
+	}
+
+	if dir == "." {
+		dir, err = os.Getwd()
+		if err != nil {
+			log.Fatalf("failed to get working directory: %v", err)
+		}
+	} else {
+		dir, err = homedir.Expand(dir)
+		if err != nil {
+			log.Fatalf("failed to expand go-whipser config directory: %v", err)
+		}
+	}
+
+	return addr, dir
+}
+
+// HeadlessClient connects to the server without starting the GUI.
+func HeadlessClient(log *logrus.Entry) *client.Client {
+	c := LocalClient(log)
+
+	if err := c.Connect(); err != nil {
+		log.Fatalf("error connecting to server: %v", err)
+	}
+
+	return c
+}
+
+// LocalClient loads the client's key and config without starting the GUI or
+// connecting to the server.
+func LocalClient(log *logrus.Entry) *client.Client {
+	addr, dir := AddrDir(RootCmd, log)
+
+	c, err := client.NewHeadless(addr, dir, Passphrase(dir, log), log)
+	if err != nil {
+		log.Fatalf("error creating client: %v", err)
+	}
+
+	return c
+}
+
 func LogLevel(cmd *cobra.Command) *logrus.Entry {
-	logger := logrus.New()
+	return newLog(cmd, os.Stderr)
+}
 
-	i, err := cmd.PersistentFlags().GetInt(FlagLogLevel)
+// GUILog opens the log file written to while the GUI is running.
+func GUILog(cmd *cobra.Command, dir string, log *logrus.Entry) *logrus.Entry {
+	path, err := cmd.PersistentFlags().GetString(FlagLogFile)
+	if err != nil {
+		log.Fatalf("failed to resolve log file flag: %v", err)
+	}
+
+	if path == "" {
+		path = filepath.Join(dir, "client.log")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		log.Fatalf("failed to create log directory: %v", err)
+	}
+
+	f, err := logging.OpenFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return newLog(cmd, f)
+}
+
+// newLog builds a logger writing to out from the log flags.
+func newLog(cmd *cobra.Command, out io.Writer) *logrus.Entry {
+	level, err := cmd.PersistentFlags().GetInt(FlagLogLevel)
 	if err != nil {
 		logrus.Fatalf("failed to get log level of flag: %s", err)
 	}
-	if i < 0 || i > 2 {
-		logrus.Fatalf("not a valid log level")
+
+	format, err := cmd.PersistentFlags().GetString(FlagLogFormat)
+	if err != nil {
+		logrus.Fatalf("failed to get log format of flag: %s", err)
 	}
-	switch i {
-	case 0:
-		logger.Level = logrus.FatalLevel
-	case 1:
-		logger.Level = logrus.InfoLevel
-	case 2:
-		logger.Level = logrus.DebugLevel
+
+	log, err := logging.New(out, level, format)
+	if err != nil {
+		logrus.Fatal(err)
 	}
 
-	return logrus.NewEntry(logger)
+	return log
 }
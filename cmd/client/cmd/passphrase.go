@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	"github.com/joshvanl/go-whisper/pkg/key"
+)
+
+var passphraseCmd = &cobra.Command{
+	Use:   "passphrase",
+	Short: "Set, change or remove the passphrase protecting your private key",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(RootCmd)
+
+		_, dir := AddrDir(RootCmd, log)
+
+		k, err := key.NewWithPassphrase(dir, Passphrase(dir, log))
+		if err != nil {
+			log.Fatalf("failed to read private key: %v", err)
+		}
+
+		passphrase, err := readPassphrase("New passphrase (empty to store the key unencrypted): ")
+		if err != nil {
+			log.Fatalf("failed to read passphrase: %v", err)
+		}
+
+		confirm, err := readPassphrase("Confirm new passphrase: ")
+		if err != nil {
+			log.Fatalf("failed to read passphrase: %v", err)
+		}
+
+		if !bytes.Equal(passphrase, confirm) {
+			log.Fatalf("passphrases do not match")
+		}
+
+		if err := k.SetPassphrase(passphrase); err != nil {
+			log.Fatalf("failed to set passphrase: %v", err)
+		}
+
+		if len(passphrase) == 0 {
+			fmt.Printf("Private key is now stored unencrypted.\n")
+		} else {
+			fmt.Printf("Private key is now encrypted.\n")
+		}
+	},
+}
+
+func init() { RootCmd.AddCommand(passphraseCmd) }
+
+// Passphrase prompts for the private key passphrase if the key in dir is
+// encrypted. It prompts straight away, rather than when the key is read, so
+// the prompt comes before the GUI takes over the terminal.
+func Passphrase(dir string, log *logrus.Entry) key.PassphraseFunc {
+	encrypted, err := key.Encrypted(dir)
+	if err != nil {
+		log.Fatalf("failed to check private key: %v", err)
+	}
+
+	if !encrypted {
+		return nil
+	}
+
+	passphrase, err := readPassphrase("Private key passphrase: ")
+	if err != nil {
+		log.Fatalf("failed to read passphrase: %v", err)
+	}
+
+	return func() ([]byte, error) {
+		return passphrase, nil
+	}
+}
+
+func readPassphrase(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	defer fmt.Fprintln(os.Stderr)
+
+	return term.ReadPassword(int(os.Stdin.Fd()))
+}
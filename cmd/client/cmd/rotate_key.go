@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var rotateKeyCmd = &cobra.Command{
+	Use:   "rotate-key",
+	Short: "Replace your identity key with a new one signed by the old key",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(RootCmd)
+
+		c := HeadlessClient(log)
+
+		fmt.Printf("Generating new key pair...\n")
+		if err := c.RotateKey(); err != nil {
+			log.Fatalf("failed to rotate key: %v", err)
+		}
+
+		fmt.Printf("Rotated identity key.\n")
+	},
+}
+
+func init() { RootCmd.AddCommand(rotateKeyCmd) }
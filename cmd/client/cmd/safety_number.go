@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/skip2/go-qrcode"
+	"github.com/spf13/cobra"
+
+	"github.com/joshvanl/go-whisper/pkg/key"
+	"github.com/joshvanl/go-whisper/pkg/uid"
+)
+
+const FlagVerified = "verified"
+
+var safetyNumberCmd = &cobra.Command{
+	Use:   "safety-number [uid]",
+	Short: "Show the safety number to compare with a contact, and mark them verified",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(RootCmd)
+
+		verified, err := cmd.Flags().GetBool(FlagVerified)
+		if err != nil {
+			log.Fatalf("failed to resolve verified flag: %v", err)
+		}
+
+		n, err := uid.Parse(args[0])
+		if err != nil {
+			log.Fatalf("invalid uid: %v", err)
+		}
+		id := uid.Key(n)
+
+		c := LocalClient(log)
+
+		number, err := c.SafetyNumber(id)
+		if err != nil {
+			log.Fatalf("failed to compute safety number: %v", err)
+		}
+
+		qr, err := qrcode.New(number, qrcode.Medium)
+		if err != nil {
+			log.Fatalf("failed to encode safety number: %v", err)
+		}
+
+		fmt.Printf("Safety number with %s:\n\n%s\n\n%s\n", uid.Format(n), key.FormatSafetyNumber(number), qr.ToSmallString(false))
+
+		if verified {
+			if err := c.MarkVerified(id); err != nil {
+				log.Fatalf("failed to mark contact verified: %v", err)
+			}
+		}
+
+		ok, err := c.Verified(id)
+		if err != nil {
+			log.Fatalf("failed to read verified contacts: %v", err)
+		}
+
+		if changed, err := c.KeyChanged(id); err == nil && changed {
+			fmt.Printf("WARNING: the server returned a different key for %s. This number is for the key you trusted before; run accept-key to trust the new one.\n", uid.Format(n))
+		} else if ok {
+			fmt.Printf("%s is verified.\n", uid.Format(n))
+		} else {
+			fmt.Printf("%s is not verified. Compare the number above with them in person, then run again with --%s.\n", uid.Format(n), FlagVerified)
+		}
+	},
+}
+
+func init() {
+	safetyNumberCmd.Flags().Bool(FlagVerified, false, "Mark the contact as verified after comparing safety numbers")
+	RootCmd.AddCommand(safetyNumberCmd)
+}
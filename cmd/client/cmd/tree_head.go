@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var treeHeadCmd = &cobra.Command{
+	Use:   "tree-head",
+	Short: "Fetch and check the server's key transparency tree head",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(RootCmd)
+
+		c := HeadlessClient(log)
+
+		head, err := c.TreeHead()
+		if err != nil {
+			log.Fatalf("failed to verify tree head: %v", err)
+		}
+
+		fmt.Printf("Tree size: %d\nRoot hash: %s\nSigned at: %s\n", head.Size, hex.EncodeToString(head.Root), head.Time)
+	},
+}
+
+func init() { RootCmd.AddCommand(treeHeadCmd) }
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/joshvanl/go-whisper/pkg/username"
+)
+
+var usernameCmd = &cobra.Command{
+	Use:   "username [name]",
+	Short: "Claim a username that others can use to find you",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(RootCmd)
+
+		c := HeadlessClient(log)
+
+		if err := c.ClaimUsername(args[0]); err != nil {
+			log.Fatalf("failed to claim username: %v", err)
+		}
+
+		fmt.Printf("Claimed username %s.\n", username.Canonical(args[0]))
+	},
+}
+
+func init() { RootCmd.AddCommand(usernameCmd) }
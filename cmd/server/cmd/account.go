@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/joshvanl/go-whisper/pkg/key"
+	"github.com/joshvanl/go-whisper/pkg/server"
+	"github.com/joshvanl/go-whisper/pkg/uid"
+)
+
+const FlagYes = "yes"
+
+var accountCmd = &cobra.Command{
+	Use:   "account",
+	Short: "Manage the accounts registered on this server",
+}
+
+var accountListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List registered accounts",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(RootCmd)
+
+		accounts, err := openAdmin(log).Accounts()
+		if err != nil {
+			log.Fatalf("failed to list accounts: %v", err)
+		}
+
+		if len(accounts) == 0 {
+			fmt.Println("No accounts.")
+			return
+		}
+
+		for _, a := range accounts {
+			fmt.Printf("%s\t%s\t%s\t%s\n", accountUID(a.UID), accountUsername(&a),
+				a.Registered.Format("2006-01-02 15:04"), accountStatus(&a))
+		}
+	},
+}
+
+var accountShowCmd = &cobra.Command{
+	Use:   "show [uid]",
+	Short: "Show an account's details and key fingerprint",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(RootCmd)
+
+		a, err := openAdmin(log).Account(accountArg(args[0], log))
+		if err != nil {
+			log.Fatalf("failed to get account: %v", err)
+		}
+
+		fmt.Printf("UID:         %s\n", accountUID(a.UID))
+		fmt.Printf("Username:    %s\n", accountUsername(a))
+		fmt.Printf("Status:      %s\n", accountStatus(a))
+		fmt.Printf("Registered:  %s\n", a.Registered.Format("2006-01-02 15:04"))
+		fmt.Printf("Key type:    %s\n", a.KeyType)
+		fmt.Printf("Fingerprint: %s\n", strings.Replace(key.FormatSafetyNumber(a.Fingerprint), "\n", " ", -1))
+		fmt.Printf("Rotations:   %d\n", a.Rotations)
+		fmt.Printf("Devices:     %d\n", a.Devices)
+	},
+}
+
+var accountDisableCmd = &cobra.Command{
+	Use:   "disable [uid]",
+	Short: "Suspend an account so the server refuses its requests",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(RootCmd)
+
+		id := accountArg(args[0], log)
+		if err := openAdmin(log).Disable(id); err != nil {
+			log.Fatalf("failed to disable account: %v", err)
+		}
+
+		fmt.Printf("Disabled account %s.\n", accountUID(id))
+	},
+}
+
+var accountEnableCmd = &cobra.Command{
+	Use:   "enable [uid]",
+	Short: "Lift the suspension of a disabled account",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(RootCmd)
+
+		id := accountArg(args[0], log)
+		if err := openAdmin(log).Enable(id); err != nil {
+			log.Fatalf("failed to enable account: %v", err)
+		}
+
+		fmt.Printf("Enabled account %s.\n", accountUID(id))
+	},
+}
+
+var accountDeleteCmd = &cobra.Command{
+	Use:   "delete [uid]",
+	Short: "Permanently delete an account and its uid",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(RootCmd)
+
+		yes, err := cmd.Flags().GetBool(FlagYes)
+		if err != nil {
+			log.Fatalf("failed to resolve yes flag: %v", err)
+		}
+
+		id := accountArg(args[0], log)
+
+		if !yes {
+			fmt.Printf("This will permanently delete uid %s. Type the uid to confirm: ", accountUID(id))
+
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil {
+				log.Fatalf("failed to read confirmation: %v", err)
+			}
+
+			n, err := uid.Parse(strings.TrimSpace(line))
+			if err != nil || uid.Key(n) != id {
+				log.Fatalf("uid did not match, not deleting account")
+			}
+		}
+
+		if err := openAdmin(log).Delete(id); err != nil {
+			log.Fatalf("failed to delete account: %v", err)
+		}
+
+		fmt.Printf("Deleted account %s.\n", accountUID(id))
+	},
+}
+
+// accountManager is implemented by both a running server's admin socket
+// and the server directory itself.
+type accountManager interface {
+	Accounts() ([]server.Account, error)
+	Account(id string) (*server.Account, error)
+	Disable(id string) error
+	Enable(id string) error
+	Delete(id string) error
+}
+
+// openAdmin manages accounts through the running server if there is one, so
+// its changes take effect immediately, or else the server directory.
+func openAdmin(log *logrus.Entry) accountManager {
+	dir := Dir(RootCmd, log)
+
+	client, err := server.DialAdmin(dir)
+	if err == nil {
+		return client
+	}
+	if err != server.ErrNotRunning {
+		log.Fatalf("failed to connect to running server: %v", err)
+	}
+
+	admin, err := server.OpenAdmin(dir)
+	if err != nil {
+		log.Fatalf("failed to open server directory: %v", err)
+	}
+
+	return admin
+}
+
+// accountArg parses a uid given on the command line into its stored form.
+func accountArg(arg string, log *logrus.Entry) string {
+	n, err := uid.Parse(arg)
+	if err != nil {
+		log.Fatalf("failed to parse uid: %v", err)
+	}
+
+	return uid.Key(n)
+}
+
+func accountUID(id string) string {
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return id
+	}
+
+	return uid.Format(n)
+}
+
+func accountUsername(a *server.Account) string {
+	if a.Username == "" {
+		return "-"
+	}
+
+	return a.Username
+}
+
+func accountStatus(a *server.Account) string {
+	if a.Disabled.IsZero() {
+		return "enabled"
+	}
+
+	return "disabled since " + a.Disabled.Format("2006-01-02 15:04")
+}
+
+func init() {
+	accountDeleteCmd.Flags().Bool(FlagYes, false, "Do not ask for confirmation")
+	accountCmd.AddCommand(accountListCmd, accountShowCmd, accountDisableCmd, accountEnableCmd, accountDeleteCmd)
+	RootCmd.AddCommand(accountCmd)
+}
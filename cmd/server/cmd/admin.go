@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/joshvanl/go-whisper/pkg/server"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the status of the running server",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(RootCmd)
+
+		status, err := dialAdmin(log).Status()
+		if err != nil {
+			log.Fatalf("failed to get server status: %v", err)
+		}
+
+		fmt.Printf("Address:      %s\n", status.Address)
+		fmt.Printf("Up since:     %s (%s)\n", status.Started.Format("2006-01-02 15:04"),
+			time.Since(status.Started).Round(time.Second))
+		fmt.Printf("Sessions:     %d\n", status.Sessions)
+		fmt.Printf("Accounts:     %d\n", status.Accounts)
+		fmt.Printf("Registration: %s\n", status.Registration)
+	},
+}
+
+var sessionsCmd = &cobra.Command{
+	Use:   "sessions",
+	Short: "List the running server's client connections",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(RootCmd)
+
+		sessions, err := dialAdmin(log).Sessions()
+		if err != nil {
+			log.Fatalf("failed to list sessions: %v", err)
+		}
+
+		if len(sessions) == 0 {
+			fmt.Println("No sessions.")
+			return
+		}
+
+		for _, s := range sessions {
+			id := "-"
+			if s.UID != "" {
+				id = accountUID(s.UID)
+			}
+			if s.Device != "" {
+				id += ":" + s.Device
+			}
+
+			fmt.Printf("%s\t%s\t%s\tsince %s\t%d request(s)\tlast seen %s\n", s.ID, s.Address, id,
+				s.Started.Format("2006-01-02 15:04"), s.Requests, s.LastSeen.Format("15:04:05"))
+		}
+	},
+}
+
+var kickCmd = &cobra.Command{
+	Use:   "kick [uid]",
+	Short: "Disconnect every session of a uid from the running server",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(RootCmd)
+
+		id := accountArg(args[0], log)
+
+		kicked, err := dialAdmin(log).Kick(id)
+		if err != nil {
+			log.Fatalf("failed to kick %s: %v", accountUID(id), err)
+		}
+
+		fmt.Printf("Disconnected %d session(s) of %s.\n", kicked, accountUID(id))
+	},
+}
+
+var reloadCmd = &cobra.Command{
+	Use:   "reload",
+	Short: "Make the running server re-read server.json and its accounts",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(RootCmd)
+
+		if err := dialAdmin(log).Reload(); err != nil {
+			log.Fatalf("failed to reload server: %v", err)
+		}
+
+		fmt.Printf("Reloaded server.\n")
+	},
+}
+
+var compactCmd = &cobra.Command{
+	Use:   "compact",
+	Short: "Remove released usernames and spent invites from the running server's storage",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(RootCmd)
+
+		compaction, err := dialAdmin(log).Compact()
+		if err != nil {
+			log.Fatalf("failed to compact storage: %v", err)
+		}
+
+		fmt.Printf("Removed %d username(s) and %d invite(s).\n", compaction.Usernames, compaction.Invites)
+	},
+}
+
+func dialAdmin(log *logrus.Entry) *server.AdminClient {
+	client, err := server.DialAdmin(Dir(RootCmd, log))
+	if err != nil {
+		log.Fatalf("failed to connect to running server: %v", err)
+	}
+
+	return client
+}
+
+func init() {
+	RootCmd.AddCommand(statusCmd, sessionsCmd, kickCmd, reloadCmd, compactCmd)
+}
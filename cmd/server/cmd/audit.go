@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/joshvanl/go-whisper/pkg/server"
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Inspect the server's security audit log",
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check the audit log has not been modified or truncated",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(RootCmd)
+
+		result, err := server.VerifyAudit(Dir(RootCmd, log))
+		if err != nil {
+			log.Fatalf("audit log failed verification: %v", err)
+		}
+
+		if result.Head == nil {
+			fmt.Println("Audit log is empty.")
+			return
+		}
+
+		fmt.Printf("Audit log is intact: %d entries, signed at %s.\n",
+			result.Head.Size, result.Head.Time.Local().Format("2006-01-02 15:04:05"))
+
+		if result.Unsigned > 0 {
+			fmt.Printf("Warning: %d entries after the signed head can not be verified.\n", result.Unsigned)
+		}
+	},
+}
+
+func init() {
+	auditCmd.AddCommand(auditVerifyCmd)
+	RootCmd.AddCommand(auditCmd)
+}
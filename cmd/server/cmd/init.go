@@ -8,10 +8,12 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
+	"github.com/joshvanl/go-whisper/pkg/logging"
 	"github.com/joshvanl/go-whisper/pkg/server"
 )
 
 const FlagLogLevel = "log-level"
+const FlagLogFormat = "log-format"
 const FlagServerAddr = "server-address"
 const FlagConfigDir = "config"
 
@@ -27,22 +29,7 @@ var RootCmd = &cobra.Command{
 			log.Fatalf("failed to resolve server address: %v", err)
 		}
 
-		dir, err := cmd.PersistentFlags().GetString(FlagConfigDir)
-		if err != nil {
-			log.Fatalf("failed to resolve configAdirectory flag: %v", err)
-		}
-
-		if dir == "." {
-			dir, err = os.Getwd()
-			if err != nil {
-				log.Fatalf("failed to get working directory: %v", err)
-			}
-		} else {
-			dir, err = homedir.Expand(dir)
-			if err != nil {
-				log.Fatalf("failed to expand go-whipser config directory: %v", err)
-			}
-		}
+		dir := Dir(cmd, log)
 
 		s, err := server.New(addr, dir, log)
 		if err != nil {
@@ -59,6 +46,7 @@ var RootCmd = &cobra.Command{
 
 func init() {
 	RootCmd.PersistentFlags().IntP(FlagLogLevel, "l", 1, "Set the log level of output. 0-Fatal 1-Info 2-Debug")
+	RootCmd.PersistentFlags().String(FlagLogFormat, logging.FormatText, "Set the format of log output, text or json")
 	RootCmd.PersistentFlags().StringP(FlagServerAddr, "s", "127.0.0.1:6667", "Set the address the server will listen to.")
 	RootCmd.PersistentFlags().StringP(FlagConfigDir, "c", "~/.go-whisper", "Directory of go-whipser directory")
 }
@@ -70,24 +58,43 @@ func Execute() {
 	}
 }
 
-func LogLevel(cmd *cobra.Command) *logrus.Entry {
-	logger := logrus.New()
+// Dir resolves the go-whisper directory flag.
+func Dir(cmd *cobra.Command, log *logrus.Entry) string {
+	dir, err := cmd.PersistentFlags().GetString(FlagConfigDir)
+	if err != nil {
+		log.Fatalf("failed to resolve configAdirectory flag: %v", err)
+	}
+
+	if dir == "." {
+		dir, err = os.Getwd()
+		if err != nil {
+			log.Fatalf("failed to get working directory: %v", err)
+		}
+	} else {
+		dir, err = homedir.Expand(dir)
+		if err != nil {
+			log.Fatalf("failed to expand go-whipser config directory: %v", err)
+		}
+	}
+
+	return dir
+}
 
-	i, err := cmd.PersistentFlags().GetInt(FlagLogLevel)
+func LogLevel(cmd *cobra.Command) *logrus.Entry {
+	level, err := cmd.PersistentFlags().GetInt(FlagLogLevel)
 	if err != nil {
 		logrus.Fatalf("failed to get log level of flag: %s", err)
 	}
-	if i < 0 || i > 2 {
-		logrus.Fatalf("not a valid log level")
+
+	format, err := cmd.PersistentFlags().GetString(FlagLogFormat)
+	if err != nil {
+		logrus.Fatalf("failed to get log format of flag: %s", err)
 	}
-	switch i {
-	case 0:
-		logger.Level = logrus.FatalLevel
-	case 1:
-		logger.Level = logrus.InfoLevel
-	case 2:
-		logger.Level = logrus.DebugLevel
+
+	log, err := logging.New(os.Stderr, level, format)
+	if err != nil {
+		logrus.Fatal(err)
 	}
 
-	return logrus.NewEntry(logger)
+	return log
 }
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/joshvanl/go-whisper/pkg/server"
+)
+
+const FlagUses = "uses"
+const FlagExpires = "expires"
+
+var inviteCmd = &cobra.Command{
+	Use:   "invite",
+	Short: "Manage invite codes for invite only registration",
+}
+
+var inviteCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Mint a new invite code",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(RootCmd)
+
+		uses, err := cmd.Flags().GetInt(FlagUses)
+		if err != nil {
+			log.Fatalf("failed to resolve uses flag: %v", err)
+		}
+
+		expires, err := cmd.Flags().GetDuration(FlagExpires)
+		if err != nil {
+			log.Fatalf("failed to resolve expires flag: %v", err)
+		}
+
+		code, invite, err := server.MintInvite(Dir(RootCmd, log), uses, expires, time.Now())
+		if err != nil {
+			log.Fatalf("failed to create invite: %v", err)
+		}
+
+		fmt.Printf("Invite %s: %s\n", invite.ID, code)
+		fmt.Printf("Usable %d time(s), %s.\n", invite.MaxUses, inviteExpiry(invite))
+		fmt.Printf("The code is not stored and can not be shown again.\n")
+	},
+}
+
+var inviteListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List invite codes and their uses",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(RootCmd)
+
+		invites, err := server.Invites(Dir(RootCmd, log))
+		if err != nil {
+			log.Fatalf("failed to list invites: %v", err)
+		}
+
+		if len(invites) == 0 {
+			fmt.Println("No invites.")
+			return
+		}
+
+		for i := range invites {
+			fmt.Printf("%s\tused %d/%d\tcreated %s\t%s\n", invites[i].ID, invites[i].Uses, invites[i].MaxUses,
+				invites[i].Created.Format("2006-01-02 15:04"), inviteExpiry(&invites[i]))
+		}
+	},
+}
+
+var inviteRevokeCmd = &cobra.Command{
+	Use:   "revoke [id]",
+	Short: "Remove an invite so its code can no longer be used",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(RootCmd)
+
+		if err := server.RevokeInvite(Dir(RootCmd, log), args[0]); err != nil {
+			log.Fatalf("failed to revoke invite: %v", err)
+		}
+
+		fmt.Printf("Revoked invite %s.\n", args[0])
+	},
+}
+
+func inviteExpiry(invite *server.Invite) string {
+	if invite.Expires.IsZero() {
+		return "never expires"
+	}
+	if time.Now().After(invite.Expires) {
+		return "expired " + invite.Expires.Format("2006-01-02 15:04")
+	}
+
+	return "expires " + invite.Expires.Format("2006-01-02 15:04")
+}
+
+func init() {
+	inviteCreateCmd.Flags().Int(FlagUses, 1, "Number of registrations the invite allows")
+	inviteCreateCmd.Flags().Duration(FlagExpires, 7*24*time.Hour, "How long the invite is valid for, 0 for never expiring")
+	inviteCmd.AddCommand(inviteCreateCmd, inviteListCmd, inviteRevokeCmd)
+	RootCmd.AddCommand(inviteCmd)
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/joshvanl/go-whisper/pkg/server"
+)
+
+const FlagKeep = "keep"
+
+var keyCmd = &cobra.Command{
+	Use:   "key",
+	Short: "Manage the server's signing key",
+}
+
+var keyRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Replace the server key with a new one signed by the old key",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(RootCmd)
+
+		fmt.Printf("Generating new key pair...\n")
+		if _, err := server.RotateKey(Dir(RootCmd, log)); err != nil {
+			log.Fatalf("failed to rotate server key: %v", err)
+		}
+
+		fmt.Printf("Rotated server key. Restart the server to start using it.\n")
+	},
+}
+
+var keyRetireCmd = &cobra.Command{
+	Use:   "retire",
+	Short: "Forget old server keys so clients can no longer follow a rotation from them",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(RootCmd)
+
+		keep, err := cmd.Flags().GetInt(FlagKeep)
+		if err != nil {
+			log.Fatalf("failed to resolve keep flag: %v", err)
+		}
+
+		retired, err := server.RetireKeys(Dir(RootCmd, log), keep)
+		if err != nil {
+			log.Fatalf("failed to retire server keys: %v", err)
+		}
+
+		fmt.Printf("Retired %d old server key(s).\n", retired)
+	},
+}
+
+func init() {
+	keyRetireCmd.Flags().Int(FlagKeep, 1, "Number of most recent key transitions to keep")
+	keyCmd.AddCommand(keyRotateCmd, keyRetireCmd)
+	RootCmd.AddCommand(keyCmd)
+}
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/joshvanl/go-whisper/pkg/server"
+)
+
+const FlagTimeout = "timeout"
+
+var selfTestCmd = &cobra.Command{
+	Use:   "selftest",
+	Short: "Check the server at the server address completes a key exchange",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(RootCmd)
+
+		addr, err := RootCmd.PersistentFlags().GetString(FlagServerAddr)
+		if err != nil {
+			log.Fatalf("failed to resolve server address: %v", err)
+		}
+
+		timeout, err := cmd.Flags().GetDuration(FlagTimeout)
+		if err != nil {
+			log.Fatalf("failed to resolve timeout flag: %v", err)
+		}
+
+		if err := server.SelfTest(addr, timeout); err != nil {
+			log.Fatalf("self test failed: %v", err)
+		}
+
+		fmt.Printf("Completed key exchange with %s.\n", addr)
+	},
+}
+
+func init() {
+	selfTestCmd.Flags().Duration(FlagTimeout, 5*time.Second, "How long to wait for the server")
+	RootCmd.AddCommand(selfTestCmd)
+}
@@ -0,0 +1,396 @@
+// Package audit keeps an append-only record of security relevant events.
+// Each entry holds the hash of the one before it, and a head signed by the
+// server key records the size and last hash of the log, so entries can not be
+// changed, removed or cut from the end without Verify noticing.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/key"
+	"github.com/joshvanl/go-whisper/pkg/store"
+)
+
+const (
+	logFile  = "audit.log"
+	headFile = "audit_head.json"
+)
+
+// Events recorded in the log.
+const (
+	EventRegistered       = "registered"
+	EventKeyRotated       = "key rotated"
+	EventAccountDeleted   = "account deleted"
+	EventDeviceLinked     = "device linked"
+	EventDeviceRevoked    = "device revoked"
+	EventServerKeyChanged = "server key changed"
+	EventBadSignature     = "bad signature"
+	EventRateLimited      = "rate limited"
+	EventAdmin            = "admin"
+)
+
+// Entry is one event in the log. The log file holds one JSON entry per line.
+type Entry struct {
+	Seq   uint64    `json:"seq"`
+	Time  time.Time `json:"time"`
+	Event string    `json:"event"`
+
+	Session string `json:"session,omitempty"`
+	Address string `json:"addr,omitempty"`
+	UID     string `json:"uid,omitempty"`
+	Device  string `json:"device,omitempty"`
+
+	Detail map[string]string `json:"detail,omitempty"`
+
+	// Prev is the Hash of the entry before, and empty for the first.
+	Prev []byte `json:"prev,omitempty"`
+	// Hash is over the entry's JSON encoding without Hash.
+	Hash []byte `json:"hash"`
+}
+
+func (e *Entry) hash() ([]byte, error) {
+	c := *e
+	c.Hash = nil
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode audit entry: %v", err)
+	}
+
+	sum := sha256.Sum256(b)
+	return sum[:], nil
+}
+
+// Head is the signed size and last hash of the log.
+type Head struct {
+	Size uint64    `json:"size"`
+	Hash []byte    `json:"hash,omitempty"`
+	Time time.Time `json:"time"`
+
+	// Key is the wire public key that made Signature.
+	Key       []byte `json:"key"`
+	Signature []byte `json:"signature"`
+}
+
+func (h *Head) message() []byte {
+	return bytes.Join([][]byte{
+		[]byte("audit head"),
+		[]byte(strconv.FormatUint(h.Size, 10)),
+		h.Hash,
+		[]byte(strconv.FormatInt(h.Time.UnixNano(), 10)),
+	}, []byte{0})
+}
+
+// Log appends entries to the audit log in a server directory. Other
+// processes may append to the same log; appends are serialised by a lock on
+// the file.
+type Log struct {
+	mu  sync.Mutex
+	dir string
+	key *key.Key
+
+	// offset, size and last describe the log file as this process last
+	// saw it, so it is only re-read when another process has appended.
+	offset int64
+	size   uint64
+	last   []byte
+}
+
+// Open returns the audit log of dir, whose head is signed by k.
+func Open(dir string, k *key.Key) *Log {
+	return &Log{
+		dir: dir,
+		key: k,
+	}
+}
+
+// Head returns the log's signed head, or nil if nothing has been recorded.
+func (l *Log) Head() (*Head, error) {
+	return readHead(l.dir)
+}
+
+// Record appends e to the log and signs the new head. Seq, Prev and Hash
+// are filled in, as is Time if it is zero.
+func (l *Log) Record(e Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.OpenFile(filepath.Join(l.dir, logFile), os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %v", err)
+	}
+	// Closing the file releases the lock.
+	defer f.Close()
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock audit log: %v", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat audit log: %v", err)
+	}
+
+	if info.Size() != l.offset {
+		if err := l.readState(f, info.Size()); err != nil {
+			return err
+		}
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Time = e.Time.UTC()
+	e.Seq = l.size
+	e.Prev = l.last
+
+	e.Hash, err = e.hash()
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %v", err)
+	}
+	line = append(line, '\n')
+
+	if _, err := f.Write(line); err != nil {
+		return fmt.Errorf("failed to append to audit log: %v", err)
+	}
+
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit log: %v", err)
+	}
+
+	l.offset = info.Size() + int64(len(line))
+	l.size++
+	l.last = e.Hash
+
+	return l.writeHead(e.Time)
+}
+
+// readState reads the number of entries in f and the hash of the last. It
+// must be called with l.mu and the file lock held.
+func (l *Log) readState(f *os.File, size int64) error {
+	l.offset, l.size, l.last = 0, 0, nil
+
+	err := readEntries(io.NewSectionReader(f, 0, size), func(e *Entry) error {
+		l.size++
+		l.last = e.Hash
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	l.offset = size
+
+	return nil
+}
+
+// writeHead signs the current size and last hash of the log. It must be
+// called with l.mu and the file lock held.
+func (l *Log) writeHead(now time.Time) error {
+	head := &Head{
+		Size: l.size,
+		Hash: l.last,
+		Time: now,
+		Key:  l.key.PublicKey(),
+	}
+
+	sig, err := l.key.SignMessage(head.message())
+	if err != nil {
+		return fmt.Errorf("failed to sign audit head: %v", err)
+	}
+	head.Signature = sig
+
+	if err := store.WriteJSON(filepath.Join(l.dir, headFile), head); err != nil {
+		return fmt.Errorf("failed to write audit head: %v", err)
+	}
+
+	return nil
+}
+
+// Result is what Verify found in a log whose chain is intact.
+type Result struct {
+	Entries uint64
+	Head    *Head
+
+	// Unsigned is the number of entries after the signed head. They are
+	// left by a server stopped between appending and signing, or were
+	// added by someone without the server key.
+	Unsigned uint64
+}
+
+// Verify checks every entry of the log in dir follows the one before it,
+// and that the log matches its head, which must be signed by one of the
+// trusted wire public keys. k verifies the signature.
+func Verify(dir string, k *key.Key, trusted [][]byte) (*Result, error) {
+	head, err := readHead(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filepath.Join(dir, logFile))
+	if os.IsNotExist(err) {
+		if head != nil && head.Size > 0 {
+			return nil, fmt.Errorf("audit log is missing but its signed head records %d entries", head.Size)
+		}
+
+		return &Result{Head: head}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %v", err)
+	}
+	defer f.Close()
+
+	var n uint64
+	var prev []byte
+	err = readEntries(f, func(e *Entry) error {
+		if e.Seq != n {
+			return fmt.Errorf("entry %d has sequence number %d: entries have been removed or reordered", n, e.Seq)
+		}
+
+		if !bytes.Equal(e.Prev, prev) {
+			return fmt.Errorf("entry %d does not follow the entry before it", n)
+		}
+
+		hash, err := e.hash()
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(hash, e.Hash) {
+			return fmt.Errorf("entry %d has been modified", n)
+		}
+
+		n++
+		prev = e.Hash
+
+		if head != nil && n == head.Size && !bytes.Equal(prev, head.Hash) {
+			return fmt.Errorf("entry %d does not match the signed head", n-1)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if head == nil {
+		if n > 0 {
+			return nil, fmt.Errorf("audit log has %d entries but no signed head", n)
+		}
+
+		return &Result{}, nil
+	}
+
+	if n < head.Size {
+		return nil, fmt.Errorf("audit log has %d entries but its signed head records %d: it has been truncated", n, head.Size)
+	}
+
+	if err := verifyHead(head, k, trusted); err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		Entries:  n,
+		Head:     head,
+		Unsigned: n - head.Size,
+	}, nil
+}
+
+func verifyHead(head *Head, k *key.Key, trusted [][]byte) error {
+	var known bool
+	for _, t := range trusted {
+		if bytes.Equal(t, head.Key) {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return fmt.Errorf("audit head is not signed by a server key")
+	}
+
+	pk, err := key.ParsePublicKey(head.Key)
+	if err != nil {
+		return fmt.Errorf("failed to parse audit head key: %v", err)
+	}
+
+	if err := k.VerifyPayload(pk, head.message(), head.Signature); err != nil {
+		return fmt.Errorf("audit head signature is invalid: %v", err)
+	}
+
+	return nil
+}
+
+// ReadLog returns every entry of the audit log in dir, without verifying
+// them.
+func ReadLog(dir string) ([]Entry, error) {
+	f, err := os.Open(filepath.Join(dir, logFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %v", err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	err = readEntries(f, func(e *Entry) error {
+		entries = append(entries, *e)
+		return nil
+	})
+
+	return entries, err
+}
+
+// readEntries calls fn with each entry of the log read from r, in order.
+func readEntries(r io.Reader, fn func(*Entry) error) error {
+	var n int
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("failed to parse audit entry %d: %v", n, err)
+		}
+
+		if err := fn(&e); err != nil {
+			return err
+		}
+		n++
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read audit log: %v", err)
+	}
+
+	return nil
+}
+
+func readHead(dir string) (*Head, error) {
+	head := new(Head)
+
+	ok, err := store.ReadJSON(filepath.Join(dir, headFile), head)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit head: %v", err)
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	return head, nil
+}
@@ -0,0 +1,118 @@
+package audit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/joshvanl/go-whisper/pkg/key"
+)
+
+func newTestLog(t *testing.T) (string, *key.Key, func()) {
+	dir, err := ioutil.TempDir("", "go-whisper-audit")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	k, err := key.New(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return dir, k, func() { os.RemoveAll(dir) }
+}
+
+func Test_Verify(t *testing.T) {
+	dir, k, cleanup := newTestLog(t)
+	defer cleanup()
+
+	trusted := [][]byte{k.PublicKey()}
+
+	result, err := Verify(dir, k, trusted)
+	if err != nil || result.Entries != 0 {
+		t.Fatalf("expected empty log to verify, got=%+v err=%v", result, err)
+	}
+
+	// Entries recorded through separate Logs, as by separate processes,
+	// share one chain.
+	for i, event := range []string{EventRegistered, EventBadSignature, EventAdmin} {
+		e := Entry{Event: event, UID: "1", Detail: map[string]string{"n": string(rune('a' + i))}}
+		if err := Open(dir, k).Record(e); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	result, err = Verify(dir, k, trusted)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Entries != 3 || result.Head.Size != 3 || result.Unsigned != 0 {
+		t.Errorf("unexpected verify result: %+v", result)
+	}
+
+	if _, err := Verify(dir, k, nil); err == nil {
+		t.Errorf("expected error for head signed by untrusted key")
+	}
+
+	path := filepath.Join(dir, logFile)
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lines := bytes.SplitAfter(b, []byte("\n"))
+
+	for _, test := range []struct {
+		name     string
+		contents []byte
+		expErr   string
+	}{
+		{"modified", bytes.Replace(b, []byte(EventBadSignature), []byte(EventRateLimited), 1), "modified"},
+		{"truncated", bytes.Join(lines[:2], nil), "truncated"},
+		{"removed", append(append([]byte{}, lines[0]...), lines[2]...), "removed"},
+		{"emptied", nil, "truncated"},
+	} {
+		if err := ioutil.WriteFile(path, test.contents, 0600); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		_, err := Verify(dir, k, trusted)
+		if err == nil || !strings.Contains(err.Error(), test.expErr) {
+			t.Errorf("%s: expected error containing %q, got=%v", test.name, test.expErr, err)
+		}
+	}
+
+	// Entries after the head are reported rather than failing.
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	head, err := ioutil.ReadFile(filepath.Join(dir, headFile))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := Open(dir, k).Record(Entry{Event: EventAdmin}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, headFile), head, 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	result, err = Verify(dir, k, trusted)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Entries != 4 || result.Unsigned != 1 {
+		t.Errorf("expected one unsigned entry, got=%+v", result)
+	}
+
+	entries, err := ReadLog(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(entries) != 4 || entries[1].Event != EventBadSignature || entries[3].Seq != 3 {
+		t.Errorf("unexpected entries: %+v", entries)
+	}
+}
@@ -0,0 +1,267 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/key"
+	"github.com/joshvanl/go-whisper/pkg/store"
+)
+
+// A backup is a PEM block of type "GO-WHISPER BACKUP", encrypted as with
+// key.EncryptBlock, with a Backup-Version header. The sealed body is a JSON
+// backup holding every file in the client directory but logs, so it carries
+// the private key, config, contact keys and verification state.
+const (
+	backupType          = "GO-WHISPER BACKUP"
+	backupVersionHeader = "Backup-Version"
+
+	// BackupVersion is the version of backups written by this client.
+	BackupVersion = 1
+)
+
+var (
+	ErrBackupExists = errors.New("directory already holds a private key")
+)
+
+type backup struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	UID     uint64    `json:"uid"`
+
+	Files map[string][]byte `json:"files"`
+	// Checksums are the hex SHA-256 of each file.
+	Checksums map[string]string `json:"checksums"`
+}
+
+// BackupInfo describes a backup.
+type BackupInfo struct {
+	Version int
+	Created time.Time
+	UID     uint64
+	Files   int
+}
+
+// Backup returns the client directory encrypted under passphrase.
+func (c *Client) Backup(passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("backup passphrase must not be empty")
+	}
+
+	b := &backup{
+		Version:   BackupVersion,
+		Created:   time.Now(),
+		UID:       c.config.UID,
+		Files:     make(map[string][]byte),
+		Checksums: make(map[string]string),
+	}
+
+	err := filepath.Walk(c.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !backedUp(info) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(c.dir, path)
+		if err != nil {
+			return err
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		rel = filepath.ToSlash(rel)
+		b.Files[rel] = data
+		b.Checksums[rel] = checksum(data)
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client directory: %v", err)
+	}
+
+	plain, err := json.Marshal(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode backup: %v", err)
+	}
+
+	block, err := key.EncryptBlock(backupType, map[string]string{
+		backupVersionHeader: strconv.Itoa(BackupVersion),
+	}, plain, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt backup: %v", err)
+	}
+
+	return pem.EncodeToMemory(block), nil
+}
+
+// RestoreBackup writes the files of backup data into dir. It refuses to
+// overwrite an existing identity in dir unless force is set, in which case
+// client state in dir that the backup does not hold is removed, so none of
+// the replaced identity is left behind.
+func RestoreBackup(dir string, data, passphrase []byte, force bool) (*BackupInfo, error) {
+	b, err := readBackup(data, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	if !force {
+		exists, err := key.Exists(dir)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			return nil, ErrBackupExists
+		}
+	}
+
+	for rel, data := range b.Files {
+		// Backups written before logs were left out may still hold one.
+		if filepath.Ext(rel) == ".log" {
+			continue
+		}
+
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, fmt.Errorf("failed to create directory for %s: %v", rel, err)
+		}
+
+		if err := store.WriteFile(path, data); err != nil {
+			return nil, fmt.Errorf("failed to restore %s: %v", rel, err)
+		}
+	}
+
+	if force {
+		if err := removeUncovered(dir, b.Files); err != nil {
+			return nil, err
+		}
+	}
+
+	return b.info(), nil
+}
+
+// removeUncovered removes the files in dir that would be backed up but are
+// not in files.
+func removeUncovered(dir string, files map[string][]byte) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !backedUp(info) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if _, ok := files[filepath.ToSlash(rel)]; ok {
+			return nil
+		}
+
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove %s: %v", rel, err)
+		}
+
+		return nil
+	})
+}
+
+// backedUp reports whether the file is client state to be backed up.
+func backedUp(info os.FileInfo) bool {
+	if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), ".tmp-") {
+		return false
+	}
+
+	// Logs are not state, and restoring one would overwrite the log of the
+	// client being restored into.
+	return filepath.Ext(info.Name()) != ".log"
+}
+
+// ReadBackupInfo decrypts and checks backup data without restoring it.
+func ReadBackupInfo(data, passphrase []byte) (*BackupInfo, error) {
+	b, err := readBackup(data, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.info(), nil
+}
+
+func readBackup(data, passphrase []byte) (*backup, error) {
+	block, rest := pem.Decode(data)
+	if block == nil || block.Type != backupType {
+		return nil, errors.New("not a go-whisper backup")
+	}
+	if len(strings.TrimSpace(string(rest))) != 0 {
+		return nil, errors.New("unexpected data after backup")
+	}
+
+	version, err := strconv.Atoi(block.Headers[backupVersionHeader])
+	if err != nil {
+		return nil, fmt.Errorf("malformed backup version: %v", err)
+	}
+	if version > BackupVersion {
+		return nil, fmt.Errorf("backup version %d is newer than this client supports (%d)", version, BackupVersion)
+	}
+
+	plain, err := key.DecryptBlock(block, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt backup: %v", err)
+	}
+
+	b := new(backup)
+	if err := json.Unmarshal(plain, b); err != nil {
+		return nil, fmt.Errorf("failed to parse backup: %v", err)
+	}
+
+	if b.Version != version {
+		return nil, fmt.Errorf("backup version mismatch, header=%d contents=%d", version, b.Version)
+	}
+
+	if len(b.Files) != len(b.Checksums) {
+		return nil, errors.New("backup checksums do not cover every file")
+	}
+
+	for rel, data := range b.Files {
+		clean := filepath.ToSlash(filepath.Clean(filepath.FromSlash(rel)))
+		if clean != rel || filepath.IsAbs(rel) || strings.HasPrefix(rel, "../") || rel == ".." {
+			return nil, fmt.Errorf("backup holds an invalid path: %q", rel)
+		}
+
+		if b.Checksums[rel] != checksum(data) {
+			return nil, fmt.Errorf("backup file %s failed its integrity check", rel)
+		}
+	}
+
+	return b, nil
+}
+
+func (b *backup) info() *BackupInfo {
+	return &BackupInfo{
+		Version: b.Version,
+		Created: b.Created,
+		UID:     b.UID,
+		Files:   len(b.Files),
+	}
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"bytes"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/joshvanl/go-whisper/pkg/key"
+)
+
+func Test_BackupRestore(t *testing.T) {
+	c := newTestClient(t)
+
+	_, pk := generatePublicKey(t)
+	if _, err := c.acceptKey("42", [][]byte{pk}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(c.dir, "client.log"), []byte("old log"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := c.Backup([]byte("backup passphrase"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := ReadBackupInfo(data, []byte("wrong")); err == nil {
+		t.Errorf("expected error for wrong passphrase")
+	}
+
+	if _, err := RestoreBackup(c.dir, data, []byte("backup passphrase"), false); err != ErrBackupExists {
+		t.Errorf("expected ErrBackupExists, got=%v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "go-whisper-restore")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "client.log"), []byte("new log"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	info, err := RestoreBackup(dir, data, []byte("backup passphrase"), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Version != BackupVersion {
+		t.Errorf("unexpected backup version, exp=%d got=%d", BackupVersion, info.Version)
+	}
+
+	k, err := key.New(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(k.PublicKey(), c.key.PublicKey()) {
+		t.Errorf("expected restored key to match")
+	}
+
+	contact, err := ioutil.ReadFile(filepath.Join(dir, "uids", "42"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(contact) == 0 {
+		t.Errorf("expected contact key to be restored")
+	}
+
+	// Logs are left out of backups, so the restored directory keeps its own.
+	if log, err := ioutil.ReadFile(filepath.Join(dir, "client.log")); err != nil || string(log) != "new log" {
+		t.Errorf("expected log to be left alone, got=%q err=%v", log, err)
+	}
+
+	// Forcing a restore over another identity removes its state that the
+	// backup does not hold.
+	for _, rel := range []string{deviceFile, verifiedFile, filepath.Join("uids", "7")} {
+		if err := ioutil.WriteFile(filepath.Join(dir, rel), []byte("other"), 0600); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, err := RestoreBackup(dir, data, []byte("backup passphrase"), true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, rel := range []string{deviceFile, verifiedFile, filepath.Join("uids", "7")} {
+		if _, err := os.Stat(filepath.Join(dir, rel)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to be removed, got=%v", rel, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "uids", "42")); err != nil {
+		t.Errorf("expected contact key to be kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "client.log")); err != nil {
+		t.Errorf("expected log to be kept: %v", err)
+	}
+
+	// Tampering with the sealed body fails the integrity check.
+	block, _ := pem.Decode(data)
+	block.Bytes[len(block.Bytes)/2] ^= 0xff
+	if _, err := ReadBackupInfo(pem.EncodeToMemory(block), []byte("backup passphrase")); err == nil {
+		t.Errorf("expected error for tampered backup")
+	}
+
+	block, _ = pem.Decode(data)
+	block.Headers[backupVersionHeader] = "99"
+	if _, err := ReadBackupInfo(pem.EncodeToMemory(block), []byte("backup passphrase")); err == nil {
+		t.Errorf("expected error for unsupported backup version")
+	}
+}
@@ -1,15 +1,19 @@
 package client
 
 import (
-	"crypto/rsa"
+	"crypto"
 	"fmt"
 	"net"
 	"sort"
 
+	"github.com/sirupsen/logrus"
+
 	"github.com/joshvanl/go-whisper/pkg/config"
 	"github.com/joshvanl/go-whisper/pkg/connection"
 	"github.com/joshvanl/go-whisper/pkg/gui"
 	"github.com/joshvanl/go-whisper/pkg/key"
+	"github.com/joshvanl/go-whisper/pkg/logging"
+	"github.com/joshvanl/go-whisper/pkg/uid"
 )
 
 const (
@@ -23,34 +27,61 @@ type Client struct {
 
 	key      *key.Key
 	conn     *connection.Connection
-	serverpk *rsa.PublicKey
+	serverpk crypto.PublicKey
 
 	config *config.Config
 	g      *gui.GUI
+
+	// device is this client's device ID if it is a linked device of the
+	// account, rather than holding its identity key.
+	device string
+	// identity is the account's identity key on a linked device.
+	identity crypto.PublicKey
+
+	requestedUID uint64
+	invite       string
+
+	log *logrus.Entry
 }
 
-func New(addr, dir string) (*Client, error) {
+// New starts the GUI and loads the client's key and config. passphrase is
+// called if the private key is encrypted; it must not need the terminal, and
+// neither may log.
+func New(addr, dir string, passphrase key.PassphraseFunc, log *logrus.Entry) (*Client, error) {
 
-	g, err := gui.New()
+	g, err := gui.New(log)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initiate gui: %v", err)
 	}
 
-	g.Infof("Retrieving local key pair...")
-	k, err := key.New(dir)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read client key: %v", err)
-	}
+	return newClient(addr, dir, passphrase, g, log)
+}
+
+// NewHeadless returns a client that does not take over the terminal, for
+// one-shot subcommands.
+func NewHeadless(addr, dir string, passphrase key.PassphraseFunc, log *logrus.Entry) (*Client, error) {
+	return newClient(addr, dir, passphrase, nil, log)
+}
 
+func newClient(addr, dir string, passphrase key.PassphraseFunc, g *gui.GUI, log *logrus.Entry) (*Client, error) {
 	client := &Client{
 		dir: dir,
-		key: k,
 		g:   g,
+		log: log,
+	}
+
+	client.infof("Retrieving local key pair...")
+	k, err := key.NewWithPassphrase(dir, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client key: %v", err)
 	}
+	client.key = k
 
-	g.SetClient(client)
+	if g != nil {
+		g.SetClient(client)
+	}
 
-	g.Infof("Retrieving local client config...")
+	client.infof("Retrieving local client config...")
 	config, err := config.ReadConfig(dir)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config: %v", err)
@@ -58,11 +89,15 @@ func New(addr, dir string) (*Client, error) {
 	client.config = config
 	client.addr = client.config.Address
 
+	if err := client.readDevice(); err != nil {
+		return nil, err
+	}
+
 	if addr != "" {
 		client.addr = addr
 	}
 
-	g.Infof("Connecting to server...")
+	client.infof("Connecting to server...")
 
 	return client, nil
 }
@@ -73,28 +108,90 @@ func (c *Client) Close() {
 	}
 }
 
+// RequestUID asks the server for a specific uid when first registering. The
+// server will only honour it when configured to allow chosen uids.
+func (c *Client) RequestUID(uid uint64) {
+	c.requestedUID = uid
+}
+
+// Invite sets the invite code sent when first registering, for servers
+// where registration is invite only.
+func (c *Client) Invite(code string) {
+	c.invite = code
+}
+
 func (c *Client) Connect() error {
+	if err := c.dial(); err != nil {
+		return err
+	}
+
+	if err := c.Handshake(); err != nil {
+		return fmt.Errorf("failed to handshake with the server: %v", err)
+	}
+
+	c.log = c.log.WithField(logging.FieldUID, uid.Key(c.config.UID))
+	c.infof("Connection successful.")
+
+	if c.g != nil {
+		c.g.SetUid(c.config.UID)
+		c.g.DrawMenu()
+	}
+
+	return nil
+}
+
+func (c *Client) dial() error {
 	conn, err := net.Dial(network, c.addr)
 	if err != nil {
 		return fmt.Errorf("failed to connect to server: %v", err)
 	}
 
-	c.conn, err = connection.New(conn)
+	// Each connection's log lines share a session ID.
+	c.log = c.log.WithFields(logrus.Fields{
+		logging.FieldSession: logging.NewSessionID(),
+		logging.FieldAddress: c.addr,
+	})
+
+	c.conn, err = connection.NewWithLog(conn, c.log)
 	if err != nil {
 		return err
 	}
 
-	if err := c.Handshake(); err != nil {
-		return fmt.Errorf("failed to handshake with the server: %v", err)
-	}
+	go c.watch(c.conn, c.log)
 
-	c.g.Infof("Connection successful.")
+	return nil
+}
 
-	c.g.SetUid(c.config.UID)
+// watch tells the user when conn to the server is lost, rather than when
+// the next request fails.
+func (c *Client) watch(conn *connection.Connection, log *logrus.Entry) {
+	<-conn.Done()
+
+	var msg string
+	switch err := conn.Err(); err {
+	case connection.ErrClosed:
+		return
+	case connection.ErrTimeout:
+		msg = "Lost connection to the server: it stopped responding."
+	default:
+		msg = fmt.Sprintf("Lost connection to the server: %v", err)
+	}
 
-	c.g.DrawMenu()
+	log.Warnf(msg)
+	if c.g != nil {
+		c.g.Infof(msg)
+	}
+}
 
-	return nil
+func (c *Client) UID() uint64 {
+	return c.config.UID
+}
+
+func (c *Client) infof(msg string) {
+	c.log.Infof(msg)
+	if c.g != nil {
+		c.g.Infof(msg)
+	}
 }
 
 func (c *Client) Uids() []string {
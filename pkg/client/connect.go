@@ -1,14 +1,22 @@
 package client
 
 import (
-	"crypto/x509"
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"strconv"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/connection"
+	"github.com/joshvanl/go-whisper/pkg/key"
+	"github.com/joshvanl/go-whisper/pkg/logging"
+	"github.com/joshvanl/go-whisper/pkg/protocol"
+	"github.com/joshvanl/go-whisper/pkg/uid"
+	"github.com/joshvanl/go-whisper/pkg/username"
 )
 
-var (
-	MessageBreak = []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+const (
+	nonceSize = 16
 )
 
 func (c *Client) Handshake() error {
@@ -20,25 +28,57 @@ func (c *Client) Handshake() error {
 		return c.FirstConnection()
 	}
 
-	pk, err := c.key.ReadUidFile("0")
+	pinned, err := c.key.ReadUidFile("0")
 	if err != nil {
 		return fmt.Errorf("failed to read server public key from file: %v", err)
 	}
 
+	pk, err := c.followServerKey(pinned)
+	if err != nil {
+		return fmt.Errorf("failed to verify server key: %v", err)
+	}
+
 	c.serverpk = pk
 
 	return nil
 }
 
+// FirstConnection registers this client's key with the server, signing a
+// challenge issued by the server to prove it holds the key.
 func (c *Client) FirstConnection() error {
-	send := appendParams([]byte("first connection"), c.key.PublicKey())
+	if err := c.conn.Write(connection.Params([]byte("registration challenge"))); err != nil {
+		return fmt.Errorf("failed to request registration challenge: %v", err)
+	}
+
+	// The server key is not known yet, so the challenge is taken on trust;
+	// only the final response, which pins the key, is verified.
+	rec, _, err := c.conn.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read from connection: %v", err)
+	}
+	if len(rec) != 3 || string(rec[0]) != "challenge" {
+		return fmt.Errorf("unexpected registration challenge response: %s", rec[0])
+	}
+
+	send := connection.Params([]byte("first connection"), c.key.PublicKey())
+	send = connection.AppendParams(send, rec[1])
+	if c.requestedUID != 0 || c.invite != "" {
+		var requested []byte
+		if c.requestedUID != 0 {
+			requested = []byte(uid.Key(c.requestedUID))
+		}
+		send = connection.AppendParams(send, requested)
+	}
+	if c.invite != "" {
+		send = connection.AppendParams(send, []byte(c.invite))
+	}
 
 	signiture, err := c.key.SignMessage(send)
 	if err != nil {
 		return fmt.Errorf("failed to sign initial message: %v", err)
 	}
 
-	send = appendParams(send, signiture)
+	send = connection.AppendParams(send, signiture)
 	if err = c.conn.Write(send); err != nil {
 		return fmt.Errorf("failed to write first connection: %v", err)
 	}
@@ -48,12 +88,16 @@ func (c *Client) FirstConnection() error {
 		return fmt.Errorf("failed to read from connection: %v", err)
 	}
 
+	if err := responseError(rec); err != nil {
+		return err
+	}
+
 	if len(rec) != 3 {
 		return fmt.Errorf("unexpected number of response, exp=3 got=%d", len(rec))
 	}
 	uidB, pkB, sigB := rec[0], rec[1], rec[2]
 
-	pk, err := x509.ParsePKCS1PublicKey(pkB)
+	pk, err := key.ParsePublicKey(pkB)
 	if err != nil {
 		return fmt.Errorf("failed to parse server public key: %v", err)
 	}
@@ -79,26 +123,60 @@ func (c *Client) FirstConnection() error {
 	return nil
 }
 
-func (c *Client) QueryUID(uid string) (string, error) {
+// QueryUID looks up the public key of a uid, or of the uid holding a
+// username, and stores it locally.
+func (c *Client) QueryUID(query string) (string, error) {
+	n, err := uid.Parse(query)
+	if err != nil {
+		if username.Validate(query) != nil {
+			return "", errors.New("not a valid uid or username")
+		}
 
-	message := appendParams([]byte("uid query"), []byte(fmt.Sprintf("%v", c.config.UID)))
-	message = appendParams(message, []byte(uid))
-	signiture, err := c.key.SignMessage(message)
+		return c.queryUsername(username.Canonical(query))
+	}
+	id := uid.Key(n)
+
+	res, err := c.request("uid query", []byte(id), c.seenTreeSize())
+
+	// The contact has deleted their account so their key is gone.
+	if errors.Is(err, protocol.ErrDeletedUID) {
+		if err := c.key.RemoveUidFile(id); err != nil {
+			return "", err
+		}
+		if err := c.clearKeyChange(id); err != nil {
+			return "", err
+		}
+
+		return "", err
+	}
 	if err != nil {
-		return "", fmt.Errorf("failed to sign query message: %v", err)
+		return "", err
 	}
-	message = appendParams(message, signiture)
 
-	if err := c.conn.Write(message); err != nil {
-		return "", fmt.Errorf("failed to send uid query: %v", err)
+	if len(res) < 2 {
+		return "", errors.New(string(res[0]))
 	}
 
-	res, payload, err := c.conn.Read()
+	keys, err := c.verifyKeyProof(id, res[1:])
 	if err != nil {
 		return "", err
 	}
 
-	if err := c.key.VerifyPayload(c.serverpk, payload, res[len(res)-1]); err != nil {
+	change, err := c.acceptKey(id, keys)
+	if err != nil {
+		return "", err
+	}
+
+	if change == keyRotated || change == keyChanged {
+		return fmt.Sprintf("%s (%s)", res[0], change), nil
+	}
+
+	return string(res[0]), nil
+}
+
+func (c *Client) queryUsername(name string) (string, error) {
+	res, err := c.request("username query", []byte(name), c.seenTreeSize())
+	if err != nil {
 		return "", err
 	}
 
@@ -106,18 +184,110 @@ func (c *Client) QueryUID(uid string) (string, error) {
 		return "", errors.New(string(res[0]))
 	}
 
-	pk, err := x509.ParsePKCS1PublicKey(res[1])
+	n, err := uid.Parse(string(res[1]))
 	if err != nil {
-		return "", fmt.Errorf("failed to parse uid public key: %v", err)
+		return "", fmt.Errorf("server returned an invalid uid: %v", err)
 	}
 
-	if err := c.key.NewUidFile(uid, pk); err != nil {
-		return "", fmt.Errorf("failed to save new uid public key: %v", err)
+	keys, err := c.verifyKeyProof(uid.Key(n), res[2:])
+	if err != nil {
+		return "", err
 	}
 
-	return string(res[0]), nil
+	change, err := c.acceptKey(uid.Key(n), keys)
+	if err != nil {
+		return "", err
+	}
+
+	if change == keyRotated || change == keyChanged {
+		return fmt.Sprintf("%s: %s (%s)", res[0], uid.Format(n), change), nil
+	}
+
+	return fmt.Sprintf("%s: %s", res[0], uid.Format(n)), nil
+}
+
+// ClaimUsername asks the server to give this client name.
+func (c *Client) ClaimUsername(name string) error {
+	if err := username.Validate(name); err != nil {
+		return err
+	}
+
+	res, err := c.request("claim username", []byte(username.Canonical(name)))
+	if err != nil {
+		return err
+	}
+
+	if string(res[0]) != "username claimed" {
+		return errors.New(string(res[0]))
+	}
+
+	return nil
+}
+
+// DeleteAccount permanently removes this client's account from the server.
+// Local keys are kept, but the client will register a new uid the next time
+// it connects.
+func (c *Client) DeleteAccount() error {
+	res, err := c.request("delete account")
+	if err != nil {
+		return err
+	}
+
+	if string(res[0]) != "account deleted" {
+		return errors.New(string(res[0]))
+	}
+
+	c.config.UID = 0
+	if err := c.config.Write(); err != nil {
+		return fmt.Errorf("account deleted but failed to reset local config: %v", err)
+	}
+
+	return c.key.RemoveUidFile("0")
 }
 
-func appendParams(a, b []byte) []byte {
-	return append(append(a, MessageBreak...), b...)
+// request sends a command signed by this client, with the client's uid as
+// the first parameter, and returns the server's verified response without
+// its signature.
+func (c *Client) request(command string, params ...[]byte) ([][]byte, error) {
+	message := connection.Params([]byte(command), []byte(c.sender()))
+	for _, p := range params {
+		message = connection.AppendParams(message, p)
+	}
+
+	// The timestamp and nonce stop the server accepting this request again
+	// if it is captured and replayed.
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate request nonce: %v", err)
+	}
+	message = connection.AppendParams(message, []byte(strconv.FormatInt(time.Now().UnixNano(), 10)))
+	message = connection.AppendParams(message, nonce)
+
+	signiture, err := c.key.SignMessage(message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign %s message: %v", command, err)
+	}
+	message = connection.AppendParams(message, signiture)
+
+	if err := c.conn.Write(message); err != nil {
+		return nil, fmt.Errorf("failed to send %s: %v", command, err)
+	}
+
+	res, payload, err := c.conn.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.key.VerifyPayload(c.serverpk, payload, res[len(res)-1]); err != nil {
+		return nil, err
+	}
+
+	if err := responseError(res); err != nil {
+		c.log.WithField(logging.FieldCommand, command).WithError(err).Warnf("server refused request")
+		return nil, err
+	}
+
+	c.log.WithField(logging.FieldCommand, command).Debugf("request completed")
+
+	return res[:len(res)-1], nil
 }
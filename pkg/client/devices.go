@@ -0,0 +1,308 @@
+package client
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/connection"
+	"github.com/joshvanl/go-whisper/pkg/key"
+	"github.com/joshvanl/go-whisper/pkg/store"
+	"github.com/joshvanl/go-whisper/pkg/uid"
+)
+
+const (
+	deviceFile = "device.json"
+)
+
+// Device is a device linked to this client's account.
+type Device struct {
+	ID    string
+	Name  string
+	Key   []byte
+	Added time.Time
+}
+
+// linkedDevice is stored by a device linked to an account, naming which of
+// the account's devices it is and holding the account's identity key.
+type linkedDevice struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Identity []byte `json:"identity"`
+}
+
+// LinkDevice joins this client to an existing account as a new device.
+// showCode is given the link code and this device's key fingerprint, to be
+// entered and checked on a device already on the account. It blocks until
+// the link is approved or expires.
+func (c *Client) LinkDevice(name string, showCode func(code, fingerprint string)) error {
+	if c.config.UID != 0 {
+		return fmt.Errorf("this client is already registered as %s", uid.Format(c.config.UID))
+	}
+
+	if err := c.dial(); err != nil {
+		return err
+	}
+
+	send := connection.Params([]byte("link request"), c.key.PublicKey())
+	send = connection.AppendParams(send, []byte(name))
+
+	sig, err := c.key.SignMessage(send)
+	if err != nil {
+		return fmt.Errorf("failed to sign link request: %v", err)
+	}
+
+	if err := c.conn.Write(connection.AppendParams(send, sig)); err != nil {
+		return fmt.Errorf("failed to send link request: %v", err)
+	}
+
+	// The server key is not known until the link completes, so the code is
+	// only checked by it matching on both devices.
+	res, _, err := c.conn.Read()
+	if err != nil {
+		return err
+	}
+	if err := responseError(res); err != nil {
+		return err
+	}
+	if len(res) != 3 || string(res[0]) != "link code" {
+		return errors.New(string(res[0]))
+	}
+
+	fingerprint, err := key.Fingerprint("", c.key.Public())
+	if err != nil {
+		return err
+	}
+	showCode(string(res[1]), fingerprint)
+
+	res, payload, err := c.conn.Read()
+	if err != nil {
+		return err
+	}
+	if err := responseError(res); err != nil {
+		return err
+	}
+	if len(res) != 7 || string(res[0]) != "device linked" {
+		return errors.New(string(res[0]))
+	}
+
+	serverpk, err := key.ParsePublicKey(res[5])
+	if err != nil {
+		return fmt.Errorf("failed to parse server public key: %v", err)
+	}
+	if err := c.key.VerifyPayload(serverpk, payload, res[6]); err != nil {
+		return err
+	}
+
+	n, err := uid.Parse(string(res[1]))
+	if err != nil {
+		return fmt.Errorf("server returned an invalid uid: %v", err)
+	}
+
+	// The identity key must have approved this device, as contacts will
+	// check.
+	identity, err := key.ParsePublicKey(res[3])
+	if err != nil {
+		return fmt.Errorf("failed to parse identity public key: %v", err)
+	}
+	if err := c.key.VerifyPayload(identity, key.DeviceMessage(string(res[1]), c.key.PublicKey()), res[4]); err != nil {
+		return fmt.Errorf("failed to verify device approval: %v", err)
+	}
+
+	d := linkedDevice{ID: string(res[2]), Name: name, Identity: res[3]}
+	if err := store.WriteJSON(c.devicePath(), d); err != nil {
+		return fmt.Errorf("failed to write device: %v", err)
+	}
+	c.device = d.ID
+	c.identity = identity
+
+	c.config.UID = n
+	if err := c.config.Write(); err != nil {
+		return err
+	}
+
+	if err := c.key.NewUIDs(n); err != nil {
+		return err
+	}
+
+	if err := c.key.NewUidFile(uid.Key(uid.Server), serverpk); err != nil {
+		return err
+	}
+	c.serverpk = serverpk
+
+	return nil
+}
+
+// AddDevice approves the device showing code to join this account. confirm
+// is given the device's name and key fingerprint and must return whether to
+// link it.
+func (c *Client) AddDevice(code string, confirm func(name, fingerprint string) bool) (string, error) {
+	res, err := c.request("link device", []byte(code))
+	if err != nil {
+		return "", err
+	}
+
+	if string(res[0]) != "link pending" || len(res) != 3 {
+		return "", errors.New(string(res[0]))
+	}
+
+	pk, err := key.ParsePublicKey(res[1])
+	if err != nil {
+		return "", fmt.Errorf("failed to parse device public key: %v", err)
+	}
+
+	fingerprint, err := key.Fingerprint("", pk)
+	if err != nil {
+		return "", err
+	}
+
+	if !confirm(string(res[2]), fingerprint) {
+		return "", errors.New("device not approved")
+	}
+
+	approval, err := c.key.SignMessage(key.DeviceMessage(uid.Key(c.config.UID), res[1]))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign device approval: %v", err)
+	}
+
+	res, err = c.request("approve device", []byte(code), approval)
+	if err != nil {
+		return "", err
+	}
+
+	if string(res[0]) != "device added" || len(res) != 2 {
+		return "", errors.New(string(res[0]))
+	}
+
+	return string(res[1]), nil
+}
+
+// Devices lists the devices linked to this account.
+func (c *Client) Devices() ([]Device, error) {
+	res, err := c.request("list devices")
+	if err != nil {
+		return nil, err
+	}
+
+	if string(res[0]) != "devices" || len(res)%4 != 1 {
+		return nil, errors.New(string(res[0]))
+	}
+
+	var devices []Device
+	for i := 1; i < len(res); i += 4 {
+		added, err := strconv.ParseInt(string(res[i+3]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse device added time: %v", err)
+		}
+
+		devices = append(devices, Device{
+			ID:    string(res[i]),
+			Name:  string(res[i+1]),
+			Key:   res[i+2],
+			Added: time.Unix(added, 0),
+		})
+	}
+
+	return devices, nil
+}
+
+// RevokeDevice unlinks a device from this account.
+func (c *Client) RevokeDevice(device string) error {
+	res, err := c.request("revoke device", []byte(device))
+	if err != nil {
+		return err
+	}
+
+	if string(res[0]) != "device revoked" {
+		return errors.New(string(res[0]))
+	}
+
+	return nil
+}
+
+// DeviceKeys returns the keys of every device of the contact id, keyed by
+// device ID with "" for the identity key, for sending to all of them. Only
+// devices approved by the contact's pinned identity key are returned.
+func (c *Client) DeviceKeys(id string) (map[string]crypto.PublicKey, error) {
+	identity, err := c.ContactKey(id)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := c.request("uid devices", []byte(id))
+	if err != nil {
+		return nil, err
+	}
+
+	if string(res[0]) != "devices" || len(res)%3 != 1 {
+		return nil, errors.New(string(res[0]))
+	}
+
+	keys := map[string]crypto.PublicKey{"": identity}
+	for i := 1; i < len(res); i += 3 {
+		device, devicepk, approval := string(res[i]), res[i+1], res[i+2]
+
+		if err := c.key.VerifyPayload(identity, key.DeviceMessage(id, devicepk), approval); err != nil {
+			c.infof(fmt.Sprintf("Ignoring device %s of %s with an invalid approval.", device, id))
+			continue
+		}
+
+		pk, err := key.ParsePublicKey(devicepk)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse device public key: %v", err)
+		}
+
+		keys[device] = pk
+	}
+
+	return keys, nil
+}
+
+// sender is the uid sent with requests, naming the device if this client is
+// a linked device.
+func (c *Client) sender() string {
+	if c.device != "" {
+		return uid.Key(c.config.UID) + ":" + c.device
+	}
+
+	return uid.Key(c.config.UID)
+}
+
+// identityKey returns the account's identity key. That is this client's own
+// key, unless it is a linked device.
+func (c *Client) identityKey() (crypto.PublicKey, error) {
+	if c.device == "" {
+		return c.key.Public(), nil
+	}
+
+	if c.identity == nil {
+		return nil, errors.New("the account's identity key is not stored on this device, link it again")
+	}
+
+	return c.identity, nil
+}
+
+func (c *Client) readDevice() error {
+	var d linkedDevice
+	if _, err := store.ReadJSON(c.devicePath(), &d); err != nil {
+		return fmt.Errorf("failed to read device: %v", err)
+	}
+	c.device = d.ID
+
+	if len(d.Identity) > 0 {
+		identity, err := key.ParsePublicKey(d.Identity)
+		if err != nil {
+			return fmt.Errorf("failed to parse identity public key: %v", err)
+		}
+		c.identity = identity
+	}
+
+	return nil
+}
+
+func (c *Client) devicePath() string {
+	return filepath.Join(c.dir, deviceFile)
+}
@@ -0,0 +1,16 @@
+package client
+
+import (
+	"github.com/joshvanl/go-whisper/pkg/protocol"
+)
+
+// responseError returns the *protocol.Error held in res, or nil if res is not
+// an error response. Callers match it against the protocol sentinels, such
+// as protocol.ErrUnknownUID, with errors.Is.
+func responseError(res [][]byte) error {
+	if len(res) != 4 || string(res[0]) != "error" {
+		return nil
+	}
+
+	return &protocol.Error{Code: protocol.Code(res[1]), Message: string(res[2])}
+}
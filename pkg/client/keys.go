@@ -0,0 +1,235 @@
+package client
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"fmt"
+
+	"github.com/joshvanl/go-whisper/pkg/connection"
+	"github.com/joshvanl/go-whisper/pkg/key"
+	"github.com/joshvanl/go-whisper/pkg/uid"
+)
+
+// RotateKey replaces this client's identity key. The new key is signed by
+// the old one so contacts can follow the change without re-verifying.
+func (c *Client) RotateKey() error {
+	sk, err := c.key.GenerateKey()
+	if err != nil {
+		return err
+	}
+
+	newpk, err := key.MarshalPublicKey(sk.Public())
+	if err != nil {
+		return err
+	}
+	transition := key.TransitionMessage(uid.Key(c.config.UID), newpk)
+
+	oldSig, err := c.key.SignMessage(transition)
+	if err != nil {
+		return fmt.Errorf("failed to sign key transition with old key: %v", err)
+	}
+
+	newSig, err := key.SignWith(sk, transition)
+	if err != nil {
+		return fmt.Errorf("failed to sign key transition with new key: %v", err)
+	}
+
+	res, err := c.request("rotate key", newpk, oldSig, newSig)
+	if err != nil {
+		return err
+	}
+
+	if string(res[0]) != "key rotated" {
+		return errors.New(string(res[0]))
+	}
+
+	if err := c.key.ReplaceKey(sk); err != nil {
+		return fmt.Errorf("server accepted new key but failed to store it locally: %v", err)
+	}
+
+	return nil
+}
+
+type keyChange int
+
+const (
+	// keyNew is a uid we had no key stored for.
+	keyNew keyChange = iota
+	keyUnchanged
+	// keyRotated is a key reached from our stored key by signed transitions.
+	keyRotated
+	// keyChanged is a key with no signed path from our stored key.
+	keyChanged
+)
+
+func (k keyChange) String() string {
+	switch k {
+	case keyRotated:
+		return "key rotated"
+	case keyChanged:
+		return "WARNING: key changed, not trusted until accepted"
+	}
+
+	return ""
+}
+
+// acceptKey verifies and stores the key of id sent by the server. params is
+// the current public key followed by the old key and signature of every key
+// transition, oldest first. It reports how the key relates to the one pinned
+// for id; a changed key is held back until AcceptKeyChange.
+func (c *Client) acceptKey(id string, params [][]byte) (keyChange, error) {
+	if len(params) == 0 {
+		return keyNew, errors.New("no key parameters sent")
+	}
+
+	current := params[0]
+	pk, err := key.ParsePublicKey(current)
+	if err != nil {
+		return keyNew, fmt.Errorf("failed to parse uid public key: %v", err)
+	}
+
+	chain, err := c.verifyTransitions(id, params)
+	if err != nil {
+		return keyNew, err
+	}
+
+	change := keyNew
+	if stored, err := c.key.ReadUidFile(id); err == nil {
+		change = keyChanged
+
+		if equalKeys(stored, current) {
+			change = keyUnchanged
+		}
+
+		for _, old := range chain {
+			if equalKeys(stored, old) {
+				change = keyRotated
+			}
+		}
+	}
+
+	if change == keyChanged {
+		if err := c.setKeyChange(id, current); err != nil {
+			return keyNew, err
+		}
+
+		return change, nil
+	}
+
+	if err := c.key.NewUidFile(id, pk); err != nil {
+		return keyNew, fmt.Errorf("failed to save new uid public key: %v", err)
+	}
+
+	if err := c.clearKeyChange(id); err != nil {
+		return keyNew, err
+	}
+
+	return change, nil
+}
+
+// verifyTransitions checks each old key in params signed the move to the key
+// after it, returning the old keys, oldest first. params is laid out as for
+// acceptKey.
+func (c *Client) verifyTransitions(id string, params [][]byte) ([][]byte, error) {
+	if len(params) == 0 || len(params)%2 != 1 {
+		return nil, fmt.Errorf("unexpected number of key parameters: %d", len(params))
+	}
+
+	chain := [][]byte{}
+	for i := 1; i < len(params); i += 2 {
+		old, sig := params[i], params[i+1]
+
+		next := params[0]
+		if i+2 < len(params) {
+			next = params[i+2]
+		}
+
+		oldpk, err := key.ParsePublicKey(old)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse old public key: %v", err)
+		}
+
+		if err := c.key.VerifyPayload(oldpk, key.TransitionMessage(id, next), sig); err != nil {
+			return nil, fmt.Errorf("server sent an invalid key transition for %s: %v", id, err)
+		}
+
+		chain = append(chain, old)
+	}
+
+	return chain, nil
+}
+
+// followServerKey asks the server for its key and transitions, and moves the
+// pinned server key to the current one if it is reached from the pinned key
+// by signed transitions.
+func (c *Client) followServerKey(pinned crypto.PublicKey) (crypto.PublicKey, error) {
+	if err := c.conn.Write(connection.Params([]byte("server key"))); err != nil {
+		return nil, fmt.Errorf("failed to send server key request: %v", err)
+	}
+
+	res, payload, err := c.conn.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := responseError(res); err != nil {
+		return nil, err
+	}
+
+	if len(res) < 3 || string(res[0]) != "server key" {
+		return nil, errors.New("unexpected response to server key request")
+	}
+	params := res[1 : len(res)-1]
+
+	if equalKeys(pinned, params[0]) {
+		if err := c.key.VerifyPayload(pinned, payload, res[len(res)-1]); err != nil {
+			return nil, err
+		}
+
+		return pinned, nil
+	}
+
+	chain, err := c.verifyTransitions(uid.Key(uid.Server), params)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the transitions from the pinned key onwards matter; anything
+	// before it was signed by keys we never trusted.
+	trusted := false
+	for _, old := range chain {
+		if equalKeys(pinned, old) {
+			trusted = true
+		}
+	}
+	if !trusted {
+		return nil, errors.New("server key changed with no signed transition from the pinned key")
+	}
+
+	pk, err := key.ParsePublicKey(params[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse server public key: %v", err)
+	}
+
+	if err := c.key.VerifyPayload(pk, payload, res[len(res)-1]); err != nil {
+		return nil, err
+	}
+
+	if err := c.key.NewUidFile(uid.Key(uid.Server), pk); err != nil {
+		return nil, fmt.Errorf("failed to save new server public key: %v", err)
+	}
+
+	c.infof("Server key rotated, following signed transition.")
+
+	return pk, nil
+}
+
+func equalKeys(pk crypto.PublicKey, b []byte) bool {
+	pkB, err := key.MarshalPublicKey(pk)
+	if err != nil {
+		return false
+	}
+
+	return bytes.Equal(pkB, b)
+}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/key"
+	"github.com/joshvanl/go-whisper/pkg/store"
+)
+
+const (
+	keyChangesFile = "key_changes.json"
+)
+
+var (
+	ErrKeyChanged = errors.New("contact's key has changed, accept the new key before sending to them")
+)
+
+// The first key seen for a contact is pinned in uids/<uid> and only replaced
+// by a signed rotation from it. Any other key the server returns is held in
+// key_changes.json until the user accepts it.
+type pendingKey struct {
+	Key  []byte    `json:"key"`
+	Time time.Time `json:"time"`
+}
+
+// ContactKey returns the pinned key of id for encrypting to. It returns
+// ErrKeyChanged while the server is offering a key the user has not accepted.
+func (c *Client) ContactKey(id string) (crypto.PublicKey, error) {
+	changed, err := c.KeyChanged(id)
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		return nil, ErrKeyChanged
+	}
+
+	pk, err := c.key.ReadUidFile(id)
+	if err != nil {
+		return nil, fmt.Errorf("no key stored for %s: %v", id, err)
+	}
+
+	return pk, nil
+}
+
+// KeyChanged reports whether the server has returned a key for id that does
+// not match the pinned one.
+func (c *Client) KeyChanged(id string) (bool, error) {
+	pending, err := c.readKeyChanges()
+	if err != nil {
+		return false, err
+	}
+
+	_, ok := pending[id]
+	return ok, nil
+}
+
+// AcceptKeyChange pins the changed key the server returned for id in place of
+// the old one. The contact is no longer verified.
+func (c *Client) AcceptKeyChange(id string) error {
+	pending, err := c.readKeyChanges()
+	if err != nil {
+		return err
+	}
+
+	p, ok := pending[id]
+	if !ok {
+		return fmt.Errorf("no key change to accept for %s", id)
+	}
+
+	pk, err := key.ParsePublicKey(p.Key)
+	if err != nil {
+		return err
+	}
+
+	if err := c.key.NewUidFile(id, pk); err != nil {
+		return fmt.Errorf("failed to save new uid public key: %v", err)
+	}
+
+	return c.clearKeyChange(id)
+}
+
+func (c *Client) setKeyChange(id string, pk []byte) error {
+	pending, err := c.readKeyChanges()
+	if err != nil {
+		return err
+	}
+
+	if p, ok := pending[id]; ok && string(p.Key) == string(pk) {
+		return nil
+	}
+
+	pending[id] = pendingKey{
+		Key:  pk,
+		Time: time.Now(),
+	}
+
+	return c.writeKeyChanges(pending)
+}
+
+func (c *Client) clearKeyChange(id string) error {
+	pending, err := c.readKeyChanges()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := pending[id]; !ok {
+		return nil
+	}
+	delete(pending, id)
+
+	return c.writeKeyChanges(pending)
+}
+
+func (c *Client) readKeyChanges() (map[string]pendingKey, error) {
+	pending := make(map[string]pendingKey)
+	if _, err := store.ReadJSON(c.keyChangesPath(), &pending); err != nil {
+		return nil, fmt.Errorf("failed to read key changes: %v", err)
+	}
+
+	return pending, nil
+}
+
+func (c *Client) writeKeyChanges(pending map[string]pendingKey) error {
+	if err := store.WriteJSON(c.keyChangesPath(), pending); err != nil {
+		return fmt.Errorf("failed to write key changes: %v", err)
+	}
+
+	return nil
+}
+
+func (c *Client) keyChangesPath() string {
+	return filepath.Join(c.dir, keyChangesFile)
+}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"crypto"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/joshvanl/go-whisper/pkg/config"
+	"github.com/joshvanl/go-whisper/pkg/key"
+)
+
+func newTestClient(t *testing.T) *Client {
+	dir, err := ioutil.TempDir("", "go-whisper-client")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	k, err := key.New(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := k.NewUIDs(0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return &Client{dir: dir, key: k, config: new(config.Config)}
+}
+
+func generatePublicKey(t *testing.T) (crypto.Signer, []byte) {
+	sk, err := key.GenerateType(key.TypeEd25519)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	b, err := key.MarshalPublicKey(sk.Public())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return sk, b
+}
+
+func Test_PinKey(t *testing.T) {
+	c := newTestClient(t)
+
+	first, firstpk := generatePublicKey(t)
+	if change, err := c.acceptKey("42", [][]byte{firstpk}); err != nil || change != keyNew {
+		t.Fatalf("expected new key, got=%v err=%v", change, err)
+	}
+
+	// A key with no signed path from the pinned one is held back.
+	_, otherpk := generatePublicKey(t)
+	if change, err := c.acceptKey("42", [][]byte{otherpk}); err != nil || change != keyChanged {
+		t.Fatalf("expected changed key, got=%v err=%v", change, err)
+	}
+
+	if _, err := c.ContactKey("42"); err != ErrKeyChanged {
+		t.Errorf("expected ErrKeyChanged, got=%v", err)
+	}
+	if err := c.MarkVerified("42"); err != ErrKeyChanged {
+		t.Errorf("expected ErrKeyChanged, got=%v", err)
+	}
+
+	pk, err := c.key.ReadUidFile("42")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !equalKeys(pk, firstpk) {
+		t.Errorf("expected pinned key to be kept after a key change")
+	}
+
+	// A signed rotation from the pinned key is followed, and replaces the
+	// unaccepted change.
+	_, rotatedpk := generatePublicKey(t)
+	sig, err := key.SignWith(first, key.TransitionMessage("42", rotatedpk))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if change, err := c.acceptKey("42", [][]byte{rotatedpk, firstpk, sig}); err != nil || change != keyRotated {
+		t.Fatalf("expected rotated key, got=%v err=%v", change, err)
+	}
+
+	pk, err = c.ContactKey("42")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !equalKeys(pk, rotatedpk) {
+		t.Errorf("expected rotated key to be pinned")
+	}
+
+	// Accepting a change pins the new key.
+	if _, err := c.acceptKey("42", [][]byte{otherpk}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.AcceptKeyChange("42"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pk, err = c.ContactKey("42")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !equalKeys(pk, otherpk) {
+		t.Errorf("expected accepted key to be pinned")
+	}
+}
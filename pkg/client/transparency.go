@@ -0,0 +1,170 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/store"
+	"github.com/joshvanl/go-whisper/pkg/transparency"
+)
+
+const (
+	treeHeadFile = "treehead.json"
+
+	// treeHeadParams is the size, root, time and signature of a tree head.
+	treeHeadParams = 4
+	// keyProofParams is a tree head followed by the leaf index, inclusion
+	// proof and consistency proof sent before a uid's key.
+	keyProofParams = treeHeadParams + 3
+)
+
+// TreeHead fetches the server's signed key transparency tree head, checks it
+// is consistent with the last one this client saw, and stores it.
+func (c *Client) TreeHead() (*transparency.TreeHead, error) {
+	res, err := c.request("tree head", c.seenTreeSize())
+	if err != nil {
+		return nil, err
+	}
+
+	if string(res[0]) != "tree head" || len(res) != treeHeadParams+2 {
+		return nil, errors.New(string(res[0]))
+	}
+
+	head, err := c.verifyTreeHead(res[1:treeHeadParams+1], res[treeHeadParams+1])
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.saveTreeHead(head); err != nil {
+		return nil, err
+	}
+
+	return head, nil
+}
+
+// verifyKeyProof checks the tree head and proofs the server sent with the
+// key of id, returning the key parameters that follow them. The tree head is
+// stored once the proofs are verified.
+func (c *Client) verifyKeyProof(id string, params [][]byte) ([][]byte, error) {
+	if len(params) < keyProofParams+1 {
+		return nil, fmt.Errorf("unexpected number of key parameters: %d", len(params))
+	}
+
+	head, err := c.verifyTreeHead(params[:treeHeadParams], params[treeHeadParams+2])
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := strconv.ParseUint(string(params[treeHeadParams]), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse leaf index: %v", err)
+	}
+
+	inclusion, err := transparency.DecodeProof(params[treeHeadParams+1])
+	if err != nil {
+		return nil, err
+	}
+
+	keys := params[keyProofParams:]
+	leaf := transparency.LeafHash(transparency.Binding(id, keys[0]))
+	if err := transparency.VerifyInclusion(leaf, index, head.Size, inclusion, head.Root); err != nil {
+		return nil, fmt.Errorf("key of %s is not in the server's transparency log: %v", id, err)
+	}
+
+	if err := c.saveTreeHead(head); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// verifyTreeHead checks the server's signature over a tree head sent as
+// size, root, time and signature, and that the log it describes extends the
+// last tree head this client saw.
+func (c *Client) verifyTreeHead(params [][]byte, consistency []byte) (*transparency.TreeHead, error) {
+	size, err := strconv.ParseUint(string(params[0]), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse tree size: %v", err)
+	}
+
+	nanos, err := strconv.ParseInt(string(params[2]), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse tree head time: %v", err)
+	}
+
+	head := &transparency.TreeHead{
+		Size:      size,
+		Root:      params[1],
+		Time:      time.Unix(0, nanos),
+		Signature: params[3],
+	}
+
+	if err := c.key.VerifyPayload(c.serverpk, head.Message(), head.Signature); err != nil {
+		return nil, fmt.Errorf("invalid tree head signature: %v", err)
+	}
+
+	seen, err := c.readTreeHead()
+	if err != nil {
+		return nil, err
+	}
+	if seen == nil {
+		return head, nil
+	}
+
+	proof, err := transparency.DecodeProof(consistency)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := transparency.VerifyConsistency(seen.Size, head.Size, seen.Root, head.Root, proof); err != nil {
+		return nil, fmt.Errorf("server's transparency log is not consistent with the one seen before: %v", err)
+	}
+
+	return head, nil
+}
+
+func (c *Client) seenTreeSize() []byte {
+	var size uint64
+	if seen, err := c.readTreeHead(); err == nil && seen != nil {
+		size = seen.Size
+	}
+
+	return []byte(strconv.FormatUint(size, 10))
+}
+
+func (c *Client) readTreeHead() (*transparency.TreeHead, error) {
+	head := new(transparency.TreeHead)
+	ok, err := store.ReadJSON(c.treeHeadPath(), head)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tree head: %v", err)
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	return head, nil
+}
+
+// saveTreeHead stores head if it is newer than the one already stored.
+func (c *Client) saveTreeHead(head *transparency.TreeHead) error {
+	seen, err := c.readTreeHead()
+	if err != nil {
+		return err
+	}
+	if seen != nil && seen.Size >= head.Size {
+		return nil
+	}
+
+	if err := store.WriteJSON(c.treeHeadPath(), head); err != nil {
+		return fmt.Errorf("failed to write tree head: %v", err)
+	}
+
+	return nil
+}
+
+func (c *Client) treeHeadPath() string {
+	return filepath.Join(c.dir, treeHeadFile)
+}
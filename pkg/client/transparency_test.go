@@ -0,0 +1,128 @@
+package client
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/key"
+	"github.com/joshvanl/go-whisper/pkg/registry"
+	"github.com/joshvanl/go-whisper/pkg/transparency"
+	"github.com/joshvanl/go-whisper/pkg/uid"
+)
+
+// testKeyProofParams encodes proof as the server sends it, signing the tree head
+// with the client's own key standing in for the server's.
+func testKeyProofParams(t *testing.T, c *Client, proof *registry.Proof) [][]byte {
+	head := &transparency.TreeHead{Size: proof.Size, Root: proof.Root, Time: time.Now()}
+	sig, err := c.key.SignMessage(head.Message())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return [][]byte{
+		[]byte(strconv.FormatUint(head.Size, 10)),
+		head.Root,
+		[]byte(strconv.FormatInt(head.Time.UnixNano(), 10)),
+		sig,
+		[]byte(strconv.FormatUint(proof.Index, 10)),
+		transparency.EncodeProof(proof.Inclusion),
+		transparency.EncodeProof(proof.Consistency),
+		proof.Key,
+	}
+}
+
+func Test_VerifyKeyProof(t *testing.T) {
+	c := newTestClient(t)
+	c.serverpk = c.key.Public()
+
+	alloc, err := uid.NewAllocator(uid.StrategySequential)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	server := newTestClient(t)
+	r, err := registry.New(server.dir, server.key, alloc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, pk := generatePublicKey(t)
+	parsed, err := key.ParsePublicKey(pk)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	id, err := r.Register(parsed, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		proof, err := r.Proof(id, parseSeen(t, c))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		keys, err := c.verifyKeyProof(id, testKeyProofParams(t, c, proof))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(keys[0]) != string(pk) {
+			t.Errorf("expected key params to follow the proof")
+		}
+
+		if _, err := r.Register(parsed, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// A key missing from the log is rejected.
+	proof, err := r.Proof(id, parseSeen(t, c))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	params := testKeyProofParams(t, c, proof)
+	_, params[len(params)-1] = generatePublicKey(t)
+	if _, err := c.verifyKeyProof(id, params); err == nil {
+		t.Errorf("expected error for key not in the log")
+	}
+
+	// A log that does not extend the stored tree head is rejected.
+	forkedAlloc, err := uid.NewAllocator(uid.StrategySequential)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	forked := newTestClient(t)
+	fr, err := registry.New(forked.dir, forked.key, forkedAlloc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	other, _ := generatePublicKey(t)
+	if forkedID, err := fr.Register(other.Public(), 0); err != nil || forkedID != id {
+		t.Fatalf("expected forked log to register %s, got=%s err=%v", id, forkedID, err)
+	}
+	for i := 0; i < 8; i++ {
+		if _, err := fr.Register(parsed, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	forkedProof, err := fr.Proof(id, parseSeen(t, c))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := c.verifyKeyProof(id, testKeyProofParams(t, c, forkedProof)); err == nil {
+		t.Errorf("expected error for inconsistent log")
+	}
+}
+
+func parseSeen(t *testing.T, c *Client) uint64 {
+	n, err := strconv.ParseUint(string(c.seenTreeSize()), 10, 64)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return n
+}
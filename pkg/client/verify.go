@@ -0,0 +1,102 @@
+package client
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/key"
+	"github.com/joshvanl/go-whisper/pkg/store"
+	"github.com/joshvanl/go-whisper/pkg/uid"
+)
+
+const (
+	verifiedFile = "verified.json"
+)
+
+// verification records that the user compared safety numbers with a contact.
+// It holds the key that was verified so a later key change is not reported as
+// verified.
+type verification struct {
+	Key  []byte    `json:"key"`
+	Time time.Time `json:"time"`
+}
+
+// SafetyNumber returns the safety number of this account and the contact
+// id, using the key stored locally for id. It is the same on every device of
+// the account.
+func (c *Client) SafetyNumber(id string) (string, error) {
+	pk, err := c.key.ReadUidFile(id)
+	if err != nil {
+		return "", fmt.Errorf("no key stored for %s, add them as a contact first: %v", id, err)
+	}
+
+	identity, err := c.identityKey()
+	if err != nil {
+		return "", err
+	}
+
+	return key.SafetyNumber(uid.Key(c.config.UID), identity, id, pk)
+}
+
+// MarkVerified records the contact id's current key as verified.
+func (c *Client) MarkVerified(id string) error {
+	pk, err := c.ContactKey(id)
+	if err != nil {
+		return err
+	}
+
+	b, err := key.MarshalPublicKey(pk)
+	if err != nil {
+		return err
+	}
+
+	verified, err := c.readVerified()
+	if err != nil {
+		return err
+	}
+
+	verified[id] = verification{
+		Key:  b,
+		Time: time.Now(),
+	}
+
+	if err := store.WriteJSON(c.verifiedPath(), verified); err != nil {
+		return fmt.Errorf("failed to write verified contacts: %v", err)
+	}
+
+	return nil
+}
+
+// Verified reports whether the contact id's current key has been verified.
+func (c *Client) Verified(id string) (bool, error) {
+	verified, err := c.readVerified()
+	if err != nil {
+		return false, err
+	}
+
+	v, ok := verified[id]
+	if !ok {
+		return false, nil
+	}
+
+	pk, err := c.key.ReadUidFile(id)
+	if err != nil {
+		return false, nil
+	}
+
+	return equalKeys(pk, v.Key), nil
+}
+
+func (c *Client) readVerified() (map[string]verification, error) {
+	verified := make(map[string]verification)
+	if _, err := store.ReadJSON(c.verifiedPath(), &verified); err != nil {
+		return nil, fmt.Errorf("failed to read verified contacts: %v", err)
+	}
+
+	return verified, nil
+}
+
+func (c *Client) verifiedPath() string {
+	return filepath.Join(c.dir, verifiedFile)
+}
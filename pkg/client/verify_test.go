@@ -0,0 +1,53 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/joshvanl/go-whisper/pkg/store"
+)
+
+func Test_SafetyNumberLinkedDevice(t *testing.T) {
+	primary := newTestClient(t)
+	device := newTestClient(t)
+	primary.config.UID = 42
+	device.config.UID = 42
+
+	contact, _ := generatePublicKey(t)
+	for _, c := range []*Client{primary, device} {
+		if err := c.key.NewUidFile("7", contact.Public()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// A linked device without the identity key can not give a safety
+	// number, rather than giving one for its own key.
+	if err := store.WriteJSON(device.devicePath(), linkedDevice{ID: "1", Name: "laptop"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := device.readDevice(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := device.SafetyNumber("7"); err == nil {
+		t.Errorf("expected error without the identity key")
+	}
+
+	d := linkedDevice{ID: "1", Name: "laptop", Identity: primary.key.PublicKey()}
+	if err := store.WriteJSON(device.devicePath(), d); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := device.readDevice(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	exp, err := primary.SafetyNumber("7")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := device.SafetyNumber("7")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != exp {
+		t.Errorf("expected linked device to give the account's safety number, exp=%s got=%s", exp, got)
+	}
+}
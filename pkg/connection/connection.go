@@ -6,46 +6,190 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"math/big"
 	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
 
 	dhke "github.com/joshvanl/go-whisper/pkg/diffie_hellman"
+	"github.com/joshvanl/go-whisper/pkg/logging"
+)
+
+const (
+	// MaxMessageSize is the largest encrypted message either side will
+	// accept.
+	MaxMessageSize = 1 << 20
+
+	// maxIntermediateSize bounds the peer's Diffie Hellman intermediate.
+	maxIntermediateSize = 2048
 )
 
 var (
-	MessageBreak = []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	// ErrClosed is returned by Read once Close has been called.
+	ErrClosed = errors.New("connection closed")
+	// ErrTimeout is returned by Read when nothing, not even a keepalive,
+	// arrived from the peer within the idle timeout.
+	ErrTimeout = errors.New("peer did not respond within idle timeout")
+
+	// Keepalives are single parameter messages, which are never requests
+	// or responses since those are signed.
+	keepalivePing = []byte("keepalive ping")
+	keepalivePong = []byte("keepalive pong")
 )
 
+// Timeouts bound how long a Connection waits on its peer. A zero value
+// disables that timeout.
+type Timeouts struct {
+	// Handshake bounds the whole key exchange in New.
+	Handshake time.Duration
+	// Idle is how long to wait for any message, including keepalives,
+	// before the peer is taken to be dead.
+	Idle time.Duration
+	// Write bounds sending a single message.
+	Write time.Duration
+	// Keepalive is how often a ping is sent. It should be well under the
+	// peer's idle timeout.
+	Keepalive time.Duration
+}
+
+// DefaultTimeouts are used by New and NewWithLog.
+var DefaultTimeouts = Timeouts{
+	Handshake: 10 * time.Second,
+	Idle:      90 * time.Second,
+	Write:     10 * time.Second,
+	Keepalive: 30 * time.Second,
+}
+
+// HandshakeError is returned by New when the key exchange fails. Reason is
+// a short fixed description, such as for metrics: "init", "send",
+// "receive", "closed" or "timeout".
+type HandshakeError struct {
+	Reason string
+	Err    error
+}
+
+func (e *HandshakeError) Error() string {
+	return e.Err.Error()
+}
+
+// handshakeError wraps err from the handshake stage reason, telling closed
+// and timed out connections apart from other failures.
+func handshakeError(reason, message string, err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		reason = "closed"
+	}
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		reason = "timeout"
+	}
+
+	return &HandshakeError{Reason: reason, Err: fmt.Errorf("%s: %v", message, err)}
+}
+
 type Connection struct {
 	conn net.Conn
 	dhke *dhke.DiffieHellman
 	sk   []byte
+
+	log      *logrus.Entry
+	timeouts Timeouts
+
+	writeMu sync.Mutex
+
+	// messages are passed from readLoop to Read, and closed once it stops.
+	messages chan message
+	// done is closed when the connection stops, after err is set.
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error
+}
+
+type message struct {
+	decoded [][]byte
+	payload []byte
 }
 
 func New(conn net.Conn) (*Connection, error) {
-	d, err := dhke.New()
+	return NewWithLog(conn, logging.Discard())
+}
+
+// NewWithLog is New, logging the connection's failures to log at debug
+// level. Callers still get every error returned.
+func NewWithLog(conn net.Conn, log *logrus.Entry) (*Connection, error) {
+	return NewWithTimeouts(conn, log, DefaultTimeouts)
+}
+
+// NewWithTimeouts is NewWithLog with timeouts other than DefaultTimeouts.
+func NewWithTimeouts(conn net.Conn, log *logrus.Entry, timeouts Timeouts) (*Connection, error) {
+	if timeouts.Handshake > 0 {
+		if err := conn.SetDeadline(time.Now().Add(timeouts.Handshake)); err != nil {
+			return nil, handshakeError("init", "failed to set handshake deadline", err)
+		}
+	}
+
+	c, err := handshake(conn)
 	if err != nil {
-		return nil, fmt.Errorf("failed to init Diffie Hellman: %v", err)
+		log.WithError(err).Debugf("key exchange failed")
+		return nil, err
 	}
 
-	if _, err := conn.Write(d.Intermediate().Bytes()); err != nil {
-		return nil, fmt.Errorf("failed to send Diffie Hellman intermediate: %v", err)
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return nil, handshakeError("init", "failed to clear handshake deadline", err)
+	}
+
+	c.log = log
+	c.timeouts = timeouts
+	c.messages = make(chan message)
+	c.done = make(chan struct{})
+	log.Debugf("key exchange completed")
+
+	go c.readLoop()
+	if timeouts.Keepalive > 0 {
+		go c.keepalive()
 	}
 
-	in := make([]byte, 2048)
-	n, err := conn.Read(in)
+	return c, nil
+}
+
+func handshake(conn net.Conn) (*Connection, error) {
+	d, err := dhke.New()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read Diffie Hellman intermediate: %v", err)
+		return nil, handshakeError("init", "failed to init Diffie Hellman", err)
+	}
+
+	// The intermediates are framed by their length, as messages are, so
+	// reading the peer's never takes in the message that follows it.
+	out := d.Intermediate().Bytes()
+	frame := make([]byte, 4, 4+len(out))
+	binary.BigEndian.PutUint32(frame, uint32(len(out)))
+	if _, err := conn.Write(append(frame, out...)); err != nil {
+		return nil, handshakeError("send", "failed to send Diffie Hellman intermediate", err)
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, handshakeError("receive", "failed to read Diffie Hellman intermediate", err)
+	}
+
+	length := binary.BigEndian.Uint32(header)
+	if length == 0 || length > maxIntermediateSize {
+		return nil, handshakeError("receive", "failed to read Diffie Hellman intermediate", fmt.Errorf("invalid length: %d bytes", length))
+	}
+
+	in := make([]byte, length)
+	if _, err := io.ReadFull(conn, in); err != nil {
+		return nil, handshakeError("receive", "failed to read Diffie Hellman intermediate", err)
 	}
-	in = in[:n]
 
 	k := d.CalcSharedSecret(new(big.Int).SetBytes(in))
 	sha := sha256.New()
 	if _, err := sha.Write(k.Bytes()); err != nil {
-		return nil, fmt.Errorf("failed to sha256 shared secret: %v", err)
+		return nil, handshakeError("init", "failed to sha256 shared secret", err)
 	}
 
 	sk := sha.Sum(nil)
@@ -57,24 +201,136 @@ func New(conn net.Conn) (*Connection, error) {
 	}, nil
 }
 
+// Read returns the next message from the peer. Once the connection has
+// stopped it returns the reason: io.EOF if the peer closed it, ErrTimeout if
+// the peer went quiet, ErrClosed after Close, or the failure reading.
 func (c *Connection) Read() (decoded [][]byte, payload []byte, err error) {
-	buff := make([]byte, 4096)
+	m, ok := <-c.messages
+	if !ok {
+		return nil, nil, c.err
+	}
 
-	n, err := c.conn.Read(buff)
-	if err != nil {
+	return m.decoded, m.payload, nil
+}
+
+// Done is closed once the connection has stopped, such as when the peer is
+// found dead. Err then gives the reason.
+func (c *Connection) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the connection stopped, or nil while it is open.
+func (c *Connection) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+// readLoop reads messages until the connection fails, answering keepalives
+// and passing everything else to Read. Reading continues while a request is
+// being handled, so a busy peer is not mistaken for a dead one.
+func (c *Connection) readLoop() {
+	defer close(c.messages)
+
+	for {
+		if c.timeouts.Idle > 0 {
+			if err := c.conn.SetReadDeadline(time.Now().Add(c.timeouts.Idle)); err != nil {
+				c.stop(err)
+				return
+			}
+		}
+
+		decoded, payload, err := c.readMessage()
+		if err != nil {
+			if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+				c.log.Debugf("no message from peer in %s", c.timeouts.Idle)
+				err = ErrTimeout
+			}
+			c.stop(err)
+			return
+		}
+
+		if len(decoded) == 1 && bytes.Equal(decoded[0], keepalivePing) {
+			if err := c.Write(Params(keepalivePong)); err != nil {
+				c.stop(err)
+				return
+			}
+			continue
+		}
+		if len(decoded) == 1 && bytes.Equal(decoded[0], keepalivePong) {
+			continue
+		}
+
+		select {
+		case c.messages <- message{decoded: decoded, payload: payload}:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// keepalive pings the peer so its idle timeout does not expire while
+// neither side has anything to send.
+func (c *Connection) keepalive() {
+	ticker := time.NewTicker(c.timeouts.Keepalive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.Write(Params(keepalivePing)); err != nil {
+				c.log.WithError(err).Debugf("failed to send keepalive")
+				c.stop(err)
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// stop records why the connection stopped, if it is the first reason, and
+// closes it.
+func (c *Connection) stop(err error) {
+	c.closeOnce.Do(func() {
+		c.err = err
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// readMessage reads one message. Messages are framed by a 4 byte big endian
+// length so a message is never split across, or merged with, TCP reads.
+func (c *Connection) readMessage() (decoded [][]byte, payload []byte, err error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(c.conn, header); err != nil {
+		return nil, nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header)
+	if length > MaxMessageSize {
+		c.log.Debugf("refused %d byte message", length)
+		return nil, nil, fmt.Errorf("message too large: %d bytes", length)
+	}
+
+	buff := make([]byte, length)
+	if _, err := io.ReadFull(c.conn, buff); err != nil {
 		return nil, nil, err
 	}
-	buff = buff[:n]
 
 	buff, err = c.decrypt(buff)
 	if err != nil {
+		c.log.WithError(err).Debugf("failed to decrypt %d byte message", length)
 		return nil, nil, fmt.Errorf("failed to decrypt cipher: %v", err)
 	}
 
-	decoded = c.decodeMessage(buff)
-	payload = decoded[0]
-	for _, b := range decoded[1 : len(decoded)-1] {
-		payload = appendParams(payload, b)
+	decoded, payload, err = decodeParams(buff)
+	if err != nil {
+		c.log.WithError(err).Debugf("failed to decode %d byte message", length)
+		return nil, nil, err
 	}
 
 	return decoded, payload, nil
@@ -86,15 +342,43 @@ func (c *Connection) Write(b []byte) error {
 		return fmt.Errorf("failed to encrypt message: %v", err)
 	}
 
-	if _, err := c.conn.Write(b); err != nil {
+	if len(b) > MaxMessageSize {
+		c.log.Debugf("refused to send %d byte message", len(b))
+		return fmt.Errorf("message too large: %d bytes", len(b))
+	}
+
+	frame := make([]byte, 4, 4+len(b))
+	binary.BigEndian.PutUint32(frame, uint32(len(b)))
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.timeouts.Write > 0 {
+		if err := c.conn.SetWriteDeadline(time.Now().Add(c.timeouts.Write)); err != nil {
+			return err
+		}
+	}
+
+	if _, err := c.conn.Write(append(frame, b...)); err != nil {
+		// A peer that stopped reading is as good as dead, and a partly
+		// written frame can not be recovered from.
+		if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+			c.stop(err)
+		}
 		return err
 	}
 
 	return nil
 }
 
-func (c *Connection) decodeMessage(d []byte) [][]byte {
-	return bytes.Split(d, MessageBreak)
+func (c *Connection) Close() error {
+	c.stop(ErrClosed)
+	return nil
+}
+
+// RemoteAddr is the address of the other end of the connection.
+func (c *Connection) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
 }
 
 func (c *Connection) encypt(text []byte) ([]byte, error) {
@@ -126,6 +410,11 @@ func (c *Connection) decrypt(text []byte) ([]byte, error) {
 		return nil, errors.New("blocksize must be multipe of decoded message length")
 	}
 
+	// An IV followed by at least one block of padding.
+	if len(text) < 2*aes.BlockSize {
+		return nil, errors.New("message too short")
+	}
+
 	iv := text[:aes.BlockSize]
 	msg := text[aes.BlockSize:]
 
@@ -157,6 +446,50 @@ func (c *Connection) unpad(src []byte) ([]byte, error) {
 	return src[:(length - unpadding)], nil
 }
 
-func appendParams(a, b []byte) []byte {
-	return append(append(a, MessageBreak...), b...)
+// Params encodes params as a message. Each parameter is preceded by its 4
+// byte big endian length, so parameters may hold any bytes.
+func Params(params ...[]byte) []byte {
+	return AppendParams(nil, params...)
+}
+
+// AppendParams appends params to the message m.
+func AppendParams(m []byte, params ...[]byte) []byte {
+	for _, p := range params {
+		var length [4]byte
+		binary.BigEndian.PutUint32(length[:], uint32(len(p)))
+		m = append(append(m, length[:]...), p...)
+	}
+
+	return m
+}
+
+// decodeParams splits message m into its parameters. payload is the
+// encoding of every parameter but the last, which is what a trailing
+// signature is over, or the only parameter of a single parameter message.
+func decodeParams(m []byte) (params [][]byte, payload []byte, err error) {
+	var last int
+	for i := 0; i < len(m); {
+		if len(m)-i < 4 {
+			return nil, nil, errors.New("malformed message: truncated parameter length")
+		}
+
+		length := binary.BigEndian.Uint32(m[i:])
+		if uint64(length) > uint64(len(m)-i-4) {
+			return nil, nil, fmt.Errorf("malformed message: parameter of %d bytes overruns message", length)
+		}
+
+		last = i
+		i += 4
+		params = append(params, m[i:i+int(length)])
+		i += int(length)
+	}
+
+	switch len(params) {
+	case 0:
+		return nil, nil, errors.New("malformed message: no parameters")
+	case 1:
+		return params, params[0], nil
+	default:
+		return params, m[:last], nil
+	}
 }
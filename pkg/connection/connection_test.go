@@ -0,0 +1,258 @@
+package connection
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	dhke "github.com/joshvanl/go-whisper/pkg/diffie_hellman"
+	"github.com/joshvanl/go-whisper/pkg/logging"
+)
+
+func Test_Params(t *testing.T) {
+	// Parameters ending in, or made of, zero bytes, as signatures, keys and
+	// nonces may be, and empty ones are decoded whole.
+	params := [][]byte{
+		[]byte("command"),
+		{1, 2, 0},
+		{0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		{},
+		{0, 3},
+		[]byte("signature\x00"),
+	}
+
+	decoded, payload, err := decodeParams(Params(params...))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(decoded) != len(params) {
+		t.Fatalf("unexpected number of parameters, exp=%d got=%d", len(params), len(decoded))
+	}
+	for i := range params {
+		if !bytes.Equal(decoded[i], params[i]) {
+			t.Errorf("unexpected parameter %d, exp=%v got=%v", i, params[i], decoded[i])
+		}
+	}
+
+	// The payload is what the trailing signature is over.
+	if exp := Params(params[:len(params)-1]...); !bytes.Equal(payload, exp) {
+		t.Errorf("unexpected payload, exp=%v got=%v", exp, payload)
+	}
+
+	// AppendParams builds the same message a parameter at a time.
+	var m []byte
+	for _, p := range params {
+		m = AppendParams(m, p)
+	}
+	if !bytes.Equal(m, Params(params...)) {
+		t.Errorf("expected AppendParams to match Params")
+	}
+
+	decoded, payload, err = decodeParams(Params([]byte("only")))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(decoded) != 1 || string(payload) != "only" {
+		t.Errorf("unexpected single parameter message, got=%q payload=%q", decoded, payload)
+	}
+}
+
+func Test_DecodeParamsMalformed(t *testing.T) {
+	for name, m := range map[string][]byte{
+		"empty":            {},
+		"truncated length": {0, 0, 1},
+		"overrun":          append(Params([]byte("command")), 0, 0, 0, 9, 1),
+	} {
+		if _, _, err := decodeParams(m); err == nil {
+			t.Errorf("%s: expected error decoding malformed message", name)
+		}
+	}
+}
+
+// pair returns both ends of a connection over loopback, the dialing end
+// using dialer and the accepting end accepter.
+func pair(t *testing.T, dialer, accepter Timeouts) (*Connection, *Connection) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer ln.Close()
+
+	type result struct {
+		conn *Connection
+		err  error
+	}
+	accepted := make(chan result, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			accepted <- result{err: err}
+			return
+		}
+
+		conn, err := NewWithTimeouts(c, logging.Discard(), accepter)
+		accepted <- result{conn, err}
+	}()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	a, err := NewWithTimeouts(c, logging.Discard(), dialer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r := <-accepted
+	if r.err != nil {
+		t.Fatalf("unexpected error: %v", r.err)
+	}
+
+	return a, r.conn
+}
+
+func Test_Keepalive(t *testing.T) {
+	timeouts := Timeouts{Idle: 200 * time.Millisecond, Keepalive: 50 * time.Millisecond}
+
+	a, b := pair(t, timeouts, timeouts)
+	defer a.Close()
+	defer b.Close()
+
+	// Neither side sends anything for longer than the idle timeout, but
+	// keepalives hold the connection open and are not returned by Read.
+	time.Sleep(3 * timeouts.Idle)
+
+	if err := a.Write(Params([]byte("hello"), []byte("sig"))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	decoded, _, err := b.Read()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(decoded) != 2 || string(decoded[0]) != "hello" {
+		t.Errorf("unexpected message: %q", decoded)
+	}
+}
+
+func Test_IdleTimeout(t *testing.T) {
+	// The dialing end never pings, as if it had silently died.
+	a, b := pair(t, Timeouts{}, Timeouts{Idle: 100 * time.Millisecond})
+	defer a.Close()
+
+	select {
+	case <-b.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected connection to stop after idle timeout")
+	}
+
+	if _, _, err := b.Read(); err != ErrTimeout {
+		t.Errorf("expected ErrTimeout, got=%v", err)
+	}
+	if err := b.Err(); err != ErrTimeout {
+		t.Errorf("expected ErrTimeout, got=%v", err)
+	}
+}
+
+func Test_Close(t *testing.T) {
+	a, b := pair(t, Timeouts{}, Timeouts{})
+
+	if err := b.Err(); err != nil {
+		t.Errorf("unexpected error on open connection: %v", err)
+	}
+
+	a.Close()
+	if _, _, err := a.Read(); err != ErrClosed {
+		t.Errorf("expected ErrClosed, got=%v", err)
+	}
+
+	// The peer sees the connection close.
+	if _, _, err := b.Read(); err == nil {
+		t.Errorf("expected error reading closed connection")
+	}
+}
+
+func Test_HandshakeTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer ln.Close()
+
+	// The peer accepts but never takes part in the key exchange.
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		time.Sleep(time.Second)
+	}()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer c.Close()
+
+	_, err = NewWithTimeouts(c, logging.Discard(), Timeouts{Handshake: 100 * time.Millisecond})
+	herr, ok := err.(*HandshakeError)
+	if !ok || herr.Reason != "timeout" {
+		t.Errorf("expected handshake timeout, got=%v", err)
+	}
+}
+
+func Test_HandshakeFramed(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer ln.Close()
+
+	// The peer sends its intermediate and the start of a message in a single
+	// write, which the key exchange must leave unread.
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		d, err := dhke.New()
+		if err != nil {
+			return
+		}
+		out := d.Intermediate().Bytes()
+		frame := make([]byte, 4)
+		binary.BigEndian.PutUint32(frame, uint32(len(out)))
+		frame = append(append(frame, out...), "after"...)
+		if _, err := c.Write(frame); err != nil {
+			return
+		}
+		io.Copy(ioutil.Discard, c)
+	}()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer c.Close()
+
+	if _, err := handshake(c); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	after := make([]byte, 5)
+	if _, err := io.ReadFull(c, after); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(after) != "after" {
+		t.Errorf("expected handshake to leave the following bytes, got=%q", after)
+	}
+}
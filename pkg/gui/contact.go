@@ -2,9 +2,12 @@ package gui
 
 import (
 	"errors"
-	"strconv"
 
 	"github.com/nsf/termbox-go"
+
+	"github.com/joshvanl/go-whisper/pkg/protocol"
+	"github.com/joshvanl/go-whisper/pkg/uid"
+	"github.com/joshvanl/go-whisper/pkg/username"
 )
 
 type Contact struct {
@@ -34,7 +37,7 @@ func newContact(gui *GUI, stream chan rune, key chan termbox.Key, stopCh chan st
 
 func (c *Contact) printNewContact() {
 	w, h := termbox.Size()
-	headStr := "Please enter new user UID:"
+	headStr := "Please enter new user UID or username:"
 	c.gui.drawText(headStr, (w-stringLength(headStr))/2, h/2-3, FG, BG)
 
 	srtX, srtY := w/2-15, h/2-1
@@ -93,7 +96,8 @@ func (c *Contact) listenToSteam() {
 
 				res, err := c.enterUid()
 				if err != nil {
-					c.gui.drawText(err.Error(), c.startX-1, c.cursorY+4, FG, termbox.ColorRed)
+					c.gui.logError(err, "failed to look up contact")
+					c.gui.drawText(errorText(err), c.startX-1, c.cursorY+4, FG, termbox.ColorRed)
 					break
 				}
 
@@ -129,11 +133,23 @@ func (c *Contact) clearBoxString(clear int) string {
 
 func (c *Contact) enterUid() (string, error) {
 
-	if _, err := strconv.Atoi(c.text); err != nil || len(c.text) != 11 {
-		return "", errors.New("UIDs must be 11 digits.")
+	// Anything that doesn't parse as a UID is looked up as a username.
+	query := c.text
+	n, err := uid.Parse(c.text)
+	isUid := err == nil
+	if isUid {
+		query = uid.Key(n)
+	} else if username.Validate(c.text) != nil {
+		return "", errors.New("Not a valid UID or username.")
 	}
 
-	res, err := c.gui.client.QueryUID(c.text)
+	res, err := c.gui.client.QueryUID(query)
+
+	// UIDs issued before check digits were added have none, so they are
+	// still looked up, and a bad checksum only explains an unknown UID.
+	if errors.Is(err, protocol.ErrUnknownUID) && isUid && !uid.Valid(n) {
+		return "", errors.New("UID checksum does not match, check for typos.")
+	}
 	if err != nil {
 		return "", err
 	}
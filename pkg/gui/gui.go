@@ -7,8 +7,11 @@ import (
 
 	"github.com/mattn/go-runewidth"
 	"github.com/nsf/termbox-go"
+	"github.com/sirupsen/logrus"
 
 	"github.com/joshvanl/go-whisper/pkg/interfaces"
+	"github.com/joshvanl/go-whisper/pkg/protocol"
+	"github.com/joshvanl/go-whisper/pkg/uid"
 )
 
 const (
@@ -23,6 +26,7 @@ var (
 		"Chats",
 		"New Contact",
 		"New Message",
+		"Verify Contact",
 	}
 )
 
@@ -42,7 +46,11 @@ type GUI struct {
 	menu    *Menu
 	contact *Contact
 	newMsg  *NewMsg
+	verify  *Verify
 	client  interfaces.Client
+
+	// log records errors shown on screen, which are lost once redrawn.
+	log *logrus.Entry
 }
 
 type Menu struct {
@@ -51,7 +59,7 @@ type Menu struct {
 	page     int
 }
 
-func New() (*GUI, error) {
+func New(log *logrus.Entry) (*GUI, error) {
 	if err := termbox.Init(); err != nil {
 		return nil, fmt.Errorf("failed to init GUI: %v", err)
 	}
@@ -64,6 +72,7 @@ func New() (*GUI, error) {
 		keys:     make(chan termbox.Key),
 		mu:       new(sync.Mutex),
 		stopPage: make(chan struct{}),
+		log:      log,
 	}
 
 	g.menu = &Menu{
@@ -103,17 +112,17 @@ func (g *GUI) DrawMenu() {
 	g.fill(0, SepY, w, 1, termbox.Cell{Ch: '-'})
 
 	yy := 5
-	for _, uid := range g.client.Uids() {
-		g.drawText(fmt.Sprintf("%s", uid), 1, yy, termbox.ColorCyan, termbox.ColorBlack)
-		yy++
-	}
+	for _, id := range g.client.Uids() {
+		fg, bg := termbox.ColorCyan, termbox.ColorBlack
+		if changed, err := g.client.KeyChanged(id); err != nil || changed {
+			fg, bg = termbox.ColorWhite, termbox.ColorRed
+		}
 
-	uid := fmt.Sprintf("%v", g.uid)
-	for len(uid) != 11 {
-		uid = fmt.Sprintf("0%s", uid)
+		g.drawText(formatUid(id), 1, yy, fg, bg)
+		yy++
 	}
 
-	pageStr := fmt.Sprintf("%s uid[%s]", g.menu.options[g.menu.page], uid)
+	pageStr := fmt.Sprintf("%s uid[%s]", g.menu.options[g.menu.page], uid.Format(g.uid))
 	g.drawText(pageStr, w-stringLength(pageStr)-1, 2, FG, termbox.ColorMagenta)
 
 	x := SepX + 1
@@ -163,6 +172,11 @@ func (g *GUI) Infof(msg string) {
 	g.Print(msg)
 }
 
+// logError records an error shown to the user.
+func (g *GUI) logError(err error, msg string) {
+	g.log.WithError(err).Warnf(msg)
+}
+
 func (g *GUI) fill(x, y, w, h int, cell termbox.Cell) {
 	for ly := 0; ly < h; ly++ {
 		for lx := 0; lx < w; lx++ {
@@ -234,6 +248,15 @@ func (g *GUI) catchKeyboard() {
 					g.newMsg.printNewMessage()
 					break
 
+				case 3:
+					g.enterMode = true
+					g.initMenu = false
+					g.menu.page = 3
+					g.DrawMenu()
+					g.verify = newVerify(g, g.stream, g.keys, g.stopPage)
+					g.verify.printVerify()
+					break
+
 				}
 
 				break
@@ -274,6 +297,26 @@ func stringLength(msg string) (x int) {
 	return x
 }
 
+// formatUid returns the display form of a stored uid.
+func formatUid(id string) string {
+	n, err := uid.Parse(id)
+	if err != nil {
+		return id
+	}
+
+	return uid.Format(n)
+}
+
+// errorText returns how err is shown to the user, describing server error
+// responses by their code rather than the server's message.
+func errorText(err error) string {
+	if perr, ok := err.(*protocol.Error); ok {
+		return perr.Description()
+	}
+
+	return err.Error()
+}
+
 func (g *GUI) SetUid(uid uint64) {
 	g.uid = uid
 }
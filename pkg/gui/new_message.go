@@ -54,10 +54,25 @@ func (c *NewMsg) printNewMessage() {
 			bg = termbox.ColorRed
 		}
 
-		c.gui.drawText(uid, (w-stringLength(headStr))/4, yy, fg, bg)
+		c.gui.drawText(formatUid(uid), (w-stringLength(headStr))/4, yy, fg, bg)
 		yy++
 	}
 
+	if len(c.uids) == 0 {
+		return
+	}
+
+	if changed, err := c.gui.client.KeyChanged(c.uids[c.selected]); err != nil || changed {
+		warning := "WARNING: the key of " + formatUid(c.uids[c.selected]) + " has changed. Messages will not be sent until you accept it under Verify Contact."
+		if err != nil {
+			c.gui.logError(err, "failed to check contact key")
+			warning = err.Error()
+		}
+
+		c.gui.fill(SepX+1, yy+1, w-SepX-1, 1, termbox.Cell{Ch: ' ', Bg: termbox.ColorRed})
+		c.gui.drawText(warning, (w-stringLength(headStr))/4, yy+1, termbox.ColorWhite|termbox.AttrBold, termbox.ColorRed)
+	}
+
 }
 
 func (n *NewMsg) listenToSteam() {
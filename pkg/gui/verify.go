@@ -0,0 +1,171 @@
+package gui
+
+import (
+	"strings"
+
+	"github.com/nsf/termbox-go"
+	"github.com/skip2/go-qrcode"
+
+	"github.com/joshvanl/go-whisper/pkg/key"
+)
+
+type Verify struct {
+	gui      *GUI
+	uids     []string
+	selected int
+	shown    bool
+	status   string
+	statusBG termbox.Attribute
+
+	stream chan rune
+	key    chan termbox.Key
+	stopCh chan struct{}
+}
+
+func newVerify(gui *GUI, stream chan rune, key chan termbox.Key, stopCh chan struct{}) *Verify {
+	verify := &Verify{
+		stream: stream,
+		key:    key,
+		stopCh: stopCh,
+		gui:    gui,
+		uids:   gui.client.Uids(),
+	}
+
+	verify.listenToSteam()
+
+	return verify
+}
+
+func (v *Verify) printVerify() {
+	termbox.Flush()
+	termbox.Clear(FG, BG)
+	v.gui.DrawMenu()
+
+	w, h := termbox.Size()
+	x := SepX + 3
+	headStr := "Select contact to verify. TAB shows the safety number, v marks them verified, a accepts a changed key."
+	v.gui.drawText(headStr, x, SepY+2, FG, BG)
+
+	yy := SepY + 4
+	for s, id := range v.uids {
+		fg := termbox.ColorCyan
+		bg := termbox.ColorBlack
+		if s == v.selected {
+			fg = termbox.ColorDefault
+			bg = termbox.ColorRed
+		}
+
+		label := formatUid(id)
+		if changed, err := v.gui.client.KeyChanged(id); err == nil && changed {
+			label += " (KEY CHANGED)"
+		} else if ok, err := v.gui.client.Verified(id); err == nil && ok {
+			label += " (verified)"
+		}
+
+		v.gui.drawText(label, x, yy, fg, bg)
+		yy++
+	}
+
+	if v.status != "" {
+		v.gui.drawText(v.status, x, yy+1, FG, v.statusBG)
+	}
+
+	if !v.shown || len(v.uids) == 0 {
+		return
+	}
+
+	number, err := v.gui.client.SafetyNumber(v.uids[v.selected])
+	if err != nil {
+		v.gui.logError(err, "failed to compute safety number")
+		v.gui.drawText(err.Error(), x, yy+1, FG, termbox.ColorRed)
+		return
+	}
+
+	yy += 3
+	for _, line := range strings.Split(key.FormatSafetyNumber(number), "\n") {
+		v.gui.drawText(line, x, yy, FG, BG)
+		yy++
+	}
+
+	qr, err := qrcode.New(number, qrcode.Medium)
+	if err != nil {
+		v.gui.logError(err, "failed to draw safety number")
+		v.gui.drawText(err.Error(), x, yy+1, FG, termbox.ColorRed)
+		return
+	}
+
+	yy++
+	for _, line := range strings.Split(strings.TrimRight(qr.ToSmallString(false), "\n"), "\n") {
+		if yy >= h || x+stringLength(line) > w {
+			break
+		}
+		v.gui.drawText(line, x, yy, FG, BG)
+		yy++
+	}
+}
+
+func (v *Verify) listenToSteam() {
+	listen := func() bool {
+		select {
+		case ch := <-v.stream:
+			if ch == 'v' && v.shown && len(v.uids) > 0 {
+				v.status, v.statusBG = formatUid(v.uids[v.selected])+" marked as verified.", termbox.ColorCyan
+				if err := v.gui.client.MarkVerified(v.uids[v.selected]); err != nil {
+					v.gui.logError(err, "failed to mark contact verified")
+					v.status, v.statusBG = err.Error(), termbox.ColorRed
+				}
+				v.printVerify()
+			}
+
+			if ch == 'a' && len(v.uids) > 0 {
+				v.status, v.statusBG = "Accepted new key of "+formatUid(v.uids[v.selected])+", compare safety numbers again.", termbox.ColorCyan
+				if err := v.gui.client.AcceptKeyChange(v.uids[v.selected]); err != nil {
+					v.gui.logError(err, "failed to accept key change")
+					v.status, v.statusBG = err.Error(), termbox.ColorRed
+				}
+				v.shown = true
+				v.printVerify()
+			}
+
+			break
+
+		case key := <-v.key:
+			if len(v.uids) == 0 {
+				break
+			}
+
+			if key == termbox.KeyArrowUp {
+				v.selected--
+				if v.selected < 0 {
+					v.selected = len(v.uids) - 1
+				}
+				v.shown, v.status = false, ""
+
+			} else if key == termbox.KeyArrowDown {
+				v.selected = (v.selected + 1) % len(v.uids)
+				v.shown, v.status = false, ""
+
+			} else if key == termbox.KeyTab {
+				v.shown = true
+			}
+
+			v.printVerify()
+
+			break
+
+		case <-v.stopCh:
+			return false
+
+		}
+
+		return true
+	}
+
+	go func() {
+		for {
+			if !listen() {
+				break
+			}
+		}
+	}()
+}
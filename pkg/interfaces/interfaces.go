@@ -5,4 +5,9 @@ type Client interface {
 	FirstConnection() error
 	QueryUID(uid string) (string, error)
 	Uids() []string
+	SafetyNumber(uid string) (string, error)
+	MarkVerified(uid string) error
+	Verified(uid string) (bool, error)
+	KeyChanged(uid string) (bool, error)
+	AcceptKeyChange(uid string) error
 }
@@ -0,0 +1,252 @@
+package key
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// An encrypted private key is stored as a PEM block of type
+// "ENCRYPTED PRIVATE KEY" with the headers:
+//
+//	Key-Type:   rsa | ed25519
+//	KDF:        argon2id
+//	KDF-Params: t=<passes>,m=<memory KiB>,p=<threads>
+//	Salt:       <hex, 16 bytes>
+//	Cipher:     AES-256-GCM
+//	Nonce:      <hex, 12 bytes>
+//
+// The block body is the GCM sealed private key (PKCS1 for RSA, PKCS8 for
+// Ed25519), using the 32 byte argon2id derivation of the passphrase and salt
+// as the AES key. Unencrypted keys keep their plain block type and are still
+// read.
+const (
+	encryptedPrivateKeyType = "ENCRYPTED PRIVATE KEY"
+	privateKeyType          = "RSA PRIVATE KEY"
+
+	kdfArgon2id = "argon2id"
+	cipherGCM   = "AES-256-GCM"
+
+	argonTime    = 3
+	argonMemory  = 64 * 1024
+	argonThreads = 4
+	argonKeyLen  = 32
+	saltLen      = 16
+
+	// The largest KDF parameters accepted when decrypting, so a crafted
+	// key file or backup can not make the KDF take all memory or run
+	// forever.
+	maxArgonTime    = 10
+	maxArgonMemory  = 1024 * 1024
+	maxArgonThreads = 16
+)
+
+var (
+	ErrPassphraseRequired = errors.New("private key is encrypted and no passphrase was given")
+	ErrWrongPassphrase    = errors.New("wrong passphrase for private key")
+	ErrDecrypt            = errors.New("wrong passphrase or corrupted data")
+)
+
+// PassphraseFunc is asked for the passphrase when an encrypted private key
+// is read.
+type PassphraseFunc func() ([]byte, error)
+
+// Exists reports whether a private key is stored in dir.
+func Exists(dir string) (bool, error) {
+	_, err := os.Stat(fmt.Sprintf("%s/%s", dir, privateKeyFile))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check for private key: %v", err)
+	}
+
+	return true, nil
+}
+
+// Encrypted reports whether the private key stored in dir is encrypted. A
+// missing key is not encrypted.
+func Encrypted(dir string) (bool, error) {
+	path := fmt.Sprintf("%s/%s", dir, privateKeyFile)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return false, nil
+	}
+
+	block, err := readPemFile(path)
+	if err != nil {
+		return false, err
+	}
+
+	return block.Type == encryptedPrivateKeyType, nil
+}
+
+// SetPassphrase rewrites the private key file encrypted under passphrase. An
+// empty passphrase stores the key unencrypted.
+func (k *Key) SetPassphrase(passphrase []byte) error {
+	k.passphrase = passphrase
+
+	if err := k.writePrivateKey(k.sk); err != nil {
+		return fmt.Errorf("failed to write private key to file: %v", err)
+	}
+
+	return nil
+}
+
+func encryptPemBlock(plain *pem.Block, passphrase []byte) (*pem.Block, error) {
+	return EncryptBlock(encryptedPrivateKeyType, map[string]string{
+		keyTypeHeader: string(blockKeyType(plain)),
+	}, plain.Bytes, passphrase)
+}
+
+func decryptPemBlock(block *pem.Block, passphrase []byte) ([]byte, error) {
+	der, err := DecryptBlock(block, passphrase)
+	if err == ErrDecrypt {
+		return nil, ErrWrongPassphrase
+	}
+
+	return der, err
+}
+
+// EncryptBlock seals plain under passphrase into a PEM block of blockType,
+// adding the KDF and cipher headers described above to headers.
+func EncryptBlock(blockType string, headers map[string]string, plain, passphrase []byte) (*pem.Block, error) {
+	salt := make([]byte, saltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %v", err)
+	}
+
+	gcm, err := newGCM(passphrase, salt, argonTime, argonMemory, argonThreads)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+
+	h := make(map[string]string)
+	for k, v := range headers {
+		h[k] = v
+	}
+	h["KDF"] = kdfArgon2id
+	h["KDF-Params"] = fmt.Sprintf("t=%d,m=%d,p=%d", argonTime, argonMemory, argonThreads)
+	h["Salt"] = hex.EncodeToString(salt)
+	h["Cipher"] = cipherGCM
+	h["Nonce"] = hex.EncodeToString(nonce)
+
+	return &pem.Block{
+		Type:    blockType,
+		Headers: h,
+		Bytes:   gcm.Seal(nil, nonce, plain, nil),
+	}, nil
+}
+
+// DecryptBlock opens a block written by EncryptBlock, returning ErrDecrypt
+// if the passphrase is wrong or the block has been tampered with.
+func DecryptBlock(block *pem.Block, passphrase []byte) ([]byte, error) {
+	if kdf := block.Headers["KDF"]; kdf != kdfArgon2id {
+		return nil, fmt.Errorf("unsupported private key KDF: %q", kdf)
+	}
+	if c := block.Headers["Cipher"]; c != cipherGCM {
+		return nil, fmt.Errorf("unsupported private key cipher: %q", c)
+	}
+
+	var t, m uint32
+	var p uint8
+	for _, param := range strings.Split(block.Headers["KDF-Params"], ",") {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("malformed KDF-Params header: %q", block.Headers["KDF-Params"])
+		}
+
+		n, err := strconv.ParseUint(kv[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("malformed KDF-Params header: %v", err)
+		}
+
+		switch kv[0] {
+		case "t":
+			t = uint32(n)
+		case "m":
+			m = uint32(n)
+		case "p":
+			if n > maxArgonThreads {
+				return nil, fmt.Errorf("KDF thread count too large: %d, the most allowed is %d", n, maxArgonThreads)
+			}
+			p = uint8(n)
+		}
+	}
+	if t == 0 || m == 0 || p == 0 {
+		return nil, fmt.Errorf("missing KDF parameters: %q", block.Headers["KDF-Params"])
+	}
+	if t > maxArgonTime {
+		return nil, fmt.Errorf("KDF passes too large: %d, the most allowed is %d", t, maxArgonTime)
+	}
+	if m > maxArgonMemory {
+		return nil, fmt.Errorf("KDF memory too large: %d KiB, the most allowed is %d KiB", m, maxArgonMemory)
+	}
+
+	salt, err := hex.DecodeString(block.Headers["Salt"])
+	if err != nil {
+		return nil, fmt.Errorf("malformed Salt header: %v", err)
+	}
+
+	nonce, err := hex.DecodeString(block.Headers["Nonce"])
+	if err != nil {
+		return nil, fmt.Errorf("malformed Nonce header: %v", err)
+	}
+
+	gcm, err := newGCM(passphrase, salt, t, m, p)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("unexpected nonce size, exp=%d got=%d", gcm.NonceSize(), len(nonce))
+	}
+
+	plain, err := gcm.Open(nil, nonce, block.Bytes, nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	return plain, nil
+}
+
+func newGCM(passphrase, salt []byte, t, m uint32, p uint8) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(argon2.IDKey(passphrase, salt, t, m, p, argonKeyLen))
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func readPemFile(path string) (*pem.Block, error) {
+	f, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open key file for reading: %v", err)
+	}
+
+	block, rest := pem.Decode(f)
+	if block == nil {
+		return nil, fmt.Errorf("pem block was nil at key file: %s", path)
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("expected rest of pem block to be nil, got=%v", rest)
+	}
+
+	return block, nil
+}
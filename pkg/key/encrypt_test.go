@@ -0,0 +1,62 @@
+package key
+
+import (
+	"bytes"
+	"encoding/pem"
+	"testing"
+)
+
+func Test_EncryptDecryptPemBlock(t *testing.T) {
+	der := []byte("not really a private key")
+	plain := &pem.Block{
+		Type:    ed25519PrivateKey,
+		Headers: map[string]string{keyTypeHeader: string(TypeEd25519)},
+		Bytes:   der,
+	}
+
+	block, err := encryptPemBlock(plain, []byte("correct horse"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Headers must survive a round trip through the file encoding.
+	block, _ = pem.Decode(pem.EncodeToMemory(block))
+	if block == nil || block.Type != encryptedPrivateKeyType {
+		t.Fatalf("failed to decode encrypted pem block")
+	}
+	if blockKeyType(block) != TypeEd25519 {
+		t.Errorf("unexpected key type, exp=%s got=%s", TypeEd25519, blockKeyType(block))
+	}
+
+	got, err := decryptPemBlock(block, []byte("correct horse"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(got, der) {
+		t.Errorf("unexpected decrypted key, exp=%q got=%q", der, got)
+	}
+
+	if _, err := decryptPemBlock(block, []byte("battery staple")); err != ErrWrongPassphrase {
+		t.Errorf("expected wrong passphrase error, got=%v", err)
+	}
+}
+
+func Test_DecryptBlockLimits(t *testing.T) {
+	block, err := EncryptBlock(encryptedPrivateKeyType, nil, []byte("secret"), []byte("correct horse"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// KDF parameters too large to be honest are refused before the KDF is
+	// run, however long or much memory it would have taken.
+	for _, params := range []string{
+		"t=4294967295,m=65536,p=4",
+		"t=3,m=4294967295,p=4",
+		"t=3,m=65536,p=255",
+	} {
+		block.Headers["KDF-Params"] = params
+		if _, err := DecryptBlock(block, []byte("correct horse")); err == nil || err == ErrDecrypt {
+			t.Errorf("%s: expected KDF parameters to be refused, got=%v", params, err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package key
+
+import (
+	"crypto"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// A fingerprint is the first 30 bytes of SHA-512 iterated over the
+// identity's wire public key and uid, read as six 5 byte chunks each
+// reduced to 5 decimal digits. A safety number is the two 30 digit
+// fingerprints of a pair of identities, lowest first, so both sides of a
+// conversation see the same 60 digits.
+const (
+	fingerprintVersion    = 0
+	fingerprintIterations = 5200
+	fingerprintChunks     = 6
+	fingerprintChunkSize  = 5
+)
+
+// Fingerprint returns the 30 digit fingerprint of the identity id holding pk.
+func Fingerprint(id string, pk crypto.PublicKey) (string, error) {
+	b, err := MarshalPublicKey(pk)
+	if err != nil {
+		return "", err
+	}
+
+	version := make([]byte, 2)
+	binary.BigEndian.PutUint16(version, fingerprintVersion)
+
+	hash := append(append(version, b...), id...)
+	for i := 0; i < fingerprintIterations; i++ {
+		h := sha512.New()
+		h.Write(hash)
+		h.Write(b)
+		hash = h.Sum(nil)
+	}
+
+	var digits strings.Builder
+	for i := 0; i < fingerprintChunks; i++ {
+		var n uint64
+		for _, c := range hash[i*fingerprintChunkSize : (i+1)*fingerprintChunkSize] {
+			n = n<<8 | uint64(c)
+		}
+		fmt.Fprintf(&digits, "%05d", n%100000)
+	}
+
+	return digits.String(), nil
+}
+
+// SafetyNumber returns the 60 digit safety number of the identities a and b.
+// It does not depend on which side is local.
+func SafetyNumber(aID string, a crypto.PublicKey, bID string, b crypto.PublicKey) (string, error) {
+	af, err := Fingerprint(aID, a)
+	if err != nil {
+		return "", fmt.Errorf("failed to fingerprint %s: %v", aID, err)
+	}
+
+	bf, err := Fingerprint(bID, b)
+	if err != nil {
+		return "", fmt.Errorf("failed to fingerprint %s: %v", bID, err)
+	}
+
+	if bf < af {
+		af, bf = bf, af
+	}
+
+	return af + bf, nil
+}
+
+// FormatSafetyNumber splits a safety number into groups of 5 digits, 4
+// groups per line.
+func FormatSafetyNumber(number string) string {
+	var lines []string
+	for len(number) > 0 {
+		var groups []string
+		for i := 0; i < 4 && len(number) > 0; i++ {
+			n := 5
+			if len(number) < n {
+				n = len(number)
+			}
+			groups = append(groups, number[:n])
+			number = number[n:]
+		}
+		lines = append(lines, strings.Join(groups, " "))
+	}
+
+	return strings.Join(lines, "\n")
+}
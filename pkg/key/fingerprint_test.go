@@ -0,0 +1,44 @@
+package key
+
+import (
+	"testing"
+)
+
+func Test_SafetyNumber(t *testing.T) {
+	a, err := GenerateType(TypeEd25519)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := GenerateType(TypeEd25519)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ab, err := SafetyNumber("1", a.Public(), "2", b.Public())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ba, err := SafetyNumber("2", b.Public(), "1", a.Public())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ab != ba {
+		t.Errorf("expected safety number to be the same from both sides, got=%s and %s", ab, ba)
+	}
+	if len(ab) != 60 {
+		t.Errorf("unexpected safety number length, exp=60 got=%d", len(ab))
+	}
+
+	other, err := SafetyNumber("1", a.Public(), "3", b.Public())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if other == ab {
+		t.Errorf("expected safety number to change with uid")
+	}
+
+	if got := FormatSafetyNumber(ab); len(got) != 60+9+2 {
+		t.Errorf("unexpected formatted safety number: %q", got)
+	}
+}
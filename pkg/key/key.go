@@ -1,27 +1,42 @@
 package key
 
 import (
+	"bytes"
 	"crypto"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
-	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"fmt"
+
+	"github.com/joshvanl/go-whisper/pkg/store"
 )
 
 const (
-	keySize = 4056
+	keySize = 4096
 )
 
 type Key struct {
 	dir string
 	uid uint64
-	sk  *rsa.PrivateKey
+	sk  crypto.Signer
+
+	// passphrase is set when the private key is stored encrypted.
+	passphrase   []byte
+	passphraseFn PassphraseFunc
 }
 
 func New(dir string) (*Key, error) {
+	return NewWithPassphrase(dir, nil)
+}
+
+// NewWithPassphrase is New, calling passphrase if the stored private key is
+// encrypted.
+func NewWithPassphrase(dir string, passphrase PassphraseFunc) (*Key, error) {
 	key := &Key{
-		dir: dir,
+		dir:          dir,
+		passphraseFn: passphrase,
 	}
 
 	sk, err := key.retrieveLocalKey()
@@ -34,13 +49,20 @@ func New(dir string) (*Key, error) {
 }
 
 func (k *Key) createKeyPair() error {
-	sk, err := k.generateRSAKey()
+	sk, err := GenerateType(DefaultType)
 	if err != nil {
 		return err
 	}
 
-	privBlock := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(sk)}
-	pubBlock := &pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&sk.PublicKey)}
+	privBlock, err := privateKeyBlock(sk)
+	if err != nil {
+		return err
+	}
+
+	pubBlock, err := publicKeyBlock(sk.Public())
+	if err != nil {
+		return err
+	}
 
 	if err := k.writeKeyPemFile(fmt.Sprintf("%s/%s", k.dir, privateKeyFile), privBlock); err != nil {
 		return fmt.Errorf("failed to write private key to file: %v", err)
@@ -53,61 +75,153 @@ func (k *Key) createKeyPair() error {
 	return nil
 }
 
-func (k *Key) generateRSAKey() (*rsa.PrivateKey, error) {
-	sk, err := rsa.GenerateKey(rand.Reader, keySize)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate RSA key pair: %v", err)
+func (k *Key) VerifyPayload(pk crypto.PublicKey, payload []byte, sig []byte) error {
+	switch pk := pk.(type) {
+	case *rsa.PublicKey:
+		opts := &rsa.PSSOptions{
+			SaltLength: rsa.PSSSaltLengthEqualsHash,
+			Hash:       crypto.SHA512,
+		}
+
+		hash := opts.Hash.New()
+		_, err := hash.Write([]byte(payload))
+		if err != nil {
+			return fmt.Errorf("failed to hash payload: %v", err)
+		}
+
+		if err := rsa.VerifyPSS(pk, crypto.SHA512, hash.Sum(nil), []byte(sig), opts); err != nil {
+			return fmt.Errorf("unable to verify payload: %v", err)
+		}
+
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pk, payload, sig) {
+			return errors.New("unable to verify payload: ed25519 signature is invalid")
+		}
+
+	default:
+		return ErrUnsupportedKey
 	}
 
-	return sk, nil
+	return nil
 }
 
-func (k *Key) VerifyPayload(pk *rsa.PublicKey, payload []byte, sig []byte) error {
-	opts := &rsa.PSSOptions{
-		SaltLength: rsa.PSSSaltLengthEqualsHash,
-		Hash:       crypto.SHA512,
+func (k *Key) SignMessage(message []byte) ([]byte, error) {
+	return SignWith(k.sk, message)
+}
+
+// SignWith signs message with sk rather than the local key, such as a key
+// that is about to replace it.
+func SignWith(sk crypto.Signer, message []byte) ([]byte, error) {
+	switch sk.(type) {
+	case *rsa.PrivateKey:
+		opts := &rsa.PSSOptions{
+			SaltLength: rsa.PSSSaltLengthEqualsHash,
+			Hash:       crypto.SHA512,
+		}
+
+		hash := opts.Hash.New()
+		_, err := hash.Write(message)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash message: %v", err)
+		}
+		hashed := hash.Sum(nil)
+
+		signiture, err := sk.Sign(rand.Reader, hashed, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign message: %v", err)
+		}
+
+		return signiture, nil
+
+	case ed25519.PrivateKey:
+		// Ed25519 signs the message itself, not a digest.
+		signiture, err := sk.Sign(rand.Reader, message, crypto.Hash(0))
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign message: %v", err)
+		}
+
+		return signiture, nil
 	}
 
-	hash := opts.Hash.New()
-	_, err := hash.Write([]byte(payload))
+	return nil, ErrUnsupportedKey
+}
+
+// GenerateKey creates a new key pair of the default type without storing it.
+// Rotating an RSA identity therefore moves it to Ed25519.
+func (k *Key) GenerateKey() (crypto.Signer, error) {
+	return GenerateType(DefaultType)
+}
+
+// ReplaceKey overwrites the local key pair files with sk and uses it from
+// then on. The private key keeps any passphrase encryption.
+func (k *Key) ReplaceKey(sk crypto.Signer) error {
+	pubBlock, err := publicKeyBlock(sk.Public())
 	if err != nil {
-		return fmt.Errorf("failed to hash payload: %v", err)
+		return err
+	}
+
+	if err := k.writePrivateKey(sk); err != nil {
+		return fmt.Errorf("failed to write private key to file: %v", err)
 	}
 
-	if err := rsa.VerifyPSS(pk, crypto.SHA512, hash.Sum(nil), []byte(sig), opts); err != nil {
-		return fmt.Errorf("unable to verify payload: %v", err)
+	if err := store.WriteFile(fmt.Sprintf("%s/%s", k.dir, publicKeyFile), pem.EncodeToMemory(pubBlock)); err != nil {
+		return fmt.Errorf("failed to write public key to file: %v", err)
 	}
 
+	k.sk = sk
+
 	return nil
 }
 
-func (k *Key) SignMessage(message []byte) ([]byte, error) {
-	opts := &rsa.PSSOptions{
-		SaltLength: rsa.PSSSaltLengthEqualsHash,
-		Hash:       crypto.SHA512,
+// writePrivateKey atomically writes sk to the private key file, encrypting
+// it if a passphrase is set.
+func (k *Key) writePrivateKey(sk crypto.Signer) error {
+	block, err := privateKeyBlock(sk)
+	if err != nil {
+		return err
 	}
 
-	hash := opts.Hash.New()
-	_, err := hash.Write(message)
-	if err != nil {
-		return nil, fmt.Errorf("failed to hash message: %v", err)
+	if len(k.passphrase) > 0 {
+		block, err = encryptPemBlock(block, k.passphrase)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt private key: %v", err)
+		}
 	}
-	hashed := hash.Sum(nil)
 
-	signiture, err := k.sk.Sign(rand.Reader, hashed, opts)
+	return store.WriteFile(fmt.Sprintf("%s/%s", k.dir, privateKeyFile), pem.EncodeToMemory(block))
+}
+
+// TransitionMessage is the statement signed by both the old and new key when
+// uid rotates its identity key to newpk.
+func TransitionMessage(uid string, newpk []byte) []byte {
+	return bytes.Join([][]byte{[]byte("key transition"), []byte(uid), newpk}, []byte{0})
+}
+
+// DeviceMessage is the statement signed by uid's identity key to link the
+// device holding devicepk to the account.
+func DeviceMessage(uid string, devicepk []byte) []byte {
+	return bytes.Join([][]byte{[]byte("device"), []byte(uid), devicepk}, []byte{0})
+}
+
+// PublicKey returns the local public key encoded for the wire.
+func (k *Key) PublicKey() []byte {
+	b, err := MarshalPublicKey(k.sk.Public())
 	if err != nil {
-		return nil, fmt.Errorf("failed to sign message: %v", err)
+		// sk is always a supported type.
+		panic(err)
 	}
 
-	return signiture, nil
+	return b
 }
 
-func (k *Key) PublicKey() []byte {
-	return x509.MarshalPKCS1PublicKey(&k.sk.PublicKey)
+// Public returns the local public key.
+func (k *Key) Public() crypto.PublicKey {
+	return k.sk.Public()
 }
 
-func (k *Key) Key() *rsa.PrivateKey {
-	return k.sk
+func (k *Key) Type() Type {
+	t, _ := TypeOf(k.sk)
+	return t
 }
 
 func (k *Key) Uid() uint64 {
@@ -1,11 +1,9 @@
 package key
 
 import (
-	"crypto/rsa"
-	"crypto/x509"
+	"crypto"
 	"encoding/pem"
 	"fmt"
-	"io/ioutil"
 	"os"
 )
 
@@ -14,7 +12,7 @@ const (
 	publicKeyFile  = "public_key.pem"
 )
 
-func (k *Key) retrieveLocalKey() (*rsa.PrivateKey, error) {
+func (k *Key) retrieveLocalKey() (crypto.Signer, error) {
 	if err := k.ensureKeyDirectory(); err != nil {
 		return nil, fmt.Errorf("failed to ensure key directory: %v", err)
 	}
@@ -31,21 +29,32 @@ func (k *Key) retrieveLocalKey() (*rsa.PrivateKey, error) {
 	return sk, nil
 }
 
-func (k *Key) readPrivateKey(path string) (*rsa.PrivateKey, error) {
-	f, err := ioutil.ReadFile(path)
+func (k *Key) readPrivateKey(path string) (crypto.Signer, error) {
+	block, err := readPemFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open private key file for reading: %v", err)
+		return nil, err
 	}
 
-	block, rest := pem.Decode(f)
-	if block == nil {
-		return nil, fmt.Errorf("pem block was nil at private key file: %s", path)
-	}
-	if len(rest) != 0 {
-		return nil, fmt.Errorf("expected rest of pem block to be nil, got=%v", rest)
+	der := block.Bytes
+	if block.Type == encryptedPrivateKeyType {
+		if k.passphraseFn == nil {
+			return nil, ErrPassphraseRequired
+		}
+
+		passphrase, err := k.passphraseFn()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get passphrase: %v", err)
+		}
+
+		der, err = decryptPemBlock(block, passphrase)
+		if err != nil {
+			return nil, err
+		}
+
+		k.passphrase = passphrase
 	}
 
-	sk, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	sk, err := parsePrivateKeyDER(blockKeyType(block), der)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse private key file: %v", err)
 	}
@@ -53,21 +62,13 @@ func (k *Key) readPrivateKey(path string) (*rsa.PrivateKey, error) {
 	return sk, nil
 }
 
-func readPublicKey(path string) (*rsa.PublicKey, error) {
-	f, err := ioutil.ReadFile(path)
+func readPublicKey(path string) (crypto.PublicKey, error) {
+	block, err := readPemFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open public key file for reading: %v", err)
-	}
-
-	block, rest := pem.Decode(f)
-	if block == nil {
-		return nil, fmt.Errorf("pem block was nil at public key file: %s", path)
-	}
-	if len(rest) != 0 {
-		return nil, fmt.Errorf("expected rest of pem block to be nil, got=%v", rest)
+		return nil, err
 	}
 
-	k, err := x509.ParsePKCS1PublicKey(block.Bytes)
+	k, err := parsePublicKeyBlock(block)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse public key file: %v", err)
 	}
@@ -0,0 +1,261 @@
+package key
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// Type is the algorithm of an identity key. Stored PEM blocks carry it in a
+// Key-Type header; blocks without one are RSA, as written before Ed25519
+// support.
+//
+// On the wire RSA public keys stay PKCS1 encoded so existing peers keep
+// working, and Ed25519 public keys are PKIX encoded so the algorithm
+// identifier in the encoding names the key type.
+type Type string
+
+const (
+	TypeRSA     Type = "rsa"
+	TypeEd25519 Type = "ed25519"
+
+	// DefaultType is used for newly generated keys.
+	DefaultType = TypeEd25519
+
+	keyTypeHeader = "Key-Type"
+
+	rsaPublicKeyType     = "RSA PUBLIC KEY"
+	ed25519PublicKeyType = "PUBLIC KEY"
+	ed25519PrivateKey    = "PRIVATE KEY"
+
+	// MinRSAKeySize is the smallest RSA modulus, in bits, accepted from a
+	// peer.
+	MinRSAKeySize = 2048
+)
+
+var (
+	ErrUnsupportedKey = errors.New("unsupported key type")
+	ErrWeakKey        = errors.New("key is too weak")
+)
+
+// TypeOf returns the Type of a public or private key.
+func TypeOf(k interface{}) (Type, error) {
+	switch k.(type) {
+	case *rsa.PublicKey, *rsa.PrivateKey:
+		return TypeRSA, nil
+	case ed25519.PublicKey, ed25519.PrivateKey:
+		return TypeEd25519, nil
+	}
+
+	return "", ErrUnsupportedKey
+}
+
+// ParseType parses a key type name, such as from a flag.
+func ParseType(s string) (Type, error) {
+	switch Type(s) {
+	case TypeRSA, TypeEd25519:
+		return Type(s), nil
+	}
+
+	return "", fmt.Errorf("unknown key type %q, expected %q or %q", s, TypeRSA, TypeEd25519)
+}
+
+// GenerateType creates a new private key of type t.
+func GenerateType(t Type) (crypto.Signer, error) {
+	switch t {
+	case TypeRSA:
+		sk, err := rsa.GenerateKey(rand.Reader, keySize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate RSA key pair: %v", err)
+		}
+		return sk, nil
+
+	case TypeEd25519:
+		_, sk, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate Ed25519 key pair: %v", err)
+		}
+		return sk, nil
+	}
+
+	return nil, ErrUnsupportedKey
+}
+
+// MarshalPublicKey encodes pk for sending on the wire.
+func MarshalPublicKey(pk crypto.PublicKey) ([]byte, error) {
+	switch pk := pk.(type) {
+	case *rsa.PublicKey:
+		return x509.MarshalPKCS1PublicKey(pk), nil
+	case ed25519.PublicKey:
+		return x509.MarshalPKIXPublicKey(pk)
+	}
+
+	return nil, ErrUnsupportedKey
+}
+
+// ParsePublicKey decodes a public key sent on the wire.
+func ParsePublicKey(b []byte) (crypto.PublicKey, error) {
+	if pk, err := x509.ParsePKCS1PublicKey(b); err == nil {
+		return pk, nil
+	}
+
+	pk, err := x509.ParsePKIXPublicKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %v", err)
+	}
+
+	if _, err := TypeOf(pk); err != nil {
+		return nil, err
+	}
+
+	return pk, nil
+}
+
+// CheckPublicKey rejects public keys too weak to accept from a peer, such as
+// short RSA moduli or invalid exponents.
+func CheckPublicKey(pk crypto.PublicKey) error {
+	switch pk := pk.(type) {
+	case *rsa.PublicKey:
+		if pk.N == nil || pk.N.BitLen() < MinRSAKeySize {
+			return ErrWeakKey
+		}
+		if pk.E < 3 || pk.E%2 == 0 {
+			return ErrWeakKey
+		}
+
+	case ed25519.PublicKey:
+		if len(pk) != ed25519.PublicKeySize {
+			return ErrUnsupportedKey
+		}
+
+		// Reject the all zero and identity point encodings, which are not
+		// real keys.
+		var zero [ed25519.PublicKeySize]byte
+		identity := zero
+		identity[0] = 1
+		if string(pk) == string(zero[:]) || string(pk) == string(identity[:]) {
+			return ErrWeakKey
+		}
+
+	default:
+		return ErrUnsupportedKey
+	}
+
+	return nil
+}
+
+// EqualPublicKeys reports whether a and b are the same key.
+func EqualPublicKeys(a, b crypto.PublicKey) bool {
+	ab, err := MarshalPublicKey(a)
+	if err != nil {
+		return false
+	}
+
+	bb, err := MarshalPublicKey(b)
+	if err != nil {
+		return false
+	}
+
+	return string(ab) == string(bb)
+}
+
+func publicKeyBlock(pk crypto.PublicKey) (*pem.Block, error) {
+	t, err := TypeOf(pk)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := MarshalPublicKey(pk)
+	if err != nil {
+		return nil, err
+	}
+
+	blockType := rsaPublicKeyType
+	if t == TypeEd25519 {
+		blockType = ed25519PublicKeyType
+	}
+
+	return &pem.Block{
+		Type:    blockType,
+		Headers: map[string]string{keyTypeHeader: string(t)},
+		Bytes:   b,
+	}, nil
+}
+
+func parsePublicKeyBlock(block *pem.Block) (crypto.PublicKey, error) {
+	switch blockKeyType(block) {
+	case TypeRSA:
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+
+	case TypeEd25519:
+		pk, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := pk.(ed25519.PublicKey); !ok {
+			return nil, fmt.Errorf("expected ed25519 public key, got %T", pk)
+		}
+		return pk, nil
+	}
+
+	return nil, ErrUnsupportedKey
+}
+
+// privateKeyBlock returns the unencrypted PEM block for sk.
+func privateKeyBlock(sk crypto.Signer) (*pem.Block, error) {
+	switch sk := sk.(type) {
+	case *rsa.PrivateKey:
+		return &pem.Block{
+			Type:    privateKeyType,
+			Headers: map[string]string{keyTypeHeader: string(TypeRSA)},
+			Bytes:   x509.MarshalPKCS1PrivateKey(sk),
+		}, nil
+
+	case ed25519.PrivateKey:
+		der, err := x509.MarshalPKCS8PrivateKey(sk)
+		if err != nil {
+			return nil, err
+		}
+
+		return &pem.Block{
+			Type:    ed25519PrivateKey,
+			Headers: map[string]string{keyTypeHeader: string(TypeEd25519)},
+			Bytes:   der,
+		}, nil
+	}
+
+	return nil, ErrUnsupportedKey
+}
+
+func parsePrivateKeyDER(t Type, der []byte) (crypto.Signer, error) {
+	switch t {
+	case TypeRSA:
+		return x509.ParsePKCS1PrivateKey(der)
+
+	case TypeEd25519:
+		sk, err := x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			return nil, err
+		}
+		ed, ok := sk.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("expected ed25519 private key, got %T", sk)
+		}
+		return ed, nil
+	}
+
+	return nil, ErrUnsupportedKey
+}
+
+func blockKeyType(block *pem.Block) Type {
+	if t, ok := block.Headers[keyTypeHeader]; ok {
+		return Type(t)
+	}
+
+	return TypeRSA
+}
@@ -0,0 +1,110 @@
+package key
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_SignVerifyTypes(t *testing.T) {
+	k := new(Key)
+
+	for _, typ := range []Type{TypeRSA, TypeEd25519} {
+		sk, err := GenerateType(typ)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		sig, err := SignWith(sk, []byte("hello"))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", typ, err)
+			continue
+		}
+
+		b, err := MarshalPublicKey(sk.Public())
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", typ, err)
+			continue
+		}
+
+		pk, err := ParsePublicKey(b)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", typ, err)
+			continue
+		}
+
+		if got, _ := TypeOf(pk); got != typ {
+			t.Errorf("unexpected parsed key type, exp=%s got=%s", typ, got)
+		}
+
+		if err := k.VerifyPayload(pk, []byte("hello"), sig); err != nil {
+			t.Errorf("%s: unexpected error: %v", typ, err)
+		}
+
+		if err := k.VerifyPayload(pk, []byte("goodbye"), sig); err == nil {
+			t.Errorf("%s: expected error verifying wrong payload", typ)
+		}
+	}
+}
+
+func Test_ReadLegacyRSAPublicKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-whisper-key")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	sk, err := GenerateType(TypeRSA)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rsaPk := &sk.(*rsa.PrivateKey).PublicKey
+
+	// Files written before key types had no Key-Type header.
+	path := filepath.Join(dir, "legacy")
+	b := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(rsaPk)})
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pk, err := readPublicKey(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !EqualPublicKeys(pk, rsaPk) {
+		t.Errorf("legacy public key did not round trip")
+	}
+}
+
+func Test_CheckPublicKey(t *testing.T) {
+	sk, err := GenerateType(TypeEd25519)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := CheckPublicKey(sk.Public()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := CheckPublicKey(weak.Public()); err != ErrWeakKey {
+		t.Errorf("expected weak key error for 1024 bit RSA, got=%v", err)
+	}
+
+	if err := CheckPublicKey(make(ed25519.PublicKey, ed25519.PublicKeySize)); err != ErrWeakKey {
+		t.Errorf("expected weak key error for zero Ed25519 key, got=%v", err)
+	}
+
+	if err := CheckPublicKey(ed25519.PublicKey{1, 2, 3}); err != ErrUnsupportedKey {
+		t.Errorf("expected unsupported key error for short Ed25519 key, got=%v", err)
+	}
+}
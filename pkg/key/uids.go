@@ -1,8 +1,7 @@
 package key
 
 import (
-	"crypto/rsa"
-	"crypto/x509"
+	"crypto"
 	"encoding/pem"
 	"fmt"
 	"io/ioutil"
@@ -10,6 +9,8 @@ import (
 	"path/filepath"
 	"regexp"
 	"strconv"
+
+	"github.com/joshvanl/go-whisper/pkg/store"
 )
 
 const (
@@ -31,13 +32,65 @@ func (k *Key) NewUIDs(uid uint64) error {
 	return nil
 }
 
-func (k *Key) NewUidFile(uid string, pk *rsa.PublicKey) error {
+func (k *Key) NewUidFile(uid string, pk crypto.PublicKey) error {
 	path := filepath.Join(k.uidsPath(), uid)
-	pubBlock := &pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(pk)}
+	pubBlock, err := publicKeyBlock(pk)
+	if err != nil {
+		return err
+	}
 	return k.writeKeyPemFile(path, pubBlock)
 }
 
-func (k *Key) ReadUidFile(uid string) (*rsa.PublicKey, error) {
+// CreateUidFile stores the public key for uid, failing with an error
+// satisfying os.IsExist if the uid file is already present. The key is
+// written to a temporary file first and then linked into place so a uid file
+// is never observed half written.
+func (k *Key) CreateUidFile(uid string, pk crypto.PublicKey) error {
+	path := filepath.Join(k.uidsPath(), uid)
+
+	pubBlock, err := publicKeyBlock(pk)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(k.uidsPath(), ".tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary uid file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := pem.Encode(tmp, pubBlock); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write pem block to file: %v", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary uid file: %v", err)
+	}
+
+	return os.Link(tmp.Name(), path)
+}
+
+// ReplaceUidFile atomically replaces the stored public key for uid.
+func (k *Key) ReplaceUidFile(uid string, pk crypto.PublicKey) error {
+	path := filepath.Join(k.uidsPath(), uid)
+	pubBlock, err := publicKeyBlock(pk)
+	if err != nil {
+		return err
+	}
+	return store.WriteFile(path, pem.EncodeToMemory(pubBlock))
+}
+
+func (k *Key) RemoveUidFile(uid string) error {
+	path := filepath.Join(k.uidsPath(), uid)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove uid file: %v", err)
+	}
+
+	return nil
+}
+
+func (k *Key) ReadUidFile(uid string) (crypto.PublicKey, error) {
 	path := filepath.Join(k.uidsPath(), uid)
 	return readPublicKey(path)
 }
@@ -0,0 +1,94 @@
+// Package logging builds the structured loggers shared by the server and
+// client, and names the fields their log lines carry.
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Output formats for New.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Fields attached to log lines so those of one session or account can be
+// picked out.
+const (
+	FieldSession = "session"
+	FieldAddress = "addr"
+	FieldUID     = "uid"
+	FieldDevice  = "device"
+	FieldCommand = "command"
+)
+
+const (
+	sessionIDSize = 6
+)
+
+// New returns a logger writing to out in format. level is 0 for fatal
+// errors only, 1 for info and 2 for debug.
+func New(out io.Writer, level int, format string) (*logrus.Entry, error) {
+	logger := logrus.New()
+	logger.Out = out
+
+	switch level {
+	case 0:
+		logger.Level = logrus.FatalLevel
+	case 1:
+		logger.Level = logrus.InfoLevel
+	case 2:
+		logger.Level = logrus.DebugLevel
+	default:
+		return nil, fmt.Errorf("not a valid log level: %d", level)
+	}
+
+	switch format {
+	case FormatText:
+		logger.Formatter = &logrus.TextFormatter{FullTimestamp: true}
+	case FormatJSON:
+		logger.Formatter = new(logrus.JSONFormatter)
+	default:
+		return nil, fmt.Errorf("unknown log format %q, expected %q or %q", format, FormatText, FormatJSON)
+	}
+
+	return logrus.NewEntry(logger), nil
+}
+
+// OpenFile opens the log file at path for appending, creating it if needed.
+func OpenFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %v", err)
+	}
+
+	return f, nil
+}
+
+// Discard returns a logger that drops everything, for code given no
+// logger.
+func Discard() *logrus.Entry {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	logger.Level = logrus.PanicLevel
+
+	return logrus.NewEntry(logger)
+}
+
+// NewSessionID returns a short random ID for the log lines of one
+// connection.
+func NewSessionID() string {
+	b := make([]byte, sessionIDSize)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+
+	return hex.EncodeToString(b)
+}
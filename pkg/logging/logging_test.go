@@ -0,0 +1,47 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func Test_New(t *testing.T) {
+	var buf bytes.Buffer
+
+	log, err := New(&buf, 1, FormatJSON)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	log.WithField(FieldSession, "abc").Debugf("hidden")
+	log.WithField(FieldSession, "abc").Infof("shown")
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expected one json log line, got %q: %v", buf.String(), err)
+	}
+	if line["msg"] != "shown" {
+		t.Errorf("unexpected message: %v", line["msg"])
+	}
+	if line[FieldSession] != "abc" {
+		t.Errorf("expected session field abc, got %v", line[FieldSession])
+	}
+
+	if _, err := New(&buf, 3, FormatText); err == nil {
+		t.Errorf("expected error for invalid level")
+	}
+	if _, err := New(&buf, 1, "xml"); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+}
+
+func Test_NewSessionID(t *testing.T) {
+	a, b := NewSessionID(), NewSessionID()
+	if len(a) != sessionIDSize*2 {
+		t.Errorf("expected session id of %d characters, got %q", sessionIDSize*2, a)
+	}
+	if a == b {
+		t.Errorf("expected distinct session ids, got %q twice", a)
+	}
+}
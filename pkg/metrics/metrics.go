@@ -0,0 +1,358 @@
+// Package metrics keeps counters, gauges and histograms and writes them in
+// the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// DefaultBuckets are histogram bucket upper bounds, in seconds, suiting
+// request latencies.
+var DefaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds metrics in the order they were created.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+func NewRegistry() *Registry {
+	return new(Registry)
+}
+
+func (r *Registry) add(m metric) {
+	r.mu.Lock()
+	r.metrics = append(r.metrics, m)
+	r.mu.Unlock()
+}
+
+// WriteTo writes every metric to w in the text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP serves the metrics for scraping.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+// desc is the name, help and label names shared by the series of a metric.
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d *desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, escapeHelp(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.typ)
+}
+
+func (d *desc) writeSample(w *bufio.Writer, suffix string, values []string, extra string, v float64) {
+	w.WriteString(d.name + suffix)
+
+	var pairs []string
+	for i, l := range d.labels {
+		pairs = append(pairs, l+`="`+escapeLabel(values[i])+`"`)
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	if len(pairs) > 0 {
+		w.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+
+	w.WriteString(" " + formatFloat(v) + "\n")
+}
+
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s takes %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+
+	return strings.Join(values, "\xff")
+}
+
+// value is a metric holding one number per set of label values.
+type value struct {
+	desc
+
+	mu     sync.Mutex
+	series map[string]*sample
+}
+
+type sample struct {
+	labels []string
+	v      float64
+}
+
+func newValue(name, help, typ string, labels []string) value {
+	return value{
+		desc:   desc{name: name, help: help, typ: typ, labels: labels},
+		series: make(map[string]*sample),
+	}
+}
+
+func (m *value) add(delta float64, values []string) {
+	k := m.key(values)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.series[k]
+	if !ok {
+		s = &sample{labels: append([]string(nil), values...)}
+		m.series[k] = s
+	}
+	s.v += delta
+}
+
+func (m *value) set(v float64, values []string) {
+	k := m.key(values)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.series[k] = &sample{labels: append([]string(nil), values...), v: v}
+}
+
+func (m *value) write(w *bufio.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.writeHeader(w)
+	if len(m.labels) == 0 && len(m.series) == 0 {
+		m.writeSample(w, "", nil, "", 0)
+	}
+	for _, k := range sortedSamples(m.series) {
+		m.writeSample(w, "", m.series[k].labels, "", m.series[k].v)
+	}
+}
+
+// Counter only goes up.
+type Counter struct {
+	value
+}
+
+// Counter creates a counter with the given label names.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	c := &Counter{value: newValue(name, help, typeCounter, labels)}
+	r.add(c)
+	return c
+}
+
+// Inc adds one to the series with the label values.
+func (c *Counter) Inc(values ...string) {
+	c.add(1, values)
+}
+
+// Add adds delta, which must not be negative, to the series with the label
+// values.
+func (c *Counter) Add(delta float64, values ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("counter %s can not decrease", c.name))
+	}
+	c.add(delta, values)
+}
+
+// Gauge may go up and down.
+type Gauge struct {
+	value
+}
+
+// Gauge creates a gauge with the given label names.
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{value: newValue(name, help, typeGauge, labels)}
+	r.add(g)
+	return g
+}
+
+func (g *Gauge) Set(v float64, values ...string) {
+	g.set(v, values)
+}
+
+func (g *Gauge) Add(delta float64, values ...string) {
+	g.add(delta, values)
+}
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	desc
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*distribution
+}
+
+type distribution struct {
+	labels []string
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Histogram creates a histogram with the given bucket upper bounds, in
+// increasing order, and label names.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		desc:    desc{name: name, help: help, typ: typeHistogram, labels: labels},
+		buckets: buckets,
+		series:  make(map[string]*distribution),
+	}
+	r.add(h)
+	return h
+}
+
+// Observe records v in the series with the label values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	k := h.key(values)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d, ok := h.series[k]
+	if !ok {
+		d = &distribution{
+			labels: append([]string(nil), values...),
+			counts: make([]uint64, len(h.buckets)),
+		}
+		h.series[k] = d
+	}
+
+	for i, upper := range h.buckets {
+		if v <= upper {
+			d.counts[i]++
+		}
+	}
+	d.sum += v
+	d.count++
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.writeHeader(w)
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		d := h.series[k]
+		for i, upper := range h.buckets {
+			h.writeSample(w, "_bucket", d.labels, `le="`+formatFloat(upper)+`"`, float64(d.counts[i]))
+		}
+		h.writeSample(w, "_bucket", d.labels, `le="+Inf"`, float64(d.count))
+		h.writeSample(w, "_sum", d.labels, "", d.sum)
+		h.writeSample(w, "_count", d.labels, "", float64(d.count))
+	}
+}
+
+// Emit reports one series of a collected metric.
+type Emit func(v float64, values ...string)
+
+// collected is a metric whose series are read from elsewhere when it is
+// written.
+type collected struct {
+	desc
+	collect func(Emit)
+}
+
+// CounterFunc creates a counter whose series are reported by collect each
+// time the metrics are written.
+func (r *Registry) CounterFunc(name, help string, labels []string, collect func(Emit)) {
+	r.add(&collected{desc: desc{name: name, help: help, typ: typeCounter, labels: labels}, collect: collect})
+}
+
+// GaugeFunc creates a gauge whose series are reported by collect each time
+// the metrics are written.
+func (r *Registry) GaugeFunc(name, help string, labels []string, collect func(Emit)) {
+	r.add(&collected{desc: desc{name: name, help: help, typ: typeGauge, labels: labels}, collect: collect})
+}
+
+func (c *collected) write(w *bufio.Writer) {
+	series := make(map[string]*sample)
+	c.collect(func(v float64, values ...string) {
+		series[c.key(values)] = &sample{labels: values, v: v}
+	})
+
+	c.writeHeader(w)
+	for _, k := range sortedSamples(series) {
+		c.writeSample(w, "", series[k].labels, "", series[k].v)
+	}
+}
+
+func sortedSamples(m map[string]*sample) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
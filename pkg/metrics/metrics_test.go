@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_Registry(t *testing.T) {
+	r := NewRegistry()
+
+	requests := r.Counter("requests_total", "Requests handled.", "command", "result")
+	sessions := r.Gauge("sessions", "Open sessions.")
+	latency := r.Histogram("latency_seconds", "Request latency.", []float64{0.1, 1}, "command")
+	r.GaugeFunc("queue", "Queued entries.", []string{"queue"}, func(emit Emit) {
+		emit(2, "b")
+		emit(1, "a")
+	})
+
+	requests.Inc("query", "ok")
+	requests.Add(2, "query", "ok")
+	requests.Inc("query", `bad "quote"`)
+	latency.Observe(0.05, "query")
+	latency.Observe(0.5, "query")
+	latency.Observe(5, "query")
+
+	exp := `# HELP requests_total Requests handled.
+# TYPE requests_total counter
+requests_total{command="query",result="bad \"quote\""} 1
+requests_total{command="query",result="ok"} 3
+# HELP sessions Open sessions.
+# TYPE sessions gauge
+sessions 0
+# HELP latency_seconds Request latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{command="query",le="0.1"} 1
+latency_seconds_bucket{command="query",le="1"} 2
+latency_seconds_bucket{command="query",le="+Inf"} 3
+latency_seconds_sum{command="query"} 5.55
+latency_seconds_count{command="query"} 3
+# HELP queue Queued entries.
+# TYPE queue gauge
+queue{queue="a"} 1
+queue{queue="b"} 2
+`
+
+	var buf bytes.Buffer
+	n, err := r.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != exp {
+		t.Errorf("unexpected output, exp=\n%s\ngot=\n%s", exp, buf.String())
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("unexpected written length, exp=%d got=%d", buf.Len(), n)
+	}
+
+	sessions.Set(4)
+	sessions.Add(-1)
+	buf.Reset()
+	r.WriteTo(&buf)
+	if !bytes.Contains(buf.Bytes(), []byte("\nsessions 3\n")) {
+		t.Errorf("expected sessions gauge of 3, got=\n%s", buf.String())
+	}
+}
@@ -0,0 +1,120 @@
+package protocol
+
+import (
+	"fmt"
+)
+
+// Code is the machine readable reason given in a server error response:
+//
+//	"error", code, message, signature
+type Code string
+
+const (
+	CodeMalformed          Code = "malformed request"
+	CodeInvalidKey         Code = "invalid key"
+	CodeWeakKey            Code = "weak key"
+	CodeBadSignature       Code = "bad signature"
+	CodeBadChallenge       Code = "bad challenge"
+	CodeStale              Code = "stale request"
+	CodeReplayed           Code = "replayed request"
+	CodeUnknownUID         Code = "unknown uid"
+	CodeDeletedUID         Code = "deleted uid"
+	CodeDisabledUID        Code = "disabled uid"
+	CodeUIDUnavailable     Code = "uid unavailable"
+	CodeInviteRequired     Code = "invite required"
+	CodeInvalidInvite      Code = "invalid invite"
+	CodeRegistrationClosed Code = "registration closed"
+	CodeForbidden          Code = "forbidden"
+	CodeNotFound           Code = "not found"
+	CodeRejected           Code = "rejected"
+	CodeRateLimited        Code = "rate limited"
+	CodeInternal           Code = "internal error"
+)
+
+// Sentinels for matching error responses by code with errors.Is.
+var (
+	ErrMalformed          = &Error{Code: CodeMalformed}
+	ErrInvalidKey         = &Error{Code: CodeInvalidKey}
+	ErrWeakKey            = &Error{Code: CodeWeakKey}
+	ErrBadSignature       = &Error{Code: CodeBadSignature}
+	ErrBadChallenge       = &Error{Code: CodeBadChallenge}
+	ErrStale              = &Error{Code: CodeStale}
+	ErrReplayed           = &Error{Code: CodeReplayed}
+	ErrUnknownUID         = &Error{Code: CodeUnknownUID}
+	ErrDeletedUID         = &Error{Code: CodeDeletedUID}
+	ErrDisabledUID        = &Error{Code: CodeDisabledUID}
+	ErrUIDUnavailable     = &Error{Code: CodeUIDUnavailable}
+	ErrInviteRequired     = &Error{Code: CodeInviteRequired}
+	ErrInvalidInvite      = &Error{Code: CodeInvalidInvite}
+	ErrRegistrationClosed = &Error{Code: CodeRegistrationClosed}
+	ErrForbidden          = &Error{Code: CodeForbidden}
+	ErrNotFound           = &Error{Code: CodeNotFound}
+	ErrRejected           = &Error{Code: CodeRejected}
+	ErrRateLimited        = &Error{Code: CodeRateLimited}
+	ErrInternal           = &Error{Code: CodeInternal}
+)
+
+var descriptions = map[Code]string{
+	CodeMalformed:          "The server did not understand the request. Is this client up to date?",
+	CodeInvalidKey:         "The server could not read this client's key.",
+	CodeWeakKey:            "The server refused this client's key as too weak.",
+	CodeBadSignature:       "The server could not verify this client's signature.",
+	CodeBadChallenge:       "Registration timed out, try again.",
+	CodeStale:              "The request was refused as too old. Check this device's clock.",
+	CodeReplayed:           "The request was refused as a repeat of an earlier one.",
+	CodeUnknownUID:         "No such UID or username.",
+	CodeDeletedUID:         "This contact has deleted their account.",
+	CodeDisabledUID:        "This account has been disabled by the server's admin.",
+	CodeUIDUnavailable:     "That UID is not available.",
+	CodeInviteRequired:     "This server needs an invite code to register.",
+	CodeInvalidInvite:      "The invite code is not valid, used up or expired.",
+	CodeRegistrationClosed: "This server is not accepting new registrations.",
+	CodeForbidden:          "This device is not allowed to do that.",
+	CodeNotFound:           "Not found.",
+	CodeRejected:           "The server refused the request.",
+	CodeRateLimited:        "Too many requests, try again later.",
+	CodeInternal:           "The server failed to handle the request, try again later.",
+}
+
+// Error is an error response from the server.
+type Error struct {
+	Code    Code
+	Message string
+}
+
+// Errorf returns an Error with code and a formatted message.
+func Errorf(code Code, format string, a ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return string(e.Code)
+	}
+
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Is reports whether target is the sentinel for e's code, so errors.Is(err,
+// ErrUnknownUID) matches any unknown uid response.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Message == "" && t.Code == e.Code
+}
+
+// Description is a sentence describing the error to a user, falling back to
+// the server's message for codes this client does not know.
+func (e *Error) Description() string {
+	d, ok := descriptions[e.Code]
+	if !ok {
+		return e.Error()
+	}
+
+	// These carry the server's reason, such as why a username can not be
+	// claimed.
+	if (e.Code == CodeRejected || e.Code == CodeNotFound) && e.Message != "" {
+		return e.Message
+	}
+
+	return d
+}
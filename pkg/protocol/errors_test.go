@@ -0,0 +1,27 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_ErrorIs(t *testing.T) {
+	err := error(Errorf(CodeUnknownUID, "uid %s does not exist", "123"))
+
+	if !errors.Is(err, ErrUnknownUID) {
+		t.Errorf("expected error to match its code's sentinel")
+	}
+	if errors.Is(err, ErrDeletedUID) {
+		t.Errorf("expected error not to match another code's sentinel")
+	}
+	if errors.Is(ErrUnknownUID, err) {
+		t.Errorf("expected only sentinels to match by code")
+	}
+
+	if d := (&Error{Code: "from a newer server", Message: "hello"}).Description(); d != "from a newer server: hello" {
+		t.Errorf("unexpected description of unknown code: %q", d)
+	}
+	if d := Errorf(CodeRejected, "username is already taken").Description(); d != "username is already taken" {
+		t.Errorf("unexpected description of rejection: %q", d)
+	}
+}
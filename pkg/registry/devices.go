@@ -0,0 +1,150 @@
+package registry
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/key"
+	"github.com/joshvanl/go-whisper/pkg/store"
+)
+
+const (
+	devicesDirectory = "devices"
+)
+
+var (
+	ErrUnknownDevice = errors.New("unknown device")
+)
+
+// Device is a key linked to an account in addition to its identity key in
+// uids/<uid>. Approval is the identity key's signature over
+// key.DeviceMessage(uid, Key), so contacts can check the device belongs to
+// the account without trusting the server.
+type Device struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Key      []byte    `json:"key"`
+	Approval []byte    `json:"approval"`
+	Added    time.Time `json:"added"`
+	Revoked  time.Time `json:"revoked,omitempty"`
+}
+
+// AddDevice links a device to id, returning the device's ID. Device IDs are
+// never reused, even after revocation.
+func (r *Registry) AddDevice(id string, d Device) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.uids[id] {
+		return "", fmt.Errorf("uid not stored on server: %s", id)
+	}
+
+	if err := os.MkdirAll(r.devicesPath(), 0700); err != nil {
+		return "", fmt.Errorf("failed to create devices directory: %v", err)
+	}
+
+	devices, err := r.readDevices(id)
+	if err != nil {
+		return "", err
+	}
+
+	d.ID = strconv.Itoa(len(devices) + 1)
+	devices = append(devices, d)
+
+	if err := store.WriteJSON(r.devicePath(id), devices); err != nil {
+		return "", fmt.Errorf("failed to write devices: %v", err)
+	}
+
+	return d.ID, nil
+}
+
+// Devices returns the linked devices of id that have not been revoked.
+func (r *Registry) Devices(id string) ([]Device, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	devices, err := r.readDevices(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var active []Device
+	for _, d := range devices {
+		if d.Revoked.IsZero() {
+			active = append(active, d)
+		}
+	}
+
+	return active, nil
+}
+
+// DeviceKey returns the public key of a linked device of id.
+func (r *Registry) DeviceKey(id, device string) (crypto.PublicKey, error) {
+	devices, err := r.Devices(id)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range devices {
+		if d.ID == device {
+			return key.ParsePublicKey(d.Key)
+		}
+	}
+
+	return nil, ErrUnknownDevice
+}
+
+// RevokeDevice unlinks a device from id.
+func (r *Registry) RevokeDevice(id, device string, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	devices, err := r.readDevices(id)
+	if err != nil {
+		return err
+	}
+
+	for i := range devices {
+		if devices[i].ID == device && devices[i].Revoked.IsZero() {
+			devices[i].Revoked = now
+
+			if err := store.WriteJSON(r.devicePath(id), devices); err != nil {
+				return fmt.Errorf("failed to write devices: %v", err)
+			}
+
+			return nil
+		}
+	}
+
+	return ErrUnknownDevice
+}
+
+func (r *Registry) readDevices(id string) ([]Device, error) {
+	var devices []Device
+	if _, err := store.ReadJSON(r.devicePath(id), &devices); err != nil {
+		return nil, fmt.Errorf("failed to read devices: %v", err)
+	}
+
+	return devices, nil
+}
+
+func (r *Registry) removeDevices(id string) error {
+	if err := os.Remove(r.devicePath(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove devices: %v", err)
+	}
+
+	return nil
+}
+
+func (r *Registry) devicesPath() string {
+	return filepath.Join(r.dir, devicesDirectory)
+}
+
+func (r *Registry) devicePath(id string) string {
+	return filepath.Join(r.devicesPath(), id+".json")
+}
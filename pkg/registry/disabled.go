@@ -0,0 +1,77 @@
+package registry
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/store"
+)
+
+const (
+	disabledFile = "disabled.json"
+)
+
+// Disable suspends the account for id. A disabled account keeps its uid, key
+// and devices, but the server refuses its requests until it is enabled.
+func (r *Registry) Disable(id string, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.uids[id] {
+		return fmt.Errorf("uid not stored on server: %s", id)
+	}
+
+	if _, ok := r.disabled[id]; ok {
+		return nil
+	}
+
+	r.disabled[id] = now
+	if err := store.WriteJSON(r.disabledPath(), r.disabled); err != nil {
+		delete(r.disabled, id)
+		return fmt.Errorf("failed to write disabled uids: %v", err)
+	}
+
+	return nil
+}
+
+// Enable lifts a suspension of id made by Disable.
+func (r *Registry) Enable(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.uids[id] {
+		return fmt.Errorf("uid not stored on server: %s", id)
+	}
+
+	return r.enable(id)
+}
+
+// Disabled returns when id was disabled, and whether it is.
+func (r *Registry) Disabled(id string) (time.Time, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	t, ok := r.disabled[id]
+	return t, ok
+}
+
+// enable must be called with the registry lock held.
+func (r *Registry) enable(id string) error {
+	t, ok := r.disabled[id]
+	if !ok {
+		return nil
+	}
+
+	delete(r.disabled, id)
+	if err := store.WriteJSON(r.disabledPath(), r.disabled); err != nil {
+		r.disabled[id] = t
+		return fmt.Errorf("failed to write disabled uids: %v", err)
+	}
+
+	return nil
+}
+
+func (r *Registry) disabledPath() string {
+	return filepath.Join(r.dir, disabledFile)
+}
@@ -0,0 +1,242 @@
+package registry
+
+import (
+	"bufio"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/key"
+	"github.com/joshvanl/go-whisper/pkg/transparency"
+	"github.com/joshvanl/go-whisper/pkg/uid"
+)
+
+const (
+	logFile = "transparency.log"
+)
+
+// LogEntry is one (uid, public key) binding in the key transparency log. A
+// nil Key records that the uid was deleted. The log file holds one JSON
+// entry per line and is only ever appended to.
+type LogEntry struct {
+	UID  string    `json:"uid"`
+	Key  []byte    `json:"key"`
+	Time time.Time `json:"time"`
+}
+
+// Proof is the log's evidence for the current key of a uid.
+type Proof struct {
+	// Key is the logged wire public key of the uid.
+	Key   []byte
+	Index uint64
+	Size  uint64
+	Root  []byte
+
+	Inclusion [][]byte
+	// Consistency proves the tree the client last saw is a prefix of this
+	// one.
+	Consistency [][]byte
+
+	// Transitions lead from the uid's first key to Key, oldest first.
+	Transitions []Transition
+}
+
+// Proof returns the inclusion proof of id's latest binding, the consistency
+// proof from the tree of size seen, and the key transitions of id, all as of
+// the same moment.
+func (r *Registry) Proof(id string, seen uint64) (*Proof, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	index, ok := r.latest[id]
+	if !ok || r.entries[index].Key == nil {
+		return nil, fmt.Errorf("uid not in transparency log: %s", id)
+	}
+
+	inclusion, err := r.tree.InclusionProof(index)
+	if err != nil {
+		return nil, err
+	}
+
+	consistency, err := r.tree.ConsistencyProof(seen)
+	if err != nil {
+		return nil, err
+	}
+
+	transitions, err := r.readTransitions(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Proof{
+		Key:         r.entries[index].Key,
+		Index:       index,
+		Size:        r.tree.Size(),
+		Root:        r.tree.Root(),
+		Inclusion:   inclusion,
+		Consistency: consistency,
+		Transitions: transitions,
+	}, nil
+}
+
+// TreeHead returns the unsigned current head of the log, and the
+// consistency proof from the tree of size seen.
+func (r *Registry) TreeHead(now time.Time, seen uint64) (*transparency.TreeHead, [][]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	consistency, err := r.tree.ConsistencyProof(seen)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &transparency.TreeHead{
+		Size: r.tree.Size(),
+		Root: r.tree.Root(),
+		Time: now,
+	}, consistency, nil
+}
+
+// Registered returns when id was first logged, which for uids registered
+// before the log existed is when the log was created.
+func (r *Registry) Registered(id string) (time.Time, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// Deleted uids are never handed out again, so the first entry for id
+	// is its registration.
+	for _, entry := range r.entries {
+		if entry.UID == id {
+			return entry.Time, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// readLog loads the log and logs the key of any uid whose file does not
+// match its latest entry, such as uids registered before the log existed. It
+// must be called with the registry lock held.
+func (r *Registry) readLog() error {
+	r.entries, r.tree, r.latest = nil, new(transparency.Tree), make(map[string]uint64)
+
+	f, err := os.Open(r.logPath())
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to open transparency log: %v", err)
+	}
+
+	if err == nil {
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			var entry LogEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				return fmt.Errorf("failed to parse transparency log entry %d: %v", len(r.entries), err)
+			}
+
+			r.addEntry(entry)
+		}
+
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("failed to read transparency log: %v", err)
+		}
+	}
+
+	var ids []string
+	for id := range r.uids {
+		if id != uid.Key(uid.Server) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		pk, err := r.key.ReadUidFile(id)
+		if err != nil {
+			return fmt.Errorf("failed to read uid public key %s: %v", id, err)
+		}
+
+		if index, ok := r.latest[id]; ok && equalKey(pk, r.entries[index].Key) {
+			continue
+		}
+
+		if err := r.appendLog(id, pk, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	for id := range r.deleted {
+		if index, ok := r.latest[id]; ok && r.entries[index].Key != nil {
+			if err := r.appendLog(id, nil, time.Now()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// appendLog records id holding pk, or its deletion if pk is nil. It must be
+// called with the registry lock held.
+func (r *Registry) appendLog(id string, pk crypto.PublicKey, now time.Time) error {
+	entry := LogEntry{
+		UID:  id,
+		Time: now,
+	}
+
+	if pk != nil {
+		b, err := key.MarshalPublicKey(pk)
+		if err != nil {
+			return err
+		}
+		entry.Key = b
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode transparency log entry: %v", err)
+	}
+
+	f, err := os.OpenFile(r.logPath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open transparency log: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to append to transparency log: %v", err)
+	}
+
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync transparency log: %v", err)
+	}
+
+	r.addEntry(entry)
+
+	return nil
+}
+
+func (r *Registry) addEntry(entry LogEntry) {
+	r.latest[entry.UID] = uint64(len(r.entries))
+	r.entries = append(r.entries, entry)
+	r.tree.Append(transparency.LeafHash(transparency.Binding(entry.UID, entry.Key)))
+}
+
+func (r *Registry) logPath() string {
+	return filepath.Join(r.dir, logFile)
+}
+
+func equalKey(pk crypto.PublicKey, b []byte) bool {
+	pkB, err := key.MarshalPublicKey(pk)
+	if err != nil {
+		return false
+	}
+
+	return string(pkB) == string(b)
+}
@@ -0,0 +1,245 @@
+package registry
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/key"
+	"github.com/joshvanl/go-whisper/pkg/store"
+	"github.com/joshvanl/go-whisper/pkg/transparency"
+	"github.com/joshvanl/go-whisper/pkg/uid"
+)
+
+const (
+	deletedFile = "deleted.json"
+)
+
+var (
+	ErrDeleted = errors.New("uid has been deleted")
+)
+
+// Registry is the server's set of registered client UIDs. All access goes
+// through the registry so that concurrent connection handlers never race on
+// allocation, and every allocated UID is persisted before it is handed out.
+type Registry struct {
+	mu    sync.RWMutex
+	dir   string
+	key   *key.Key
+	alloc uid.Allocator
+	uids  map[string]bool
+
+	// deleted holds tombstones of deleted accounts. Deleted UIDs are never
+	// handed out again so nobody can take over a deleted account's UID.
+	deleted map[string]time.Time
+
+	// disabled holds accounts suspended by the server's admin.
+	disabled map[string]time.Time
+
+	// entries is the key transparency log, tree the Merkle tree of their
+	// hashes, and latest the index of each uid's newest entry.
+	entries []LogEntry
+	tree    *transparency.Tree
+	latest  map[string]uint64
+}
+
+func New(dir string, k *key.Key, alloc uid.Allocator) (*Registry, error) {
+	r := &Registry{
+		dir:   dir,
+		key:   k,
+		alloc: alloc,
+	}
+
+	if err := r.Refresh(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Refresh reloads the set of UIDs from the uids directory on disk.
+func (r *Registry) Refresh() error {
+	uids, err := r.key.UIDsFromFile()
+	if err != nil {
+		return fmt.Errorf("failed to read uids from file: %v", err)
+	}
+
+	deleted := make(map[string]time.Time)
+	if _, err := store.ReadJSON(r.deletedPath(), &deleted); err != nil {
+		return fmt.Errorf("failed to read deleted uids: %v", err)
+	}
+
+	disabled := make(map[string]time.Time)
+	if _, err := store.ReadJSON(r.disabledPath(), &disabled); err != nil {
+		return fmt.Errorf("failed to read disabled uids: %v", err)
+	}
+
+	// A uid file left behind by an interrupted delete is still deleted.
+	for id := range deleted {
+		delete(uids, id)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.uids = uids
+	r.deleted = deleted
+	r.disabled = disabled
+
+	if res, ok := r.alloc.(uid.Resumer); ok {
+		res.Resume(r.highest())
+	}
+
+	return r.readLog()
+}
+
+// Register allocates a new unused UID and stores pk against it. requested is
+// the UID the client asked for, or 0 to let the allocator choose. The UID is
+// only returned once its key file has been written.
+func (r *Registry) Register(pk crypto.PublicKey, requested uint64) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for {
+		n, err := r.alloc.Allocate(r.taken, requested)
+		if err != nil {
+			return "", err
+		}
+		id := uid.Key(n)
+
+		if err := r.key.CreateUidFile(id, pk); err != nil {
+			// Another process may have registered this uid behind our
+			// back; remember it and try again.
+			if os.IsExist(err) {
+				r.uids[id] = true
+				continue
+			}
+
+			return "", fmt.Errorf("failed to store client public key: %v", err)
+		}
+
+		r.uids[id] = true
+
+		if err := r.appendLog(id, pk, time.Now()); err != nil {
+			return "", err
+		}
+
+		return id, nil
+	}
+}
+
+// Delete removes the account for id. The tombstone is persisted before the
+// key file is removed.
+func (r *Registry) Delete(id string, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.uids[id] {
+		return fmt.Errorf("uid not stored on server: %s", id)
+	}
+
+	r.deleted[id] = now
+	if err := store.WriteJSON(r.deletedPath(), r.deleted); err != nil {
+		delete(r.deleted, id)
+		return fmt.Errorf("failed to write deleted uids: %v", err)
+	}
+
+	delete(r.uids, id)
+
+	if err := r.enable(id); err != nil {
+		return err
+	}
+
+	if err := r.appendLog(id, nil, now); err != nil {
+		return err
+	}
+
+	if err := r.removeTransitions(id); err != nil {
+		return err
+	}
+
+	if err := r.removeDevices(id); err != nil {
+		return err
+	}
+
+	return r.key.RemoveUidFile(id)
+}
+
+// Deleted reports whether id belonged to an account that has been deleted.
+func (r *Registry) Deleted(id string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.deleted[id]
+	return ok
+}
+
+func (r *Registry) Exists(id string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.uids[id]
+}
+
+func (r *Registry) PublicKey(id string) (crypto.PublicKey, error) {
+	if r.Deleted(id) {
+		return nil, ErrDeleted
+	}
+
+	if !r.Exists(id) {
+		return nil, fmt.Errorf("uid not stored on server: %s", id)
+	}
+
+	return r.key.ReadUidFile(id)
+}
+
+func (r *Registry) UIDs() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var uids []string
+	for id, b := range r.uids {
+		if b {
+			uids = append(uids, id)
+		}
+	}
+
+	sort.Strings(uids)
+
+	return uids
+}
+
+// highest returns the highest uid registered or deleted. It must be called
+// with the registry lock held.
+func (r *Registry) highest() uint64 {
+	var max uint64
+	check := func(id string) {
+		if n, err := uid.Parse(id); err == nil && n > max {
+			max = n
+		}
+	}
+
+	for id := range r.uids {
+		check(id)
+	}
+	for id := range r.deleted {
+		check(id)
+	}
+
+	return max
+}
+
+// taken must be called with the registry lock held.
+func (r *Registry) taken(n uint64) bool {
+	_, deleted := r.deleted[uid.Key(n)]
+	return n == uid.Server || deleted || r.uids[uid.Key(n)]
+}
+
+func (r *Registry) deletedPath() string {
+	return filepath.Join(r.dir, deletedFile)
+}
@@ -0,0 +1,495 @@
+package registry
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/key"
+	"github.com/joshvanl/go-whisper/pkg/transparency"
+	"github.com/joshvanl/go-whisper/pkg/uid"
+)
+
+func newTestRegistry(t *testing.T, strategy string) (*Registry, func()) {
+	dir, err := ioutil.TempDir("", "go-whisper-registry")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+
+	k, err := key.New(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := k.NewUIDs(0); err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	alloc, err := uid.NewAllocator(strategy)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r, err := New(dir, k, alloc)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return r, func() { os.RemoveAll(dir) }
+}
+
+func Test_ConcurrentRegister(t *testing.T) {
+	for _, strategy := range []string{uid.StrategyRandom, uid.StrategySequential} {
+		testConcurrentRegister(t, strategy)
+	}
+}
+
+func testConcurrentRegister(t *testing.T, strategy string) {
+	r, cleanup := newTestRegistry(t, strategy)
+	defer cleanup()
+
+	const workers = 32
+	const perWorker = 8
+
+	pk := r.key.Public()
+
+	var wg sync.WaitGroup
+	uidCh := make(chan string, workers*perWorker)
+	errCh := make(chan error, workers*perWorker)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < perWorker; j++ {
+				id, err := r.Register(pk, 0)
+				if err != nil {
+					errCh <- err
+					continue
+				}
+				uidCh <- id
+
+				// interleave readers with writers
+				if !r.Exists(id) {
+					errCh <- os.ErrNotExist
+				}
+				r.UIDs()
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(uidCh)
+	close(errCh)
+
+	for err := range errCh {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	for id := range uidCh {
+		if seen[id] {
+			t.Errorf("%s: uid allocated twice: %s", strategy, id)
+		}
+		seen[id] = true
+
+		if id == "0" {
+			t.Errorf("%s: reserved uid 0 was allocated", strategy)
+		}
+	}
+
+	if len(seen) != workers*perWorker {
+		t.Errorf("unexpected number of uids, exp=%d got=%d", workers*perWorker, len(seen))
+	}
+
+	// Everything allocated must have been persisted.
+	onDisk, err := New(r.dir, r.key, r.alloc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := len(onDisk.UIDs()); got != len(seen) {
+		t.Errorf("unexpected number of uids on disk, exp=%d got=%d", len(seen), got)
+	}
+
+	for id := range seen {
+		if _, err := onDisk.PublicKey(id); err != nil {
+			t.Errorf("failed to read persisted uid %s: %v", id, err)
+		}
+	}
+}
+
+func Test_RegisterSkipsExistingFiles(t *testing.T) {
+	r, cleanup := newTestRegistry(t, uid.StrategySequential)
+	defer cleanup()
+
+	pk := r.key.Public()
+
+	// Simulate another process taking the first sequential uid.
+	if err := r.key.CreateUidFile(uid.Key(uid.WithCheckDigit(1)), pk); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	id, err := r.Register(pk, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if exp := uid.Key(uid.WithCheckDigit(2)); id != exp {
+		t.Errorf("unexpected uid, exp=%s got=%s", exp, id)
+	}
+}
+
+func Test_RegisterSequentialResumes(t *testing.T) {
+	r, cleanup := newTestRegistry(t, uid.StrategySequential)
+	defer cleanup()
+
+	pk := r.key.Public()
+
+	// An account registered before the server last started, with free uids
+	// below it.
+	if err := r.key.CreateUidFile(uid.Key(uid.WithCheckDigit(10)), pk); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	alloc, err := uid.NewAllocator(uid.StrategySequential)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	restarted, err := New(r.dir, r.key, alloc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	id, err := restarted.Register(pk, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if exp := uid.Key(uid.WithCheckDigit(11)); id != exp {
+		t.Errorf("unexpected uid, exp=%s got=%s", exp, id)
+	}
+}
+
+func Test_RegisterHandle(t *testing.T) {
+	r, cleanup := newTestRegistry(t, uid.StrategyHandle)
+	defer cleanup()
+
+	pk := r.key.Public()
+	want := uid.WithCheckDigit(4242424242)
+
+	id, err := r.Register(pk, want)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if id != uid.Key(want) {
+		t.Errorf("unexpected uid, exp=%s got=%s", uid.Key(want), id)
+	}
+
+	if _, err := r.Register(pk, want); err != uid.ErrTaken {
+		t.Errorf("expected taken error, got=%v", err)
+	}
+
+	if _, err := r.Register(pk, want+1); err != uid.ErrInvalid {
+		t.Errorf("expected invalid error, got=%v", err)
+	}
+}
+
+func Test_Delete(t *testing.T) {
+	r, cleanup := newTestRegistry(t, uid.StrategyHandle)
+	defer cleanup()
+
+	pk := r.key.Public()
+	want := uid.WithCheckDigit(1234)
+
+	id, err := r.Register(pk, want)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := r.Delete(id, time.Now()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if r.Exists(id) || !r.Deleted(id) {
+		t.Errorf("expected uid to be deleted")
+	}
+
+	if _, err := r.PublicKey(id); err != ErrDeleted {
+		t.Errorf("expected deleted error, got=%v", err)
+	}
+
+	// Deleted uids can never be registered again, even after a restart.
+	r, err = New(r.dir, r.key, r.alloc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := r.Register(pk, want); err != uid.ErrTaken {
+		t.Errorf("expected taken error, got=%v", err)
+	}
+}
+
+func Test_TransparencyLog(t *testing.T) {
+	r, cleanup := newTestRegistry(t, uid.StrategySequential)
+	defer cleanup()
+
+	pk := r.key.Public()
+
+	id, err := r.Register(pk, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	first, err := r.Proof(id, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sk, err := key.GenerateType(key.TypeEd25519)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	oldpk, err := key.MarshalPublicKey(pk)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := r.Rotate(id, sk.Public(), Transition{Old: oldpk, Time: time.Now()}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	other, err := r.Register(pk, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := r.Delete(other, time.Now()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	proof, err := r.Proof(id, first.Size)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	newpk, err := key.MarshalPublicKey(sk.Public())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(proof.Key) != string(newpk) {
+		t.Errorf("expected proof for rotated key")
+	}
+
+	leaf := transparency.LeafHash(transparency.Binding(id, proof.Key))
+	if err := transparency.VerifyInclusion(leaf, proof.Index, proof.Size, proof.Inclusion, proof.Root); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := transparency.VerifyConsistency(first.Size, proof.Size, first.Root, proof.Root, proof.Consistency); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if _, err := r.Proof(other, 0); err == nil {
+		t.Errorf("expected error for proof of deleted uid")
+	}
+
+	// The log is read back unchanged.
+	head, _, err := r.TreeHead(time.Now(), 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := r.Refresh(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, _, err := r.TreeHead(time.Now(), 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Size != head.Size || string(got.Root) != string(head.Root) {
+		t.Errorf("expected same tree head after refresh, exp=%d got=%d", head.Size, got.Size)
+	}
+}
+
+func Test_ConcurrentRotate(t *testing.T) {
+	r, cleanup := newTestRegistry(t, uid.StrategySequential)
+	defer cleanup()
+
+	pk := r.key.Public()
+	oldpk, err := key.MarshalPublicKey(pk)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	id, err := r.Register(pk, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Rotations signed by the same old key race; only one may be made, or
+	// the transitions would no longer form a chain.
+	const n = 8
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			sk, err := key.GenerateType(key.TypeEd25519)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			errs <- r.Rotate(id, sk.Public(), Transition{Old: oldpk, Time: time.Now()})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	var rotated int
+	for err := range errs {
+		switch err {
+		case nil:
+			rotated++
+		case ErrKeyChanged:
+		default:
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if rotated != 1 {
+		t.Errorf("unexpected number of rotations, exp=1 got=%d", rotated)
+	}
+
+	transitions, err := r.Transitions(id)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(transitions) != 1 {
+		t.Errorf("unexpected number of transitions, exp=1 got=%d", len(transitions))
+	}
+
+	// The proof's key and transitions are read together, so they agree.
+	proof, err := r.Proof(id, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(proof.Transitions) != 1 || string(proof.Transitions[0].New) != string(proof.Key) {
+		t.Errorf("expected proof transitions to lead to its key")
+	}
+}
+
+func Test_Devices(t *testing.T) {
+	r, cleanup := newTestRegistry(t, uid.StrategySequential)
+	defer cleanup()
+
+	id, err := r.Register(r.key.Public(), 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pk := r.key.PublicKey()
+
+	first, err := r.AddDevice(id, Device{Name: "laptop", Key: pk, Added: time.Now()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	second, err := r.AddDevice(id, Device{Name: "phone", Key: pk, Added: time.Now()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := r.RevokeDevice(id, first, time.Now()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := r.RevokeDevice(id, first, time.Now()); err != ErrUnknownDevice {
+		t.Errorf("expected unknown device revoking twice, got=%v", err)
+	}
+
+	if _, err := r.DeviceKey(id, first); err != ErrUnknownDevice {
+		t.Errorf("expected unknown device for revoked device, got=%v", err)
+	}
+	if _, err := r.DeviceKey(id, second); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Revoked device IDs are never reused.
+	third, err := r.AddDevice(id, Device{Name: "tablet", Key: pk, Added: time.Now()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if third == first || third == second {
+		t.Errorf("expected new device ID, got=%s", third)
+	}
+
+	devices, err := r.Devices(id)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(devices) != 2 {
+		t.Errorf("unexpected number of devices, exp=2 got=%d", len(devices))
+	}
+
+	if err := r.Delete(id, time.Now()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if devices, _ := r.Devices(id); len(devices) != 0 {
+		t.Errorf("expected devices removed with account, got=%d", len(devices))
+	}
+}
+
+func Test_Disable(t *testing.T) {
+	r, cleanup := newTestRegistry(t, uid.StrategySequential)
+	defer cleanup()
+
+	id, err := r.Register(r.key.Public(), 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, ok := r.Disabled(id); ok {
+		t.Errorf("expected new uid not to be disabled")
+	}
+
+	if err := r.Disable("0", time.Now()); err == nil {
+		t.Errorf("expected error disabling unknown uid")
+	}
+
+	if err := r.Disable(id, time.Now()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Suspensions survive a restart.
+	r, err = New(r.dir, r.key, r.alloc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := r.Disabled(id); !ok || !r.Exists(id) {
+		t.Errorf("expected uid to exist and be disabled after restart")
+	}
+
+	if err := r.Enable(id); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := r.Disabled(id); ok {
+		t.Errorf("expected uid to be enabled")
+	}
+
+	// Deleting a disabled account clears its suspension.
+	if err := r.Disable(id, time.Now()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := r.Delete(id, time.Now()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := r.Disabled(id); ok {
+		t.Errorf("expected deleted uid not to be disabled")
+	}
+}
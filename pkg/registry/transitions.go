@@ -0,0 +1,112 @@
+package registry
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/key"
+	"github.com/joshvanl/go-whisper/pkg/store"
+)
+
+const (
+	transitionsDirectory = "transitions"
+)
+
+var (
+	// ErrKeyChanged is returned by Rotate when the key being rotated from is
+	// no longer the stored key, such as after another rotation.
+	ErrKeyChanged = errors.New("key has changed since the rotation was signed")
+)
+
+// Transition records a UID rotating its identity key. Signature is the old
+// key's signature over key.TransitionMessage(uid, New), so anyone who trusted
+// Old can verify the move to New without trusting the server.
+type Transition struct {
+	Old       []byte    `json:"old"`
+	New       []byte    `json:"new"`
+	Signature []byte    `json:"signature"`
+	Time      time.Time `json:"time"`
+}
+
+// Rotate replaces the stored key for id with newpk, recording t so contacts
+// can follow the change. t.Old must be the stored key, so of two rotations
+// signed by the same key only the first is made.
+func (r *Registry) Rotate(id string, newpk crypto.PublicKey, t Transition) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.uids[id] {
+		return fmt.Errorf("uid not stored on server: %s", id)
+	}
+
+	current, err := r.key.ReadUidFile(id)
+	if err != nil {
+		return fmt.Errorf("failed to read uid public key: %v", err)
+	}
+	if !equalKey(current, t.Old) {
+		return ErrKeyChanged
+	}
+
+	if err := os.MkdirAll(r.transitionsPath(), 0700); err != nil {
+		return fmt.Errorf("failed to create transitions directory: %v", err)
+	}
+
+	transitions, err := r.readTransitions(id)
+	if err != nil {
+		return err
+	}
+
+	// The transition is written first; a transition with no matching key
+	// file is harmless, a new key with no transition is not verifiable.
+	t.New, err = key.MarshalPublicKey(newpk)
+	if err != nil {
+		return err
+	}
+	transitions = append(transitions, t)
+	if err := store.WriteJSON(r.transitionPath(id), transitions); err != nil {
+		return fmt.Errorf("failed to write key transitions: %v", err)
+	}
+
+	if err := r.key.ReplaceUidFile(id, newpk); err != nil {
+		return fmt.Errorf("failed to replace uid public key: %v", err)
+	}
+
+	return r.appendLog(id, newpk, t.Time)
+}
+
+// Transitions returns every key rotation of id, oldest first.
+func (r *Registry) Transitions(id string) ([]Transition, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.readTransitions(id)
+}
+
+func (r *Registry) readTransitions(id string) ([]Transition, error) {
+	var transitions []Transition
+	if _, err := store.ReadJSON(r.transitionPath(id), &transitions); err != nil {
+		return nil, fmt.Errorf("failed to read key transitions: %v", err)
+	}
+
+	return transitions, nil
+}
+
+func (r *Registry) removeTransitions(id string) error {
+	if err := os.Remove(r.transitionPath(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove key transitions: %v", err)
+	}
+
+	return nil
+}
+
+func (r *Registry) transitionsPath() string {
+	return filepath.Join(r.dir, transitionsDirectory)
+}
+
+func (r *Registry) transitionPath(id string) string {
+	return filepath.Join(r.transitionsPath(), id+".json")
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/audit"
+	"github.com/joshvanl/go-whisper/pkg/connection"
+	"github.com/joshvanl/go-whisper/pkg/key"
+	"github.com/joshvanl/go-whisper/pkg/protocol"
+	"github.com/joshvanl/go-whisper/pkg/registry"
+)
+
+// deleteAccount handles:
+//
+//	"delete account", uid, timestamp, nonce, signature
+//
+// The account's key is removed and its uid tombstoned so contacts querying
+// it are told the key is gone, rather than that it never existed.
+func (s *Server) deleteAccount(conn *connection.Connection, recv [][]byte) error {
+	if len(recv) != 5 {
+		return newRequestError(protocol.CodeMalformed, "unexpected number of parameters, exp=5 got=%d", len(recv))
+	}
+
+	clientUid, err := s.verifyPrimary(conn, recv)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	if err := s.registry.Delete(clientUid, now); err != nil {
+		return fmt.Errorf("failed to delete account: %v", err)
+	}
+
+	// Released only once the account is gone, so a failed delete leaves
+	// the account with its username.
+	if err := s.usernames.Release(clientUid, now); err != nil {
+		return fmt.Errorf("failed to release username: %v", err)
+	}
+
+	s.connLog(conn).Infof("deleted account")
+	s.record(conn, audit.Entry{Event: audit.EventAccountDeleted})
+
+	if err := s.writeSigned(conn, connection.Params([]byte("account deleted"))); err != nil {
+		return fmt.Errorf("failed to write to delete account: %v", err)
+	}
+
+	return nil
+}
+
+// rotateKey handles:
+//
+//	"rotate key", uid, new public key, old signature, new signature, timestamp, nonce, signature
+//
+// Both signatures are over key.TransitionMessage(uid, new public key); the
+// old one proves the account owner authorised the change, the new one that
+// they hold the new key.
+func (s *Server) rotateKey(conn *connection.Connection, recv [][]byte) error {
+	if len(recv) != 8 {
+		return newRequestError(protocol.CodeMalformed, "unexpected number of parameters, exp=8 got=%d", len(recv))
+	}
+
+	clientUid, err := s.verifyPrimary(conn, recv)
+	if err != nil {
+		return err
+	}
+
+	newpkB, oldSig, newSig := recv[2], recv[3], recv[4]
+
+	newpk, err := key.ParsePublicKey(newpkB)
+	if err != nil {
+		return newRequestError(protocol.CodeInvalidKey, "failed to parse new public key: %v", err)
+	}
+	if err := key.CheckPublicKey(newpk); err == key.ErrWeakKey {
+		return newRequestError(protocol.CodeWeakKey, "keys must be Ed25519 or RSA of at least %d bits", key.MinRSAKeySize)
+	} else if err != nil {
+		return newRequestError(protocol.CodeInvalidKey, "%v", err)
+	}
+
+	oldpk, err := s.registry.PublicKey(clientUid)
+	if err != nil {
+		return fmt.Errorf("failed to get client public key: %v", err)
+	}
+
+	transition := key.TransitionMessage(clientUid, newpkB)
+	if err := s.key.VerifyPayload(oldpk, transition, oldSig); err != nil {
+		return s.badSignature(conn, string(recv[0]), clientUid, "", "failed to verify old key transition signature: %v", err)
+	}
+	if err := s.key.VerifyPayload(newpk, transition, newSig); err != nil {
+		return s.badSignature(conn, string(recv[0]), clientUid, "", "failed to verify new key transition signature: %v", err)
+	}
+
+	oldpkB, err := key.MarshalPublicKey(oldpk)
+	if err != nil {
+		return err
+	}
+
+	// The old key is checked again under the registry lock, in case
+	// another rotation was made since it was read.
+	err = s.registry.Rotate(clientUid, newpk, registry.Transition{
+		Old:       oldpkB,
+		Signature: oldSig,
+		Time:      time.Now(),
+	})
+	if err == registry.ErrKeyChanged {
+		return newRequestError(protocol.CodeRejected, "key of %s was rotated by another request", clientUid)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to rotate key: %v", err)
+	}
+
+	s.connLog(conn).Infof("rotated key")
+	s.record(conn, audit.Entry{
+		Event:  audit.EventKeyRotated,
+		Detail: keyDetail(clientUid, newpk),
+	})
+
+	if err := s.writeSigned(conn, connection.Params([]byte("key rotated"))); err != nil {
+		return fmt.Errorf("failed to write to rotate key: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/audit"
+	"github.com/joshvanl/go-whisper/pkg/key"
+	"github.com/joshvanl/go-whisper/pkg/registry"
+	"github.com/joshvanl/go-whisper/pkg/uid"
+	"github.com/joshvanl/go-whisper/pkg/username"
+)
+
+// Account is an admin's view of a registered account.
+type Account struct {
+	UID      string `json:"uid"`
+	Username string `json:"username,omitempty"`
+
+	KeyType     key.Type `json:"keyType"`
+	Fingerprint string   `json:"fingerprint"`
+	Devices     int      `json:"devices"`
+	Rotations   int      `json:"rotations"`
+
+	Registered time.Time `json:"registered"`
+	// Disabled is zero unless the account is disabled.
+	Disabled time.Time `json:"disabled,omitempty"`
+}
+
+// Admin manages the accounts stored in a server directory. Changes made
+// through it are not seen by a server already running on the directory until
+// it is reloaded; use its AdminClient instead.
+type Admin struct {
+	accounts
+}
+
+// accounts implements account management over a registry and username
+// directory, shared by Admin and the running server.
+type accounts struct {
+	registry  *registry.Registry
+	usernames *username.Directory
+	audit     *audit.Log
+}
+
+// OpenAdmin reads the accounts stored in the server directory dir.
+func OpenAdmin(dir string) (*Admin, error) {
+	k, err := key.New(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read server key: %v", err)
+	}
+
+	options, err := ReadOptions(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read options: %v", err)
+	}
+
+	if err := k.NewUIDs(0); err != nil {
+		return nil, err
+	}
+
+	alloc, err := uid.NewAllocator(options.UIDStrategy)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := registry.New(dir, k, alloc)
+	if err != nil {
+		return nil, err
+	}
+
+	usernames, err := username.NewDirectory(dir, username.Rules{
+		ChangeInterval: options.UsernameChangeInterval.Duration,
+		HoldPeriod:     options.UsernameHoldPeriod.Duration,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Admin{
+		accounts: accounts{
+			registry:  r,
+			usernames: usernames,
+			audit:     audit.Open(dir, k),
+		},
+	}, nil
+}
+
+// Accounts returns every registered account, ordered by uid.
+func (a *accounts) Accounts() ([]Account, error) {
+	var list []Account
+	for _, id := range a.registry.UIDs() {
+		if id == uid.Key(uid.Server) {
+			continue
+		}
+
+		account, err := a.Account(id)
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, *account)
+	}
+
+	return list, nil
+}
+
+// Account returns the account registered to id.
+func (a *accounts) Account(id string) (*Account, error) {
+	if a.registry.Deleted(id) {
+		return nil, registry.ErrDeleted
+	}
+
+	pk, err := a.registry.PublicKey(id)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := key.TypeOf(pk)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint, err := key.Fingerprint(id, pk)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute fingerprint of %s: %v", id, err)
+	}
+
+	devices, err := a.registry.Devices(id)
+	if err != nil {
+		return nil, err
+	}
+
+	transitions, err := a.registry.Transitions(id)
+	if err != nil {
+		return nil, err
+	}
+
+	account := &Account{
+		UID:         id,
+		KeyType:     t,
+		Fingerprint: fingerprint,
+		Devices:     len(devices),
+		Rotations:   len(transitions),
+	}
+	account.Username, _ = a.usernames.Username(id)
+	account.Registered, _ = a.registry.Registered(id)
+	account.Disabled, _ = a.registry.Disabled(id)
+
+	return account, nil
+}
+
+// Disable suspends id so the server refuses its requests. Contacts can still
+// look up its key.
+func (a *accounts) Disable(id string) error {
+	if err := a.registry.Disable(id, time.Now()); err != nil {
+		return err
+	}
+
+	return a.record("disable", id)
+}
+
+// Enable lifts a suspension made by Disable.
+func (a *accounts) Enable(id string) error {
+	if err := a.registry.Enable(id); err != nil {
+		return err
+	}
+
+	return a.record("enable", id)
+}
+
+// Delete deletes id as if its owner had, releasing its username and
+// tombstoning its uid.
+func (a *accounts) Delete(id string) error {
+	if !a.registry.Exists(id) {
+		return fmt.Errorf("uid not stored on server: %s", id)
+	}
+
+	now := time.Now()
+
+	if err := a.registry.Delete(id, now); err != nil {
+		return fmt.Errorf("failed to delete account: %v", err)
+	}
+
+	if err := a.usernames.Release(id, now); err != nil {
+		return fmt.Errorf("failed to release username: %v", err)
+	}
+
+	return a.record("delete", id)
+}
+
+// record audits an admin command made on the account id.
+func (a *accounts) record(command, id string) error {
+	if err := a.audit.Record(adminEntry(command, id)); err != nil {
+		return fmt.Errorf("failed to record audit event: %v", err)
+	}
+
+	return nil
+}
+
+func adminEntry(command, id string) audit.Entry {
+	return audit.Entry{
+		Event:  audit.EventAdmin,
+		UID:    id,
+		Detail: map[string]string{"command": command},
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/joshvanl/go-whisper/pkg/key"
+)
+
+func Test_Admin(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	sk, err := key.GenerateType(key.TypeEd25519)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	id, err := s.registry.Register(sk.Public(), 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	admin, err := OpenAdmin(s.dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	accounts, err := admin.Accounts()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(accounts) != 1 || accounts[0].UID != id {
+		t.Fatalf("expected only account %s, got=%+v", id, accounts)
+	}
+
+	fingerprint, err := key.Fingerprint(id, sk.Public())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a := accounts[0]
+	if a.Fingerprint != fingerprint || a.KeyType != key.TypeEd25519 || a.Registered.IsZero() || !a.Disabled.IsZero() {
+		t.Errorf("unexpected account details: %+v", a)
+	}
+
+	if err := admin.Disable(id); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a, err := admin.Account(id); err != nil || a.Disabled.IsZero() {
+		t.Errorf("expected account to be disabled, got=%+v err=%v", a, err)
+	}
+
+	if err := admin.Delete(id); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := admin.Account(id); err == nil {
+		t.Errorf("expected error getting deleted account")
+	}
+	if err := admin.Delete(id); err == nil {
+		t.Errorf("expected error deleting account twice")
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+
+	"github.com/joshvanl/go-whisper/pkg/audit"
+	"github.com/joshvanl/go-whisper/pkg/connection"
+	"github.com/joshvanl/go-whisper/pkg/key"
+	"github.com/joshvanl/go-whisper/pkg/protocol"
+	"github.com/joshvanl/go-whisper/pkg/uid"
+)
+
+// record appends e to the audit log, adding the session details of conn if
+// it is not nil. Failures are logged rather than failing the request.
+func (s *Server) record(conn *connection.Connection, e audit.Entry) {
+	if conn != nil {
+		if session, ok := s.sessions.get(conn); ok {
+			e.Session, e.Address = session.ID, session.Address
+			if e.UID == "" {
+				e.UID, e.Device = session.UID, session.Device
+			}
+		}
+	}
+
+	if err := s.audit.Record(e); err != nil {
+		s.log.WithError(err).WithField("event", e.Event).Errorf("failed to record audit event")
+	}
+}
+
+// badSignature returns a bad signature error for a request claiming to be
+// from id, or device of id, and records it.
+func (s *Server) badSignature(conn *connection.Connection, command, id, device, format string, a ...interface{}) error {
+	err := protocol.Errorf(protocol.CodeBadSignature, format, a...)
+
+	s.record(conn, audit.Entry{
+		Event:  audit.EventBadSignature,
+		UID:    id,
+		Device: device,
+		Detail: map[string]string{
+			"command": command,
+			"reason":  err.Message,
+		},
+	})
+
+	return err
+}
+
+// rateLimited records the first request refused by a rate limit since the
+// source last had one allowed.
+func (s *Server) rateLimited(command, kind, source string) {
+	e := audit.Entry{
+		Event: audit.EventRateLimited,
+		Detail: map[string]string{
+			"command": command,
+			"limit":   kind,
+		},
+	}
+
+	if kind == limitByUID {
+		e.UID = source
+	} else {
+		e.Address = source
+	}
+
+	s.record(nil, e)
+}
+
+// checkServerKey records the server key having changed since the audit log
+// was last signed, such as by a rotation.
+func (s *Server) checkServerKey() error {
+	head, err := s.audit.Head()
+	if err != nil {
+		return err
+	}
+
+	if head == nil || bytes.Equal(head.Key, s.key.PublicKey()) {
+		return nil
+	}
+
+	s.record(nil, audit.Entry{
+		Event:  audit.EventServerKeyChanged,
+		UID:    uid.Key(uid.Server),
+		Detail: keyDetail(uid.Key(uid.Server), s.key.Public()),
+	})
+
+	return nil
+}
+
+// VerifyAudit checks the audit log in the server directory dir has not been
+// modified or truncated. Its head may be signed by the current server key or
+// any key in the retained key transitions.
+func VerifyAudit(dir string) (*audit.Result, error) {
+	exists, err := key.Exists(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("no server key in %s", dir)
+	}
+
+	k, err := key.New(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read server key: %v", err)
+	}
+
+	transitions, err := readServerTransitions(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	trusted := [][]byte{k.PublicKey()}
+	for _, t := range transitions {
+		trusted = append(trusted, t.Old, t.New)
+	}
+
+	return audit.Verify(dir, k, trusted)
+}
+
+// keyDetail describes the key of an audited event by its fingerprint.
+func keyDetail(id string, pk crypto.PublicKey) map[string]string {
+	fingerprint, err := key.Fingerprint(id, pk)
+	if err != nil {
+		return nil
+	}
+
+	return map[string]string{"key": fingerprint}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/audit"
+	"github.com/joshvanl/go-whisper/pkg/key"
+)
+
+func Test_Audit(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	conn := dialTestServer(t, s)
+	defer conn.Close()
+
+	sk, err := key.GenerateType(key.TypeEd25519)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	id, err := s.registry.Register(sk.Public(), 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	other, err := key.GenerateType(key.TypeEd25519)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	signedRequest(t, conn, other, "list devices", id)
+
+	// Only the first refusal by a limit is recorded.
+	s.limits.tripped = s.rateLimited
+	s.limits.setLimits(map[string]RateLimits{
+		anyRequest: {PerUID: Limit{Every: Duration{time.Hour}, Burst: 1}},
+	})
+	for i := 0; i < 3; i++ {
+		signedRequest(t, conn, sk, "list devices", id)
+	}
+
+	if err := s.accounts().Disable(id); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entries, err := audit.ReadLog(s.dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var events []string
+	for _, e := range entries {
+		events = append(events, e.Event)
+		if e.UID != id {
+			t.Errorf("%s: expected uid %s, got=%q", e.Event, id, e.UID)
+		}
+	}
+	exp := []string{audit.EventBadSignature, audit.EventRateLimited, audit.EventAdmin}
+	if strings.Join(events, ",") != strings.Join(exp, ",") {
+		t.Fatalf("unexpected events, exp=%q got=%q", exp, events)
+	}
+	if entries[0].Session == "" || entries[0].Address == "" {
+		t.Errorf("expected session details on bad signature, got=%+v", entries[0])
+	}
+
+	if _, err := VerifyAudit(s.dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A head signed before a key rotation is still trusted.
+	if _, err := RotateKey(s.dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	result, err := VerifyAudit(s.dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Entries != 3 {
+		t.Errorf("unexpected number of entries, exp=3 got=%d", result.Entries)
+	}
+}
@@ -1,159 +1,264 @@
 package server
 
 import (
-	"bytes"
-	"crypto/rand"
-	"crypto/x509"
+	"crypto"
 	"fmt"
-	"math/big"
+	"io"
+	"net"
+	"strings"
+	"time"
 
-	"github.com/joshvanl/go-whisper/pkg/connection"
-)
+	"github.com/sirupsen/logrus"
 
-const (
-	MaxNumber = 99999999999
-)
-
-var (
-	MessageBreak = []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	"github.com/joshvanl/go-whisper/pkg/connection"
+	"github.com/joshvanl/go-whisper/pkg/logging"
+	"github.com/joshvanl/go-whisper/pkg/protocol"
+	"github.com/joshvanl/go-whisper/pkg/registry"
+	"github.com/joshvanl/go-whisper/pkg/uid"
 )
 
+// Handle serves requests on conn until the client disconnects.
 func (s *Server) Handle(conn *connection.Connection) {
+	id := logging.NewSessionID()
+	s.handle(conn, id, s.sessionLog(id, conn.RemoteAddr()))
+}
 
-	payload, _, err := conn.Read()
-	if err != nil {
-		return
-	}
+// handle is Handle for a session whose ID and logger were made when it was
+// accepted.
+func (s *Server) handle(conn *connection.Connection, id string, log *logrus.Entry) {
+	defer conn.Close()
+	defer s.challenges.forget(conn)
 
-	if len(payload) == 0 {
-		return
-	}
+	s.sessions.open(conn, id, log, time.Now())
+	defer s.sessions.close(conn)
+
+	log.Debugf("session opened")
 
-	switch string(payload[0]) {
-	case "first connection":
-		if len(payload) != 3 {
+	for {
+		payload, _, err := conn.Read()
+		if err == io.EOF {
+			s.connLog(conn).Debugf("session closed by client")
+			return
+		}
+		if err == connection.ErrTimeout {
+			s.connLog(conn).Debugf("session closed as client stopped responding")
 			return
 		}
-
-		err = s.newClient(conn, payload)
 		if err != nil {
-			s.log.Errorf("error handling new user: %v", err)
+			s.connLog(conn).WithError(err).Debugf("session closed")
+			return
 		}
 
-		break
-
-	case "uid query":
-
-		if err := s.uidQuery(conn, payload); err != nil {
-			s.log.Errorf("error handling uid query: %v", err)
+		if len(payload) == 0 {
+			return
 		}
 
-		return
+		s.sessions.request(conn, time.Now())
+		s.handleRequest(conn, payload)
 	}
+}
 
+// handlers serves each request by its command, the first parameter.
+var handlers = map[string]func(*Server, *connection.Connection, [][]byte) error{
+	"registration challenge": (*Server).registrationChallenge,
+	"first connection":       (*Server).newClient,
+	"uid query":              (*Server).uidQuery,
+	"username query":         (*Server).usernameQuery,
+	"claim username":         (*Server).claimUsername,
+	"delete account":         (*Server).deleteAccount,
+	"rotate key":             (*Server).rotateKey,
+	"server key":             (*Server).serverKey,
+	"link request":           (*Server).linkRequest,
+	"link device":            (*Server).linkDevice,
+	"approve device":         (*Server).approveDevice,
+	"list devices":           (*Server).listDevices,
+	"revoke device":          (*Server).revokeDevice,
+	"uid devices":            (*Server).uidDevices,
+	"tree head":              (*Server).treeHead,
 }
 
-func (s *Server) uidQuery(conn *connection.Connection, recv [][]byte) error {
-	sig := recv[len(recv)-1]
+// handleRequest serves one request, answering any failure with an error
+// response so the client is not left waiting.
+func (s *Server) handleRequest(conn *connection.Connection, payload [][]byte) {
+	start := time.Now()
+
+	command := string(payload[0])
+	handle, ok := handlers[command]
 
-	p := recv[0]
-	for _, r := range recv[1 : len(recv)-1] {
-		p = appendParams(p, r)
+	err := s.limits.allowAddress(command, conn.RemoteAddr(), time.Now())
+	if err == nil {
+		if ok {
+			err = handle(s, conn, payload)
+		} else {
+			err = newRequestError(protocol.CodeMalformed, "unknown request %q", command)
+		}
 	}
 
-	recv[2] = bytes.TrimLeft(recv[2], "0")
+	took := time.Since(start)
+	s.metrics.request(command, err, took)
 
-	uids, err := s.key.UIDsFromFile()
-	if err != nil {
-		return fmt.Errorf("failed to get all uids on server: %v", err)
+	log := s.connLog(conn).WithField(logging.FieldCommand, command)
+
+	if err == nil {
+		log.Debugf("handled request in %s", took)
+		return
 	}
 
-	if b, ok := uids[string(recv[1])]; !ok || !b {
-		return fmt.Errorf("client uid not stored on server: %v", string(recv[1]))
+	// Refused requests are the client's doing; anything else is the
+	// server's.
+	if _, ok := err.(*protocol.Error); ok {
+		log.WithError(err).Infof("refused request")
+	} else {
+		log.WithError(err).Errorf("failed to handle request")
 	}
 
-	clientpk, err := s.key.ReadUidFile(string(recv[1]))
-	if err != nil {
-		return fmt.Errorf("failed to get client public key from file: %v", err)
+	if err := s.writeError(conn, err); err != nil {
+		log.WithError(err).Errorf("failed to write error response")
 	}
+}
 
-	if err := s.key.VerifyPayload(clientpk, p, sig); err != nil {
-		return fmt.Errorf("failed to verify client uid query: %v", err)
+// connLog returns the logger of conn's session, carrying its ID, address
+// and, once verified, uid.
+func (s *Server) connLog(conn *connection.Connection) *logrus.Entry {
+	if log := s.sessions.log(conn); log != nil {
+		return log
 	}
 
-	var message []byte
-	if b, ok := uids[string(recv[2])]; !ok || !b {
-		message = []byte("uid does not exist")
+	return s.log
+}
 
-	} else {
+func (s *Server) sessionLog(id string, addr net.Addr) *logrus.Entry {
+	return s.log.WithFields(logrus.Fields{
+		logging.FieldSession: id,
+		logging.FieldAddress: addr.String(),
+	})
+}
 
-		pk, err := s.key.ReadUidFile(string(recv[2]))
-		if err != nil {
-			return fmt.Errorf("failed to get uid pk from file: %v", err)
-		}
+// uidQuery handles:
+//
+//	"uid query", uid, queried uid, [seen tree size], timestamp, nonce,
+//	signature
+func (s *Server) uidQuery(conn *connection.Connection, recv [][]byte) error {
+	if len(recv) != 6 && len(recv) != 7 {
+		return newRequestError(protocol.CodeMalformed, "unexpected number of parameters, exp=6 or 7 got=%d", len(recv))
+	}
 
-		message = appendParams([]byte("uid found"), x509.MarshalPKCS1PublicKey(pk))
+	if _, err := s.verifyClient(conn, recv); err != nil {
+		return err
 	}
 
-	signiture, err := s.key.SignMessage(message)
+	seen, err := seenTreeSize(recv, 7)
 	if err != nil {
-		return fmt.Errorf("failed to sign message: %v", err)
+		return err
+	}
+
+	n, err := uid.Parse(string(recv[2]))
+	if err != nil {
+		return newRequestError(protocol.CodeMalformed, "failed to parse queried uid: %v", err)
 	}
+	recv[2] = []byte(uid.Key(n))
 
-	message = appendParams(message, signiture)
-	if err := conn.Write(message); err != nil {
+	if s.registry.Deleted(string(recv[2])) {
+		return newRequestError(protocol.CodeDeletedUID, "uid %s has been deleted", uid.Format(n))
+	}
+	if !s.registry.Exists(string(recv[2])) {
+		return newRequestError(protocol.CodeUnknownUID, "uid %s does not exist", uid.Format(n))
+	}
+
+	message, err := s.appendKey(connection.Params([]byte("uid found")), string(recv[2]), seen)
+	if err != nil {
+		return err
+	}
+
+	if err := s.writeSigned(conn, message); err != nil {
 		return fmt.Errorf("failed to write to uid query: %v", err)
 	}
 
 	return nil
 }
 
-func (s *Server) newClient(conn *connection.Connection, recv [][]byte) error {
-	uid, err := s.newUID()
+// verifyClient checks the trailing signature of a request whose second
+// parameter is the sending client's uid, or uid:device for a linked device,
+// and that its timestamp and nonce are fresh, returning the account uid.
+func (s *Server) verifyClient(conn *connection.Connection, recv [][]byte) (string, error) {
+	clientUid, _, err := s.verifyDevice(conn, recv)
+	return clientUid, err
+}
+
+// verifyPrimary is verifyClient for requests that only the account's
+// identity key may make, not its linked devices.
+func (s *Server) verifyPrimary(conn *connection.Connection, recv [][]byte) (string, error) {
+	clientUid, device, err := s.verifyDevice(conn, recv)
 	if err != nil {
-		fmt.Errorf("failed to create new uid: %v", err)
+		return "", err
 	}
 
-	pk, err := x509.ParsePKCS1PublicKey(recv[1])
-	if err != nil {
-		return fmt.Errorf("failed to parse client public key: %v", err)
+	if device != "" {
+		return "", newRequestError(protocol.CodeForbidden, "request from device %s of %s must be made by the account's identity key", device, clientUid)
 	}
 
-	if err := s.key.NewUidFile(uid, pk); err != nil {
-		return fmt.Errorf("failed to store client public key: %v", err)
+	return clientUid, nil
+}
+
+func (s *Server) verifyDevice(conn *connection.Connection, recv [][]byte) (string, string, error) {
+	if len(recv) < 3+freshnessParams {
+		return "", "", newRequestError(protocol.CodeMalformed, "request too short to be signed, got=%d parameters", len(recv))
 	}
 
-	message := append(append([]byte(uid), MessageBreak...), s.key.PublicKey()...)
-	signiture, err := s.key.SignMessage(message)
-	if err != nil {
-		return fmt.Errorf("failed to sign message for client: %v", err)
+	clientUid, device := string(recv[1]), ""
+	if i := strings.IndexByte(clientUid, ':'); i >= 0 {
+		clientUid, device = clientUid[:i], clientUid[i+1:]
 	}
 
-	payload := append(append(message, MessageBreak...), signiture...)
-	if err = conn.Write(payload); err != nil {
-		return fmt.Errorf("failed to send payload to client: %v", err)
+	if !s.registry.Exists(clientUid) {
+		return "", "", newRequestError(protocol.CodeUnknownUID, "sending uid %s is not registered", clientUid)
 	}
 
-	return nil
-}
+	if _, ok := s.registry.Disabled(clientUid); ok {
+		return "", "", newRequestError(protocol.CodeDisabledUID, "sending uid %s is disabled", clientUid)
+	}
 
-func (s *Server) newUID() (string, error) {
-	for {
-		n, err := rand.Int(rand.Reader, big.NewInt(MaxNumber))
-		if err != nil {
-			if err != nil {
-				return "", fmt.Errorf("failed to generate random number; %v", err)
-			}
+	var clientpk crypto.PublicKey
+	var err error
+	if device == "" {
+		clientpk, err = s.registry.PublicKey(clientUid)
+	} else {
+		clientpk, err = s.registry.DeviceKey(clientUid, device)
+		if err == registry.ErrUnknownDevice {
+			return "", "", newRequestError(protocol.CodeForbidden, "device %s of %s is not linked", device, clientUid)
 		}
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get client public key: %v", err)
+	}
 
-		if _, ok := s.clientUids[n.String()]; !ok {
-			s.clientUids[n.String()] = true
-			return n.String(), nil
-		}
+	if err := s.key.VerifyPayload(clientpk, connection.Params(recv[:len(recv)-1]...), recv[len(recv)-1]); err != nil {
+		return "", "", s.badSignature(conn, string(recv[0]), clientUid, device, "%v", err)
+	}
 
+	// Only signed requests are remembered, so nobody else can burn a
+	// client's nonces.
+	if err := s.replays.check(string(recv[1]), recv[len(recv)-3], recv[len(recv)-2], time.Now()); err != nil {
+		return "", "", err
 	}
+
+	// As with nonces, only verified requests count against an account's
+	// rate limits.
+	if err := s.limits.allowUID(string(recv[0]), clientUid, time.Now()); err != nil {
+		return "", "", err
+	}
+
+	s.sessions.identify(conn, clientUid, device)
+
+	return clientUid, device, nil
 }
 
-func appendParams(a, b []byte) []byte {
-	return append(append(a, MessageBreak...), b...)
+// writeSigned appends the server's signature to message and sends it.
+func (s *Server) writeSigned(conn *connection.Connection, message []byte) error {
+	signiture, err := s.key.SignMessage(message)
+	if err != nil {
+		return fmt.Errorf("failed to sign message: %v", err)
+	}
+
+	return conn.Write(connection.AppendParams(message, signiture))
 }
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/connection"
+	"github.com/joshvanl/go-whisper/pkg/key"
+	"github.com/joshvanl/go-whisper/pkg/protocol"
+	"github.com/joshvanl/go-whisper/pkg/uid"
+)
+
+// signedRequest sends a request from id signed by sk, with a fresh timestamp
+// and nonce, and returns the response.
+func signedRequest(t *testing.T, conn *connection.Connection, sk crypto.Signer, command, id string, params ...[]byte) [][]byte {
+	nonce := make([]byte, minNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return signedRequestNonce(t, conn, sk, nonce, command, id, params...)
+}
+
+// signedRequestNonce is signedRequest with the given nonce.
+func signedRequestNonce(t *testing.T, conn *connection.Connection, sk crypto.Signer, nonce []byte, command, id string, params ...[]byte) [][]byte {
+	message := connection.Params([]byte(command), []byte(id))
+	for _, p := range params {
+		message = connection.AppendParams(message, p)
+	}
+
+	message = connection.AppendParams(message, []byte(strconv.FormatInt(time.Now().UnixNano(), 10)))
+	message = connection.AppendParams(message, nonce)
+
+	sig, err := key.SignWith(sk, message)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := conn.Write(connection.AppendParams(message, sig)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	res, _, err := conn.Read()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return res
+}
+
+func Test_ErrorResponses(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	conn := dialTestServer(t, s)
+	defer conn.Close()
+
+	sk, err := key.GenerateType(key.TypeEd25519)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	id, err := s.registry.Register(sk.Public(), 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	missing := uid.Key(uid.WithCheckDigit(4242))
+
+	for _, test := range []struct {
+		name string
+		res  [][]byte
+		code protocol.Code
+	}{
+		{"unknown command", signedRequest(t, conn, sk, "make coffee", id), protocol.CodeMalformed},
+		{"unknown uid", signedRequest(t, conn, sk, "uid query", id, []byte(missing)), protocol.CodeUnknownUID},
+		{"unregistered sender", signedRequest(t, conn, sk, "uid query", missing, []byte(id)), protocol.CodeUnknownUID},
+		{"wrong parameters", signedRequest(t, conn, sk, "list devices", id, []byte("extra")), protocol.CodeMalformed},
+	} {
+		if len(test.res) != 4 || string(test.res[0]) != "error" || protocol.Code(test.res[1]) != test.code {
+			t.Errorf("%s: expected %q error response, got=%q", test.name, test.code, test.res)
+		}
+	}
+
+	// A request signed by another key is refused.
+	other, err := key.GenerateType(key.TypeEd25519)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res := signedRequest(t, conn, other, "list devices", id)
+	if string(res[0]) != "error" || protocol.Code(res[1]) != protocol.CodeBadSignature {
+		t.Errorf("expected bad signature error response, got=%q", res)
+	}
+
+	// The connection is still usable after errors.
+	res = signedRequest(t, conn, sk, "list devices", id)
+	if string(res[0]) != "devices" {
+		t.Errorf("expected devices response, got=%q", res)
+	}
+
+	// Disabled accounts are refused until enabled.
+	if err := s.registry.Disable(id, time.Now()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res = signedRequest(t, conn, sk, "list devices", id)
+	if string(res[0]) != "error" || protocol.Code(res[1]) != protocol.CodeDisabledUID {
+		t.Errorf("expected disabled uid error response, got=%q", res)
+	}
+}
+
+func Test_LegacyUIDQuery(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	conn := dialTestServer(t, s)
+	defer conn.Close()
+
+	sk, err := key.GenerateType(key.TypeEd25519)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	id, err := s.registry.Register(sk.Public(), 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// UIDs issued before check digits were added are random numbers, most
+	// of which fail the checksum, but they can still be looked up and used.
+	legacy := uint64(48271930562)
+	if uid.Valid(legacy) {
+		t.Fatalf("expected legacy uid to fail the checksum")
+	}
+
+	legacySK, err := key.GenerateType(key.TypeEd25519)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.key.NewUidFile(uid.Key(legacy), legacySK.Public()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.registry.Refresh(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	res := signedRequest(t, conn, sk, "uid query", id, []byte(uid.Format(legacy)))
+	if string(res[0]) != "uid found" {
+		t.Errorf("expected uid found response, got=%q", res)
+	}
+
+	res = signedRequest(t, conn, legacySK, "uid query", uid.Key(legacy), []byte(id))
+	if string(res[0]) != "uid found" {
+		t.Errorf("expected uid found response to legacy uid, got=%q", res)
+	}
+}
+
+func Test_Metrics(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	conn := dialTestServer(t, s)
+	defer conn.Close()
+
+	sk, err := key.GenerateType(key.TypeEd25519)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	id, err := s.registry.Register(sk.Public(), 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	signedRequest(t, conn, sk, "list devices", id)
+	signedRequest(t, conn, sk, "make coffee", id)
+
+	var buf bytes.Buffer
+	if _, err := s.metrics.registry.WriteTo(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, exp := range []string{
+		`whisper_requests_total{command="list devices",result="ok"} 1`,
+		`whisper_requests_total{command="unknown",result="malformed request"} 1`,
+		`whisper_request_duration_seconds_count{command="list devices"} 1`,
+		`whisper_sessions_active 1`,
+	} {
+		if !strings.Contains(buf.String(), exp+"\n") {
+			t.Errorf("expected metrics to contain %q, got=\n%s", exp, buf.String())
+		}
+	}
+}
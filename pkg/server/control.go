@@ -0,0 +1,389 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/joshvanl/go-whisper/pkg/logging"
+	"github.com/joshvanl/go-whisper/pkg/uid"
+	"github.com/joshvanl/go-whisper/pkg/username"
+)
+
+const (
+	controlSocket = "admin.sock"
+)
+
+var (
+	ErrNotRunning = errors.New("no server is running on this directory")
+)
+
+// controlRequest is a call on the admin socket. Requests and responses are
+// JSON values sent one after another on the connection.
+type controlRequest struct {
+	Command string `json:"command"`
+	UID     string `json:"uid,omitempty"`
+}
+
+type controlResponse struct {
+	Error  string          `json:"error,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// Status summarises a running server.
+type Status struct {
+	Address      string    `json:"address"`
+	Started      time.Time `json:"started"`
+	Sessions     int       `json:"sessions"`
+	Accounts     int       `json:"accounts"`
+	Registration string    `json:"registration"`
+}
+
+// Compaction is the number of stale entries removed from each store.
+type Compaction struct {
+	Usernames int `json:"usernames"`
+	Invites   int `json:"invites"`
+}
+
+// controlHandlers serves each admin socket call by its command.
+var controlHandlers = map[string]func(*Server, controlRequest) (interface{}, error){
+	"status":   (*Server).controlStatus,
+	"sessions": (*Server).controlSessions,
+	"kick":     (*Server).controlKick,
+	"reload":   (*Server).controlReload,
+	"compact":  (*Server).controlCompact,
+	"accounts": (*Server).controlAccounts,
+	"account":  (*Server).controlAccount,
+	"disable":  (*Server).controlDisable,
+	"enable":   (*Server).controlEnable,
+	"delete":   (*Server).controlDelete,
+}
+
+// listenControl creates the admin socket in the server directory. Callers
+// are authenticated by the filesystem: only the server's user may connect.
+func (s *Server) listenControl() (net.Listener, error) {
+	path := filepath.Join(s.dir, controlSocket)
+
+	// A socket accepting connections belongs to another server using this
+	// directory. Otherwise it was left behind and is replaced.
+	if c, err := net.Dial("unix", path); err == nil {
+		c.Close()
+		return nil, fmt.Errorf("another server is already running on %s", s.dir)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove stale admin socket: %v", err)
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on admin socket: %v", err)
+	}
+
+	// The server directory is created private to its owner, so nobody else
+	// can reach the socket before its own permissions are tightened.
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to restrict admin socket: %v", err)
+	}
+
+	return ln, nil
+}
+
+func (s *Server) serveControl(ln net.Listener) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			s.log.WithError(err).Errorf("failed to accept admin connection")
+			return
+		}
+
+		go s.handleControl(c)
+	}
+}
+
+func (s *Server) handleControl(c net.Conn) {
+	defer c.Close()
+
+	dec, enc := json.NewDecoder(c), json.NewEncoder(c)
+
+	for {
+		var req controlRequest
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+
+		var res controlResponse
+
+		result, err := s.control(req)
+		if err == nil {
+			res.Result, err = json.Marshal(result)
+		}
+		if err != nil {
+			s.controlLog(req).WithError(err).Errorf("failed to handle admin request")
+			res.Error = err.Error()
+		}
+
+		if err := enc.Encode(res); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) control(req controlRequest) (interface{}, error) {
+	handle, ok := controlHandlers[req.Command]
+	if !ok {
+		return nil, fmt.Errorf("unknown admin command %q", req.Command)
+	}
+
+	s.controlLog(req).Infof("handling admin request")
+
+	return handle(s, req)
+}
+
+func (s *Server) controlLog(req controlRequest) *logrus.Entry {
+	log := s.log.WithField("admin", req.Command)
+	if req.UID != "" {
+		log = log.WithField(logging.FieldUID, req.UID)
+	}
+
+	return log
+}
+
+func (s *Server) controlStatus(req controlRequest) (interface{}, error) {
+	accounts := len(s.registry.UIDs())
+	if s.registry.Exists(uid.Key(uid.Server)) {
+		accounts--
+	}
+
+	return &Status{
+		Address:      s.addr,
+		Started:      s.started,
+		Sessions:     len(s.sessions.list()),
+		Accounts:     accounts,
+		Registration: s.currentOptions().Registration,
+	}, nil
+}
+
+func (s *Server) controlSessions(req controlRequest) (interface{}, error) {
+	return s.sessions.list(), nil
+}
+
+func (s *Server) controlKick(req controlRequest) (interface{}, error) {
+	kicked := s.sessions.kick(req.UID)
+	s.record(nil, adminEntry("kick", req.UID))
+
+	return kicked, nil
+}
+
+func (s *Server) controlReload(req controlRequest) (interface{}, error) {
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	s.record(nil, adminEntry("reload", ""))
+
+	return nil, nil
+}
+
+func (s *Server) controlCompact(req controlRequest) (interface{}, error) {
+	now := time.Now()
+
+	usernames, err := s.usernames.Compact(now)
+	if err != nil {
+		return nil, err
+	}
+
+	invites, err := s.compactInvites(now)
+	if err != nil {
+		return nil, err
+	}
+
+	s.record(nil, adminEntry("compact", ""))
+
+	return &Compaction{
+		Usernames: usernames,
+		Invites:   invites,
+	}, nil
+}
+
+func (s *Server) controlAccounts(req controlRequest) (interface{}, error) {
+	return s.accounts().Accounts()
+}
+
+func (s *Server) controlAccount(req controlRequest) (interface{}, error) {
+	return s.accounts().Account(req.UID)
+}
+
+// controlDisable disables the account and disconnects its sessions.
+func (s *Server) controlDisable(req controlRequest) (interface{}, error) {
+	if err := s.accounts().Disable(req.UID); err != nil {
+		return nil, err
+	}
+
+	return s.sessions.kick(req.UID), nil
+}
+
+func (s *Server) controlEnable(req controlRequest) (interface{}, error) {
+	return nil, s.accounts().Enable(req.UID)
+}
+
+// controlDelete deletes the account and disconnects its sessions.
+func (s *Server) controlDelete(req controlRequest) (interface{}, error) {
+	if err := s.accounts().Delete(req.UID); err != nil {
+		return nil, err
+	}
+
+	return s.sessions.kick(req.UID), nil
+}
+
+// reload applies server.json and any account changes made on disk without
+// restarting. The uid strategy can only change on restart.
+func (s *Server) reload() error {
+	options, err := ReadOptions(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read options: %v", err)
+	}
+
+	if options.UIDStrategy != s.currentOptions().UIDStrategy {
+		s.log.WithField("uidStrategy", options.UIDStrategy).Warnf("uid strategy will not change until the server is restarted")
+	}
+
+	s.optionsMu.Lock()
+	s.options = options
+	s.optionsMu.Unlock()
+
+	s.limits.setLimits(options.RateLimits)
+	s.usernames.SetRules(username.Rules{
+		ChangeInterval: options.UsernameChangeInterval.Duration,
+		HoldPeriod:     options.UsernameHoldPeriod.Duration,
+	})
+
+	if err := s.registry.Refresh(); err != nil {
+		return fmt.Errorf("failed to reload accounts: %v", err)
+	}
+
+	return nil
+}
+
+func (s *Server) currentOptions() *Options {
+	s.optionsMu.RLock()
+	defer s.optionsMu.RUnlock()
+
+	return s.options
+}
+
+func (s *Server) accounts() *accounts {
+	return &accounts{
+		registry:  s.registry,
+		usernames: s.usernames,
+		audit:     s.audit,
+	}
+}
+
+// AdminClient calls a running server over its admin socket.
+type AdminClient struct {
+	conn net.Conn
+	dec  *json.Decoder
+	enc  *json.Encoder
+}
+
+// DialAdmin connects to the admin socket of the server running on dir. It
+// returns ErrNotRunning if there is none.
+func DialAdmin(dir string) (*AdminClient, error) {
+	conn, err := net.Dial("unix", filepath.Join(dir, controlSocket))
+	if err != nil {
+		if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, ErrNotRunning
+		}
+
+		return nil, fmt.Errorf("failed to connect to admin socket: %v", err)
+	}
+
+	return &AdminClient{
+		conn: conn,
+		dec:  json.NewDecoder(conn),
+		enc:  json.NewEncoder(conn),
+	}, nil
+}
+
+func (a *AdminClient) Close() error {
+	return a.conn.Close()
+}
+
+func (a *AdminClient) Status() (*Status, error) {
+	status := new(Status)
+	return status, a.call("status", "", status)
+}
+
+func (a *AdminClient) Sessions() ([]Session, error) {
+	var sessions []Session
+	return sessions, a.call("sessions", "", &sessions)
+}
+
+// Kick disconnects every session of id, returning how many there were.
+func (a *AdminClient) Kick(id string) (int, error) {
+	var kicked int
+	return kicked, a.call("kick", id, &kicked)
+}
+
+// Reload makes the server re-read its options and accounts from disk.
+func (a *AdminClient) Reload() error {
+	return a.call("reload", "", nil)
+}
+
+// Compact removes expired usernames and invites from the server's storage.
+func (a *AdminClient) Compact() (*Compaction, error) {
+	compaction := new(Compaction)
+	return compaction, a.call("compact", "", compaction)
+}
+
+func (a *AdminClient) Accounts() ([]Account, error) {
+	var accounts []Account
+	return accounts, a.call("accounts", "", &accounts)
+}
+
+func (a *AdminClient) Account(id string) (*Account, error) {
+	account := new(Account)
+	return account, a.call("account", id, account)
+}
+
+// Disable disables id and disconnects its sessions.
+func (a *AdminClient) Disable(id string) error {
+	return a.call("disable", id, nil)
+}
+
+func (a *AdminClient) Enable(id string) error {
+	return a.call("enable", id, nil)
+}
+
+// Delete deletes id and disconnects its sessions.
+func (a *AdminClient) Delete(id string) error {
+	return a.call("delete", id, nil)
+}
+
+func (a *AdminClient) call(command, id string, result interface{}) error {
+	if err := a.enc.Encode(controlRequest{Command: command, UID: id}); err != nil {
+		return fmt.Errorf("failed to send admin request: %v", err)
+	}
+
+	var res controlResponse
+	if err := a.dec.Decode(&res); err != nil {
+		return fmt.Errorf("failed to read admin response: %v", err)
+	}
+
+	if res.Error != "" {
+		return errors.New(res.Error)
+	}
+
+	if result == nil || len(res.Result) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(res.Result, result)
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/key"
+)
+
+func Test_Control(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	if _, err := DialAdmin(s.dir); err != ErrNotRunning {
+		t.Errorf("expected not running error, got=%v", err)
+	}
+
+	ln, err := s.listenControl()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer ln.Close()
+	go s.serveControl(ln)
+
+	info, err := os.Stat(filepath.Join(s.dir, controlSocket))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected admin socket to be private, got=%s", info.Mode().Perm())
+	}
+
+	if _, err := s.listenControl(); err == nil {
+		t.Errorf("expected error starting a second admin socket")
+	}
+
+	admin, err := DialAdmin(s.dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer admin.Close()
+
+	sk, err := key.GenerateType(key.TypeEd25519)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	id, err := s.registry.Register(sk.Public(), 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	conn := dialTestServer(t, s)
+	defer conn.Close()
+
+	if res := signedRequest(t, conn, sk, "list devices", id); string(res[0]) != "devices" {
+		t.Fatalf("expected devices response, got=%q", res)
+	}
+
+	status, err := admin.Status()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status.Sessions != 1 || status.Accounts != 1 || status.Registration != RegistrationOpen {
+		t.Errorf("unexpected status: %+v", status)
+	}
+
+	sessions, err := admin.Sessions()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].UID != id || sessions[0].Requests != 1 {
+		t.Errorf("unexpected sessions: %+v", sessions)
+	}
+
+	kicked, err := admin.Kick(id)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if kicked != 1 {
+		t.Errorf("expected one session kicked, got=%d", kicked)
+	}
+	if _, _, err := conn.Read(); err == nil {
+		t.Errorf("expected kicked connection to be closed")
+	}
+
+	if err := admin.Disable(id); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := s.registry.Disabled(id); !ok {
+		t.Errorf("expected account to be disabled on the running server")
+	}
+
+	// Options changed on disk are applied on reload.
+	if err := ioutil.WriteFile(filepath.Join(s.dir, optionsFile), []byte(`{"registration": "closed"}`), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := admin.Reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.currentOptions().Registration != RegistrationClosed {
+		t.Errorf("expected reloaded registration policy, got=%q", s.currentOptions().Registration)
+	}
+
+	if _, _, err := MintInvite(s.dir, 1, time.Minute, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	compaction, err := admin.Compact()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if compaction.Invites != 1 {
+		t.Errorf("expected expired invite to be compacted, got=%+v", compaction)
+	}
+
+	if err := admin.Enable("0"); err == nil {
+		t.Errorf("expected error enabling unknown uid")
+	}
+}
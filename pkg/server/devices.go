@@ -0,0 +1,428 @@
+package server
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/audit"
+	"github.com/joshvanl/go-whisper/pkg/connection"
+	"github.com/joshvanl/go-whisper/pkg/key"
+	"github.com/joshvanl/go-whisper/pkg/protocol"
+	"github.com/joshvanl/go-whisper/pkg/registry"
+	"github.com/joshvanl/go-whisper/pkg/uid"
+)
+
+const (
+	linkTimeout    = 5 * time.Minute
+	linkCodeDigits = 8
+
+	// maxPendingLinks bounds the devices waiting on a link code at once, as
+	// asking for one needs no account and holds the connection open until
+	// the link is approved or expires.
+	maxPendingLinks = 128
+)
+
+var (
+	errUnknownLinkCode = newRequestError(protocol.CodeNotFound, "unknown link code")
+	errTooManyLinks    = newRequestError(protocol.CodeRateLimited, "too many devices waiting to be linked, try again later")
+)
+
+// pendingLink is a new device waiting for an existing device of an account
+// to approve its link code.
+type pendingLink struct {
+	key     []byte
+	name    string
+	expires time.Time
+
+	// approver is the uid that looked up the code; only it may approve.
+	approver string
+	linked   chan linkResult
+}
+
+// linkResult is the outcome of approving a pendingLink: the account and
+// device ID it was linked as, with the account's identity key and its
+// approval of the device, or why it was not.
+type linkResult struct {
+	uid      string
+	device   string
+	identity []byte
+	approval []byte
+	err      error
+}
+
+type links struct {
+	mu      sync.Mutex
+	pending map[string]*pendingLink
+}
+
+// linkRequest handles:
+//
+//	"link request", device public key, device name, signature
+//
+// from a device that is not part of an account yet. The signature proves the
+// device holds the key. It replies with a link code, then waits for a device
+// of an existing account to approve the code and replies again with the
+// account's uid, the new device ID, the account's identity key, its approval
+// of the device and the server public key.
+func (s *Server) linkRequest(conn *connection.Connection, recv [][]byte) error {
+	if len(recv) != 4 {
+		return newRequestError(protocol.CodeMalformed, "unexpected number of parameters, exp=4 got=%d", len(recv))
+	}
+
+	pk, err := key.ParsePublicKey(recv[1])
+	if err != nil {
+		return newRequestError(protocol.CodeInvalidKey, "failed to parse device public key: %v", err)
+	}
+	if err := key.CheckPublicKey(pk); err == key.ErrWeakKey {
+		return newRequestError(protocol.CodeWeakKey, "keys must be Ed25519 or RSA of at least %d bits", key.MinRSAKeySize)
+	} else if err != nil {
+		return newRequestError(protocol.CodeInvalidKey, "%v", err)
+	}
+
+	if err := s.key.VerifyPayload(pk, connection.Params(recv[:len(recv)-1]...), recv[len(recv)-1]); err != nil {
+		return s.badSignature(conn, string(recv[0]), "", "", "failed to verify device link request: %v", err)
+	}
+
+	link := &pendingLink{
+		key:     recv[1],
+		name:    string(recv[2]),
+		expires: time.Now().Add(linkTimeout),
+		linked:  make(chan linkResult, 1),
+	}
+
+	code, err := s.links.add(link)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		s.links.mu.Lock()
+		delete(s.links.pending, code)
+		s.links.mu.Unlock()
+	}()
+
+	if err := s.writeSigned(conn, connection.Params([]byte("link code"), []byte(formatLinkCode(code)))); err != nil {
+		return fmt.Errorf("failed to write link code: %v", err)
+	}
+
+	select {
+	case res := <-link.linked:
+		if res.err != nil {
+			return res.err
+		}
+
+		message := connection.Params([]byte("device linked"), []byte(res.uid))
+		message = connection.AppendParams(message, []byte(res.device))
+		message = connection.AppendParams(message, res.identity)
+		message = connection.AppendParams(message, res.approval)
+		message = connection.AppendParams(message, s.key.PublicKey())
+
+		if err := s.writeSigned(conn, message); err != nil {
+			return fmt.Errorf("failed to write device linked: %v", err)
+		}
+
+	case <-time.After(linkTimeout):
+		if err := s.writeSigned(conn, connection.Params([]byte("link expired"))); err != nil {
+			return fmt.Errorf("failed to write link expired: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// linkDevice handles:
+//
+//	"link device", uid, link code, timestamp, nonce, signature
+//
+// and responds with the key and name of the device waiting on that code, so
+// the user can check it before approving.
+func (s *Server) linkDevice(conn *connection.Connection, recv [][]byte) error {
+	if len(recv) != 6 {
+		return newRequestError(protocol.CodeMalformed, "unexpected number of parameters, exp=6 got=%d", len(recv))
+	}
+
+	clientUid, err := s.verifyPrimary(conn, recv)
+	if err != nil {
+		return err
+	}
+
+	var message []byte
+
+	s.links.mu.Lock()
+	link, ok := s.links.pending[parseLinkCode(string(recv[2]))]
+	if ok && link.approver == "" && time.Now().Before(link.expires) {
+		link.approver = clientUid
+		message = connection.Params([]byte("link pending"), link.key)
+		message = connection.AppendParams(message, []byte(link.name))
+	}
+	s.links.mu.Unlock()
+
+	if message == nil {
+		return errUnknownLinkCode
+	}
+
+	if err := s.writeSigned(conn, message); err != nil {
+		return fmt.Errorf("failed to write to link device: %v", err)
+	}
+
+	return nil
+}
+
+// approveDevice handles:
+//
+//	"approve device", uid, link code, approval, timestamp, nonce, signature
+//
+// where approval is the identity key's signature over key.DeviceMessage for
+// the waiting device's key.
+func (s *Server) approveDevice(conn *connection.Connection, recv [][]byte) error {
+	if len(recv) != 7 {
+		return newRequestError(protocol.CodeMalformed, "unexpected number of parameters, exp=7 got=%d", len(recv))
+	}
+
+	clientUid, err := s.verifyPrimary(conn, recv)
+	if err != nil {
+		return err
+	}
+
+	message, err := s.addDevice(conn, clientUid, parseLinkCode(string(recv[2])), recv[3])
+	if err != nil {
+		return err
+	}
+
+	if err := s.writeSigned(conn, message); err != nil {
+		return fmt.Errorf("failed to write to approve device: %v", err)
+	}
+
+	return nil
+}
+
+func (s *Server) addDevice(conn *connection.Connection, clientUid, code string, approval []byte) ([]byte, error) {
+	// Only the account that looked the code up may use it, so nobody else
+	// can cancel a link by approving it badly.
+	s.links.mu.Lock()
+	link, ok := s.links.pending[code]
+	if ok && link.approver == clientUid && time.Now().Before(link.expires) {
+		delete(s.links.pending, code)
+	} else {
+		ok = false
+	}
+	s.links.mu.Unlock()
+
+	if !ok {
+		return nil, errUnknownLinkCode
+	}
+
+	device, identity, err := s.approveLink(conn, clientUid, link, approval)
+	if err != nil {
+		// The new device is told the link failed rather than being left
+		// waiting for it to expire.
+		res := linkResult{err: err}
+		if _, ok := err.(*protocol.Error); ok {
+			res.err = newRequestError(protocol.CodeRejected, "the device link was not approved")
+		}
+		link.linked <- res
+
+		return nil, err
+	}
+
+	s.record(conn, audit.Entry{
+		Event:  audit.EventDeviceLinked,
+		UID:    clientUid,
+		Device: device,
+		Detail: map[string]string{"name": link.name},
+	})
+
+	link.linked <- linkResult{uid: clientUid, device: device, identity: identity, approval: approval}
+
+	return connection.Params([]byte("device added"), []byte(device)), nil
+}
+
+// approveLink checks approval is clientUid's signature over the key of link,
+// and adds it as a device of the account, returning its device ID and the
+// account's identity key.
+func (s *Server) approveLink(conn *connection.Connection, clientUid string, link *pendingLink, approval []byte) (string, []byte, error) {
+	identity, err := s.registry.PublicKey(clientUid)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err := s.key.VerifyPayload(identity, key.DeviceMessage(clientUid, link.key), approval); err != nil {
+		return "", nil, s.badSignature(conn, "approve device", clientUid, "", "invalid device approval")
+	}
+
+	identityB, err := key.MarshalPublicKey(identity)
+	if err != nil {
+		return "", nil, err
+	}
+
+	device, err := s.registry.AddDevice(clientUid, registry.Device{
+		Name:     link.name,
+		Key:      link.key,
+		Approval: approval,
+		Added:    time.Now(),
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	return device, identityB, nil
+}
+
+// listDevices handles:
+//
+//	"list devices", uid, timestamp, nonce, signature
+//
+// and responds with the ID, name, key and time linked of each of the
+// account's devices.
+func (s *Server) listDevices(conn *connection.Connection, recv [][]byte) error {
+	if len(recv) != 5 {
+		return newRequestError(protocol.CodeMalformed, "unexpected number of parameters, exp=5 got=%d", len(recv))
+	}
+
+	clientUid, err := s.verifyClient(conn, recv)
+	if err != nil {
+		return err
+	}
+
+	devices, err := s.registry.Devices(clientUid)
+	if err != nil {
+		return err
+	}
+
+	message := connection.Params([]byte("devices"))
+	for _, d := range devices {
+		message = connection.AppendParams(message, []byte(d.ID))
+		message = connection.AppendParams(message, []byte(d.Name))
+		message = connection.AppendParams(message, d.Key)
+		message = connection.AppendParams(message, []byte(strconv.FormatInt(d.Added.Unix(), 10)))
+	}
+
+	if err := s.writeSigned(conn, message); err != nil {
+		return fmt.Errorf("failed to write to list devices: %v", err)
+	}
+
+	return nil
+}
+
+// revokeDevice handles:
+//
+//	"revoke device", uid, device ID, timestamp, nonce, signature
+func (s *Server) revokeDevice(conn *connection.Connection, recv [][]byte) error {
+	if len(recv) != 6 {
+		return newRequestError(protocol.CodeMalformed, "unexpected number of parameters, exp=6 got=%d", len(recv))
+	}
+
+	clientUid, err := s.verifyPrimary(conn, recv)
+	if err != nil {
+		return err
+	}
+
+	err = s.registry.RevokeDevice(clientUid, string(recv[2]), time.Now())
+	if err == registry.ErrUnknownDevice {
+		return newRequestError(protocol.CodeNotFound, "no device %s linked to this account", recv[2])
+	}
+	if err != nil {
+		return err
+	}
+
+	s.record(conn, audit.Entry{
+		Event:  audit.EventDeviceRevoked,
+		UID:    clientUid,
+		Device: string(recv[2]),
+	})
+
+	if err := s.writeSigned(conn, connection.Params([]byte("device revoked"))); err != nil {
+		return fmt.Errorf("failed to write to revoke device: %v", err)
+	}
+
+	return nil
+}
+
+// uidDevices handles:
+//
+//	"uid devices", uid, queried uid, timestamp, nonce, signature
+//
+// and responds with the ID, key and approval of each device of the queried
+// account, for sending to all of them.
+func (s *Server) uidDevices(conn *connection.Connection, recv [][]byte) error {
+	if len(recv) != 6 {
+		return newRequestError(protocol.CodeMalformed, "unexpected number of parameters, exp=6 got=%d", len(recv))
+	}
+
+	if _, err := s.verifyClient(conn, recv); err != nil {
+		return err
+	}
+
+	n, err := uid.Parse(string(recv[2]))
+	if err != nil {
+		return newRequestError(protocol.CodeMalformed, "failed to parse queried uid: %v", err)
+	}
+	id := uid.Key(n)
+
+	if !s.registry.Exists(id) {
+		return newRequestError(protocol.CodeUnknownUID, "uid %s does not exist", uid.Format(n))
+	}
+
+	devices, err := s.registry.Devices(id)
+	if err != nil {
+		return err
+	}
+
+	message := connection.Params([]byte("devices"))
+	for _, d := range devices {
+		message = connection.AppendParams(message, []byte(d.ID))
+		message = connection.AppendParams(message, d.Key)
+		message = connection.AppendParams(message, d.Approval)
+	}
+
+	if err := s.writeSigned(conn, message); err != nil {
+		return fmt.Errorf("failed to write to uid devices: %v", err)
+	}
+
+	return nil
+}
+
+// add stores link under a new code, one no other device is waiting on.
+func (l *links) add(link *pendingLink) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.pending) >= maxPendingLinks {
+		return "", errTooManyLinks
+	}
+
+	for {
+		code, err := newLinkCode()
+		if err != nil {
+			return "", err
+		}
+
+		if _, ok := l.pending[code]; !ok {
+			l.pending[code] = link
+			return code, nil
+		}
+	}
+}
+
+func newLinkCode() (string, error) {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(linkCodeDigits), nil)
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate link code: %v", err)
+	}
+
+	return fmt.Sprintf("%0*d", linkCodeDigits, n), nil
+}
+
+func formatLinkCode(code string) string {
+	return code[:linkCodeDigits/2] + "-" + code[linkCodeDigits/2:]
+}
+
+func parseLinkCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
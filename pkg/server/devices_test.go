@@ -0,0 +1,194 @@
+package server
+
+import (
+	"bytes"
+	"crypto"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/connection"
+	"github.com/joshvanl/go-whisper/pkg/key"
+	"github.com/joshvanl/go-whisper/pkg/protocol"
+)
+
+// requestLink sends a link request from the new device sk on conn and
+// returns its link code.
+func requestLink(t *testing.T, conn *connection.Connection, sk crypto.Signer, pk []byte) string {
+	send := connection.Params([]byte("link request"), pk, []byte("laptop"))
+	sig, err := key.SignWith(sk, send)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := conn.Write(connection.AppendParams(send, sig)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	res, _, err := conn.Read()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(res) != 3 || string(res[0]) != "link code" {
+		t.Fatalf("unexpected link request response: %q", res)
+	}
+
+	return string(res[1])
+}
+
+// readLinked waits for the second response to a link request.
+func readLinked(t *testing.T, conn *connection.Connection) [][]byte {
+	type result struct {
+		res [][]byte
+		err error
+	}
+	read := make(chan result, 1)
+	go func() {
+		res, _, err := conn.Read()
+		read <- result{res, err}
+	}()
+
+	select {
+	case r := <-read:
+		if r.err != nil {
+			t.Fatalf("unexpected error: %v", r.err)
+		}
+		return r.res
+	case <-time.After(5 * time.Second):
+		t.Fatalf("new device was left waiting on its link")
+		return nil
+	}
+}
+
+func Test_LinkDevice(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	account := dialTestServer(t, s)
+	defer account.Close()
+	device := dialTestServer(t, s)
+	defer device.Close()
+
+	sk, err := key.GenerateType(key.TypeEd25519)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	id, err := s.registry.Register(sk.Public(), 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	devicesk, err := key.GenerateType(key.TypeEd25519)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	devicepk, err := key.MarshalPublicKey(devicesk.Public())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	approval, err := key.SignWith(sk, key.DeviceMessage(id, devicepk))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A bad approval is refused, and the waiting device is told so rather
+	// than waiting for the link to expire.
+	code := requestLink(t, device, devicesk, devicepk)
+	if res := signedRequest(t, account, sk, "link device", id, []byte(code)); string(res[0]) != "link pending" {
+		t.Fatalf("unexpected link device response: %q", res)
+	}
+
+	other, err := key.GenerateType(key.TypeEd25519)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bad, err := key.SignWith(other, key.DeviceMessage(id, devicepk))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	res := signedRequest(t, account, sk, "approve device", id, []byte(code), bad)
+	if string(res[0]) != "error" || protocol.Code(res[1]) != protocol.CodeBadSignature {
+		t.Errorf("expected bad signature error, got=%q", res)
+	}
+	if res := readLinked(t, device); string(res[0]) != "error" || protocol.Code(res[1]) != protocol.CodeRejected {
+		t.Errorf("expected rejected error for new device, got=%q", res)
+	}
+
+	// The device can ask again, and be approved.
+	code = requestLink(t, device, devicesk, devicepk)
+	if res := signedRequest(t, account, sk, "link device", id, []byte(code)); string(res[0]) != "link pending" {
+		t.Fatalf("unexpected link device response: %q", res)
+	}
+	if res := signedRequest(t, account, sk, "approve device", id, []byte(code), approval); string(res[0]) != "device added" {
+		t.Fatalf("unexpected approve device response: %q", res)
+	}
+	res = readLinked(t, device)
+	if len(res) != 7 || string(res[0]) != "device linked" || string(res[1]) != id {
+		t.Fatalf("unexpected device linked response: %q", res)
+	}
+
+	// The new device is given the account's identity key, and its approval
+	// to check the key against.
+	identity, err := key.MarshalPublicKey(sk.Public())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(res[3], identity) || !bytes.Equal(res[4], approval) {
+		t.Errorf("expected identity key and approval in device linked response")
+	}
+
+	res = signedRequest(t, account, sk, "uid devices", id, []byte(id))
+	if len(res) != 5 || string(res[0]) != "devices" {
+		t.Fatalf("unexpected uid devices response: %q", res)
+	}
+	if !bytes.Equal(res[2], devicepk) || !bytes.Equal(res[3], approval) {
+		t.Errorf("unexpected device key or approval")
+	}
+}
+
+func Test_LinkRequestLimit(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	device := dialTestServer(t, s)
+	defer device.Close()
+
+	s.links.mu.Lock()
+	for i := 0; i < maxPendingLinks; i++ {
+		s.links.pending[strconv.Itoa(i)] = &pendingLink{expires: time.Now().Add(linkTimeout)}
+	}
+	s.links.mu.Unlock()
+
+	devicesk, err := key.GenerateType(key.TypeEd25519)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	devicepk, err := key.MarshalPublicKey(devicesk.Public())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	send := connection.Params([]byte("link request"), devicepk, []byte("laptop"))
+	sig, err := key.SignWith(devicesk, send)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := device.Write(connection.AppendParams(send, sig)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	res, _, err := device.Read()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(res[0]) != "error" || protocol.Code(res[1]) != protocol.CodeRateLimited {
+		t.Errorf("expected rate limited error once too many links are pending, got=%q", res)
+	}
+
+	s.links.mu.Lock()
+	n := len(s.links.pending)
+	s.links.mu.Unlock()
+	if n != maxPendingLinks {
+		t.Errorf("expected no new pending link, exp=%d got=%d", maxPendingLinks, n)
+	}
+}
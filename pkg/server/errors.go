@@ -0,0 +1,24 @@
+package server
+
+import (
+	"github.com/joshvanl/go-whisper/pkg/connection"
+	"github.com/joshvanl/go-whisper/pkg/protocol"
+)
+
+// newRequestError is an error caused by the client's request, reported back
+// to it with code.
+func newRequestError(code protocol.Code, format string, a ...interface{}) error {
+	return protocol.Errorf(code, format, a...)
+}
+
+// writeError sends err to the client as an error response. Errors not caused
+// by the request are reported as internal errors without their details.
+func (s *Server) writeError(conn *connection.Connection, err error) error {
+	perr, ok := err.(*protocol.Error)
+	if !ok {
+		perr = protocol.ErrInternal
+	}
+
+	response := connection.Params([]byte("error"), []byte(perr.Code))
+	return s.writeSigned(conn, connection.AppendParams(response, []byte(perr.Message)))
+}
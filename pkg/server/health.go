@@ -0,0 +1,135 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/connection"
+	"github.com/joshvanl/go-whisper/pkg/logging"
+)
+
+// healthCheck is one named condition reported by /healthz or /readyz.
+type healthCheck struct {
+	name  string
+	check func() error
+}
+
+// liveChecks are the conditions under which the server can work at all.
+func (s *Server) liveChecks() []healthCheck {
+	return []healthCheck{
+		{"storage", s.checkStorage},
+		{"key", s.checkKey},
+	}
+}
+
+// readyChecks are liveChecks plus those for accepting clients. They are
+// kept cheap as supervisors poll them; a full key exchange is left to
+// SelfTest.
+func (s *Server) readyChecks() []healthCheck {
+	return append(s.liveChecks(),
+		healthCheck{"listener", s.checkListener},
+		healthCheck{"registry", s.checkRegistry},
+	)
+}
+
+// checkStorage writes and removes a file in the server directory.
+func (s *Server) checkStorage() error {
+	f, err := ioutil.TempFile(s.dir, ".health-")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %v", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write([]byte("ok")); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+
+	return f.Close()
+}
+
+// checkKey signs and verifies a message with the server key.
+func (s *Server) checkKey() error {
+	message := []byte("health check")
+
+	sig, err := s.key.SignMessage(message)
+	if err != nil {
+		return fmt.Errorf("failed to sign: %v", err)
+	}
+
+	return s.key.VerifyPayload(s.key.Public(), message, sig)
+}
+
+func (s *Server) checkListener() error {
+	if s.listenAddr() == nil {
+		return fmt.Errorf("not listening on %s", s.addr)
+	}
+
+	return nil
+}
+
+// checkRegistry reads the head of the registry's key transparency log.
+func (s *Server) checkRegistry() error {
+	if _, _, err := s.registry.TreeHead(time.Now(), 0); err != nil {
+		return fmt.Errorf("failed to read tree head: %v", err)
+	}
+
+	return nil
+}
+
+// listenAddr returns the address clients are served on, or nil before the
+// listener is bound.
+func (s *Server) listenAddr() net.Addr {
+	s.listenerMu.Lock()
+	defer s.listenerMu.Unlock()
+
+	if s.listener == nil {
+		return nil
+	}
+
+	return s.listener.Addr()
+}
+
+// SelfTest connects to the server at addr and completes a key exchange with
+// it, as a client would, within timeout.
+func SelfTest(addr string, timeout time.Duration) error {
+	c, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %v", addr, err)
+	}
+	defer c.Close()
+
+	conn, err := connection.NewWithTimeouts(c, logging.Discard(), connection.Timeouts{Handshake: timeout})
+	if err != nil {
+		return fmt.Errorf("failed key exchange with %s: %v", addr, err)
+	}
+
+	return conn.Close()
+}
+
+// healthHandler runs checks on each request, responding 200 if they all
+// pass and 503 otherwise, with a line per check.
+func healthHandler(checks func() []healthCheck) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var body bytes.Buffer
+
+		status := http.StatusOK
+		for _, c := range checks() {
+			if err := c.check(); err != nil {
+				status = http.StatusServiceUnavailable
+				fmt.Fprintf(&body, "[-]%s failed: %v\n", c.name, err)
+				continue
+			}
+			fmt.Fprintf(&body, "[+]%s ok\n", c.name)
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		body.WriteTo(w)
+	})
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Health(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	get := func(h http.Handler) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		return rec
+	}
+
+	if rec := get(healthHandler(s.liveChecks)); rec.Code != http.StatusOK {
+		t.Errorf("expected healthy server, got=%d %s", rec.Code, rec.Body)
+	}
+
+	// Not ready until the listener is bound.
+	rec := get(healthHandler(s.readyChecks))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "[-]listener failed") {
+		t.Errorf("expected listener not to be ready, got=%d %s", rec.Code, rec.Body)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer ln.Close()
+
+	s.listenerMu.Lock()
+	s.listener = ln
+	s.listenerMu.Unlock()
+
+	// Readiness does not connect to the server, so nothing need be
+	// accepted.
+	if rec := get(healthHandler(s.readyChecks)); rec.Code != http.StatusOK {
+		t.Errorf("expected ready server, got=%d %s", rec.Code, rec.Body)
+	}
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.accept(c)
+		}
+	}()
+
+	if err := SelfTest(ln.Addr().String(), time.Second); err != nil {
+		t.Errorf("unexpected self test error: %v", err)
+	}
+
+	// A listener that never answers fails the self test.
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer silent.Close()
+
+	if err := SelfTest(silent.Addr().String(), 100*time.Millisecond); err == nil {
+		t.Errorf("expected self test of a silent listener to fail")
+	}
+}
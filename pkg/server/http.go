@@ -0,0 +1,79 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/joshvanl/go-whisper/pkg/logging"
+)
+
+// httpListener serves the metrics and health endpoints configured on one
+// address.
+type httpListener struct {
+	net.Listener
+	addr string
+	mux  *http.ServeMux
+}
+
+// listenHTTP opens the optional HTTP listeners for metrics, at
+// MetricsAddress, and health checks, at HealthAddress. Endpoints sharing an
+// address share a listener.
+func (s *Server) listenHTTP() ([]httpListener, error) {
+	options := s.currentOptions()
+
+	var listeners []httpListener
+	mux := func(addr string) (*http.ServeMux, error) {
+		for _, l := range listeners {
+			if l.addr == addr {
+				return l.mux, nil
+			}
+		}
+
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %v", addr, err)
+		}
+
+		l := httpListener{Listener: ln, addr: addr, mux: http.NewServeMux()}
+		listeners = append(listeners, l)
+		return l.mux, nil
+	}
+
+	for _, endpoint := range []struct {
+		addr     string
+		handlers map[string]http.Handler
+	}{
+		{options.MetricsAddress, map[string]http.Handler{
+			"/metrics": s.metrics.registry,
+		}},
+		{options.HealthAddress, map[string]http.Handler{
+			"/healthz": healthHandler(s.liveChecks),
+			"/readyz":  healthHandler(s.readyChecks),
+		}},
+	} {
+		if endpoint.addr == "" {
+			continue
+		}
+
+		m, err := mux(endpoint.addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+
+		for pattern, h := range endpoint.handlers {
+			m.Handle(pattern, h)
+		}
+	}
+
+	return listeners, nil
+}
+
+func (s *Server) serveHTTP(l httpListener) {
+	if err := http.Serve(l, l.mux); err != nil {
+		s.log.WithError(err).WithField(logging.FieldAddress, l.addr).Errorf("failed to serve http")
+	}
+}
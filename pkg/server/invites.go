@@ -0,0 +1,310 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/audit"
+	"github.com/joshvanl/go-whisper/pkg/key"
+	"github.com/joshvanl/go-whisper/pkg/protocol"
+	"github.com/joshvanl/go-whisper/pkg/store"
+)
+
+const (
+	invitesFile = "invites.json"
+	// invitesLock is locked around every change to invitesFile, which is
+	// replaced on write and so can not hold the lock itself.
+	invitesLock = "invites.lock"
+
+	inviteCodeSize = 10
+	inviteIDSize   = 8
+)
+
+// Registration policies for the Registration option.
+const (
+	RegistrationOpen   = "open"
+	RegistrationInvite = "invite"
+	RegistrationClosed = "closed"
+)
+
+var (
+	ErrUnknownInvite = errors.New("unknown invite")
+
+	errInvalidInvite = newRequestError(protocol.CodeInvalidInvite, "invite code is unknown, used up or expired")
+)
+
+// Invite allows registering while registration is invite only. Only the
+// hash of its code is stored.
+type Invite struct {
+	// ID names the invite to admins without revealing its code.
+	ID   string `json:"id"`
+	Hash string `json:"hash"`
+
+	MaxUses int       `json:"maxUses"`
+	Uses    int       `json:"uses"`
+	Created time.Time `json:"created"`
+	// Expires is zero for invites that never expire.
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+func (i *Invite) valid(now time.Time) bool {
+	return i.Uses < i.MaxUses && (i.Expires.IsZero() || now.Before(i.Expires))
+}
+
+// MintInvite creates an invite in dir usable maxUses times, expiring after
+// ttl unless ttl is zero. It returns the invite's code, which is not stored.
+func MintInvite(dir string, maxUses int, ttl time.Duration, now time.Time) (string, *Invite, error) {
+	if maxUses < 1 {
+		return "", nil, fmt.Errorf("invites must allow at least one use: %d", maxUses)
+	}
+	if ttl < 0 {
+		return "", nil, fmt.Errorf("invite expiry must not be negative: %s", ttl)
+	}
+
+	b := make([]byte, inviteCodeSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, fmt.Errorf("failed to generate invite code: %v", err)
+	}
+	code := base32.StdEncoding.EncodeToString(b)
+
+	hash := hashInvite(code)
+	invite := Invite{
+		ID:      hash[:inviteIDSize],
+		Hash:    hash,
+		MaxUses: maxUses,
+		Created: now,
+	}
+	if ttl > 0 {
+		invite.Expires = now.Add(ttl)
+	}
+
+	unlock, err := lockInvites(dir)
+	if err != nil {
+		return "", nil, err
+	}
+	defer unlock()
+
+	invites, err := readInvites(dir)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err := writeInvites(dir, append(invites, invite)); err != nil {
+		return "", nil, err
+	}
+
+	if err := recordInvite(dir, "invite create", invite.ID); err != nil {
+		return "", nil, err
+	}
+
+	return formatInvite(code), &invite, nil
+}
+
+// Invites returns the invites stored in dir, including used up and expired
+// ones.
+func Invites(dir string) ([]Invite, error) {
+	return readInvites(dir)
+}
+
+// RevokeInvite removes the invite with id from dir.
+func RevokeInvite(dir, id string) error {
+	unlock, err := lockInvites(dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	invites, err := readInvites(dir)
+	if err != nil {
+		return err
+	}
+
+	for i := range invites {
+		if invites[i].ID == id {
+			if err := writeInvites(dir, append(invites[:i], invites[i+1:]...)); err != nil {
+				return err
+			}
+
+			return recordInvite(dir, "invite revoke", id)
+		}
+	}
+
+	return ErrUnknownInvite
+}
+
+// recordInvite audits an admin command on the invite id, made outside the
+// server.
+func recordInvite(dir, command, id string) error {
+	k, err := key.New(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read server key: %v", err)
+	}
+
+	err = audit.Open(dir, k).Record(audit.Entry{
+		Event: audit.EventAdmin,
+		Detail: map[string]string{
+			"command": command,
+			"invite":  id,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record audit event: %v", err)
+	}
+
+	return nil
+}
+
+// checkRegistration applies the registration policy to a new client, with
+// the invite code it sent, if any. register is only called if the client
+// may register, and the invite's use is given back if it fails.
+func (s *Server) checkRegistration(code string, register func() error) error {
+	switch s.currentOptions().Registration {
+	case RegistrationOpen:
+		return register()
+
+	case RegistrationClosed:
+		return newRequestError(protocol.CodeRegistrationClosed, "this server is not accepting new registrations")
+	}
+
+	if code == "" {
+		return newRequestError(protocol.CodeInviteRequired, "registering on this server requires an invite code")
+	}
+
+	// Held until the use is written so a single use invite can not be
+	// redeemed twice, nor an invite revoked by the CLI meanwhile come back.
+	s.invitesMu.Lock()
+	defer s.invitesMu.Unlock()
+
+	unlock, err := lockInvites(s.dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	invites, err := readInvites(s.dir)
+	if err != nil {
+		return err
+	}
+
+	hash := hashInvite(parseInvite(code))
+	now := time.Now()
+
+	for i := range invites {
+		if invites[i].Hash != hash || !invites[i].valid(now) {
+			continue
+		}
+
+		// The use is written first so an account is never registered
+		// against an invite that does not record it.
+		invites[i].Uses++
+		if err := writeInvites(s.dir, invites); err != nil {
+			return err
+		}
+
+		if err := register(); err != nil {
+			invites[i].Uses--
+			if werr := writeInvites(s.dir, invites); werr != nil {
+				s.log.WithError(werr).Errorf("failed to return use of invite %s", invites[i].ID)
+			}
+			return err
+		}
+
+		return nil
+	}
+
+	return errInvalidInvite
+}
+
+// compactInvites removes used up and expired invites, returning how many
+// were removed.
+func (s *Server) compactInvites(now time.Time) (int, error) {
+	s.invitesMu.Lock()
+	defer s.invitesMu.Unlock()
+
+	unlock, err := lockInvites(s.dir)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	invites, err := readInvites(s.dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var valid []Invite
+	for _, invite := range invites {
+		if invite.valid(now) {
+			valid = append(valid, invite)
+		}
+	}
+
+	if len(valid) == len(invites) {
+		return 0, nil
+	}
+
+	return len(invites) - len(valid), writeInvites(s.dir, valid)
+}
+
+func hashInvite(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// formatInvite splits code into groups of four for reading out.
+func formatInvite(code string) string {
+	var groups []string
+	for len(code) > 4 {
+		groups = append(groups, code[:4])
+		code = code[4:]
+	}
+
+	return strings.Join(append(groups, code), "-")
+}
+
+func parseInvite(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// lockInvites takes the lock on the invites in dir, shared between the server
+// and the invite commands run alongside it. The returned func releases it.
+func lockInvites(dir string) (func(), error) {
+	f, err := os.OpenFile(filepath.Join(dir, invitesLock), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open invites lock: %v", err)
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock invites: %v", err)
+	}
+
+	// Closing the file releases the lock.
+	return func() { f.Close() }, nil
+}
+
+func readInvites(dir string) ([]Invite, error) {
+	var invites []Invite
+	if _, err := store.ReadJSON(filepath.Join(dir, invitesFile), &invites); err != nil {
+		return nil, fmt.Errorf("failed to read invites: %v", err)
+	}
+
+	return invites, nil
+}
+
+func writeInvites(dir string, invites []Invite) error {
+	if err := store.WriteJSON(filepath.Join(dir, invitesFile), invites); err != nil {
+		return fmt.Errorf("failed to write invites: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/protocol"
+)
+
+func Test_Invites(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	registered := 0
+	register := func() error {
+		registered++
+		return nil
+	}
+
+	s.options.Registration = RegistrationClosed
+	if err := s.checkRegistration("", register); !errors.Is(err, protocol.ErrRegistrationClosed) {
+		t.Errorf("expected registration closed error, got=%v", err)
+	}
+
+	s.options.Registration = RegistrationInvite
+	if err := s.checkRegistration("", register); !errors.Is(err, protocol.ErrInviteRequired) {
+		t.Errorf("expected invite required error, got=%v", err)
+	}
+
+	code, invite, err := MintInvite(s.dir, 2, time.Hour, time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Codes are accepted however they are grouped or cased.
+	for _, c := range []string{code, strings.ToLower(strings.Replace(code, "-", "", -1))} {
+		if err := s.checkRegistration(c, register); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	if err := s.checkRegistration(code, register); !errors.Is(err, protocol.ErrInvalidInvite) {
+		t.Errorf("expected invalid invite error once used up, got=%v", err)
+	}
+	if registered != 2 {
+		t.Errorf("unexpected number of registrations, exp=2 got=%d", registered)
+	}
+
+	// The use is recorded before the account is registered, and given back
+	// if registration fails.
+	single, singleInvite, err := MintInvite(s.dir, 1, 0, time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	failed := func() error {
+		invites, err := Invites(s.dir)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, i := range invites {
+			if i.ID == singleInvite.ID && i.Uses != 1 {
+				t.Errorf("expected use to be recorded before registering, got=%d", i.Uses)
+			}
+		}
+		return errors.New("failed")
+	}
+	if err := s.checkRegistration(single, failed); err == nil {
+		t.Errorf("expected registration error")
+	}
+	if err := s.checkRegistration(single, register); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	expired, _, err := MintInvite(s.dir, 1, time.Minute, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.checkRegistration(expired, register); !errors.Is(err, protocol.ErrInvalidInvite) {
+		t.Errorf("expected invalid invite error when expired, got=%v", err)
+	}
+
+	if err := RevokeInvite(s.dir, invite.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := RevokeInvite(s.dir, invite.ID); err != ErrUnknownInvite {
+		t.Errorf("expected unknown invite error, got=%v", err)
+	}
+
+	invites, err := Invites(s.dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(invites) != 2 {
+		t.Errorf("unexpected number of invites, exp=2 got=%d", len(invites))
+	}
+	for _, i := range invites {
+		if strings.Contains(i.Hash, parseInvite(code)) {
+			t.Errorf("expected invite code not to be stored")
+		}
+	}
+}
+
+func Test_InvitesConcurrentCLI(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+	s.options.Registration = RegistrationInvite
+
+	code, invite, err := MintInvite(s.dir, 20, 0, time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Invites minted by the CLI while the server redeems another must
+	// neither be lost nor undo its uses.
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			if err := s.checkRegistration(code, func() error { return nil }); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			if _, _, err := MintInvite(s.dir, 1, 0, time.Now()); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}
+	}()
+	wg.Wait()
+
+	invites, err := Invites(s.dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(invites) != 21 {
+		t.Errorf("unexpected number of invites, exp=21 got=%d", len(invites))
+	}
+	for _, i := range invites {
+		if i.ID == invite.ID && i.Uses != 20 {
+			t.Errorf("unexpected number of uses, exp=20 got=%d", i.Uses)
+		}
+	}
+}
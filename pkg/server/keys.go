@@ -0,0 +1,141 @@
+package server
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/connection"
+	"github.com/joshvanl/go-whisper/pkg/key"
+	"github.com/joshvanl/go-whisper/pkg/protocol"
+	"github.com/joshvanl/go-whisper/pkg/registry"
+	"github.com/joshvanl/go-whisper/pkg/store"
+	"github.com/joshvanl/go-whisper/pkg/uid"
+)
+
+const (
+	serverKeysFile = "server_keys.json"
+)
+
+// serverKey handles:
+//
+//	"server key"
+//
+// and responds with the server's current public key followed by the old key
+// and signature of each of its retained key transitions, oldest first. It is
+// signed by the current key, so clients that pinned an older key can follow
+// the chain to it.
+func (s *Server) serverKey(conn *connection.Connection, recv [][]byte) error {
+	if len(recv) != 1 {
+		return newRequestError(protocol.CodeMalformed, "unexpected number of parameters, exp=1 got=%d", len(recv))
+	}
+
+	transitions, err := readServerTransitions(s.dir)
+	if err != nil {
+		return err
+	}
+
+	message := connection.Params([]byte("server key"), s.key.PublicKey())
+	for _, t := range transitions {
+		message = connection.AppendParams(message, t.Old)
+		message = connection.AppendParams(message, t.Signature)
+	}
+
+	if err := s.writeSigned(conn, message); err != nil {
+		return fmt.Errorf("failed to write to server key: %v", err)
+	}
+
+	return nil
+}
+
+// RotateKey replaces the server key stored in dir with a new one, signed by
+// the old key so clients can follow the change. A running server keeps using
+// the old key until it is restarted.
+func RotateKey(dir string) (*registry.Transition, error) {
+	k, err := key.New(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read server key: %v", err)
+	}
+
+	sk, err := k.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	newpk, err := key.MarshalPublicKey(sk.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := k.SignMessage(key.TransitionMessage(uid.Key(uid.Server), newpk))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign key transition with old key: %v", err)
+	}
+
+	transitions, err := readServerTransitions(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	t := registry.Transition{
+		Old:       k.PublicKey(),
+		New:       newpk,
+		Signature: sig,
+		Time:      time.Now(),
+	}
+
+	// As with client rotations the transition is written first, so a
+	// failure leaves a harmless unused transition rather than an
+	// unverifiable key.
+	if err := writeServerTransitions(dir, append(transitions, t)); err != nil {
+		return nil, err
+	}
+
+	if err := k.ReplaceKey(sk); err != nil {
+		return nil, fmt.Errorf("failed to store new server key: %v", err)
+	}
+
+	return &t, nil
+}
+
+// RetireKeys forgets all but the newest keep server key transitions. Clients
+// that pinned a retired key can no longer follow the chain and must be
+// re-pinned by hand. It returns the number of transitions retired.
+func RetireKeys(dir string, keep int) (int, error) {
+	if keep < 0 {
+		return 0, fmt.Errorf("number of transitions to keep must not be negative: %d", keep)
+	}
+
+	transitions, err := readServerTransitions(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(transitions) <= keep {
+		return 0, nil
+	}
+
+	retired := len(transitions) - keep
+	if err := writeServerTransitions(dir, transitions[retired:]); err != nil {
+		return 0, err
+	}
+
+	return retired, nil
+}
+
+func readServerTransitions(dir string) ([]registry.Transition, error) {
+	var transitions []registry.Transition
+	if _, err := store.ReadJSON(filepath.Join(dir, serverKeysFile), &transitions); err != nil {
+		return nil, fmt.Errorf("failed to read server key transitions: %v", err)
+	}
+
+	return transitions, nil
+}
+
+func writeServerTransitions(dir string, transitions []registry.Transition) error {
+	if err := store.WriteJSON(filepath.Join(dir, serverKeysFile), transitions); err != nil {
+		return fmt.Errorf("failed to write server key transitions: %v", err)
+	}
+
+	return nil
+}
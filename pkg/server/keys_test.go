@@ -0,0 +1,80 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/joshvanl/go-whisper/pkg/key"
+	"github.com/joshvanl/go-whisper/pkg/uid"
+)
+
+func Test_RotateKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-whisper-server")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	k, err := key.New(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	first := k.PublicKey()
+
+	for i := 0; i < 3; i++ {
+		if _, err := RotateKey(dir); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	transitions, err := readServerTransitions(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(transitions) != 3 {
+		t.Fatalf("unexpected number of transitions, exp=3 got=%d", len(transitions))
+	}
+	if string(transitions[0].Old) != string(first) {
+		t.Errorf("expected first transition to be from the original key")
+	}
+
+	k, err = key.New(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(transitions[2].New) != string(k.PublicKey()) {
+		t.Errorf("expected last transition to be to the stored key")
+	}
+
+	for i, tr := range transitions {
+		oldpk, err := key.ParsePublicKey(tr.Old)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if err := k.VerifyPayload(oldpk, key.TransitionMessage(uid.Key(uid.Server), tr.New), tr.Signature); err != nil {
+			t.Errorf("transition %d: unexpected error: %v", i, err)
+		}
+
+		if i > 0 && string(transitions[i-1].New) != string(tr.Old) {
+			t.Errorf("transition %d does not follow from the one before", i)
+		}
+	}
+
+	retired, err := RetireKeys(dir, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if retired != 2 {
+		t.Errorf("unexpected number of retired keys, exp=2 got=%d", retired)
+	}
+
+	transitions, err = readServerTransitions(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(transitions) != 1 || string(transitions[0].New) != string(k.PublicKey()) {
+		t.Errorf("expected only the newest transition to be kept")
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/connection"
+	"github.com/joshvanl/go-whisper/pkg/metrics"
+	"github.com/joshvanl/go-whisper/pkg/protocol"
+	"github.com/joshvanl/go-whisper/pkg/store"
+)
+
+// serverMetrics are exposed on the metrics listener when MetricsAddress is
+// set. Values read from elsewhere in the server are collected on scrape.
+type serverMetrics struct {
+	registry *metrics.Registry
+
+	handshakes        *metrics.Counter
+	handshakeFailures *metrics.Counter
+	requests          *metrics.Counter
+	requestDuration   *metrics.Histogram
+	registrations     *metrics.Counter
+}
+
+func newServerMetrics(s *Server) *serverMetrics {
+	r := metrics.NewRegistry()
+
+	m := &serverMetrics{
+		registry: r,
+		handshakes: r.Counter("whisper_handshakes_total",
+			"Key exchanges completed with connecting clients."),
+		handshakeFailures: r.Counter("whisper_handshake_failures_total",
+			"Key exchanges with connecting clients that failed, by reason.", "reason"),
+		requests: r.Counter("whisper_requests_total",
+			"Requests handled, by command and result code.", "command", "result"),
+		requestDuration: r.Histogram("whisper_request_duration_seconds",
+			"Time taken to handle requests, by command.", metrics.DefaultBuckets, "command"),
+		registrations: r.Counter("whisper_registrations_total",
+			"Accounts registered."),
+	}
+
+	r.GaugeFunc("whisper_sessions_active", "Client connections currently open.", nil,
+		func(emit metrics.Emit) {
+			emit(float64(len(s.sessions.list())))
+		})
+
+	r.GaugeFunc("whisper_queue_depth", "Entries waiting in each of the server's in-memory queues.", []string{"queue"},
+		func(emit metrics.Emit) {
+			s.links.mu.Lock()
+			emit(float64(len(s.links.pending)), "device links")
+			s.links.mu.Unlock()
+
+			s.challenges.mu.Lock()
+			emit(float64(len(s.challenges.issued)), "registration challenges")
+			s.challenges.mu.Unlock()
+
+			s.replays.mu.Lock()
+			emit(float64(len(s.replays.seen)), "replay nonces")
+			s.replays.mu.Unlock()
+		})
+
+	r.CounterFunc("whisper_rate_limited_total", "Requests refused by rate limits, by command and limit.", []string{"command", "limit"},
+		func(emit metrics.Emit) {
+			for command, kinds := range s.limits.rejections() {
+				for kind, n := range kinds {
+					emit(float64(n), command, kind)
+				}
+			}
+		})
+
+	r.CounterFunc("whisper_storage_errors_total", "Failed reads and writes of the server's stored files.", []string{"op"},
+		func(emit metrics.Emit) {
+			reads, writes := store.Errors()
+			emit(float64(reads), "read")
+			emit(float64(writes), "write")
+		})
+
+	return m
+}
+
+// handshake records the result of a key exchange with a connecting client.
+func (m *serverMetrics) handshake(err error) {
+	if err == nil {
+		m.handshakes.Inc()
+		return
+	}
+
+	reason := "other"
+	if herr, ok := err.(*connection.HandshakeError); ok {
+		reason = herr.Reason
+	}
+	m.handshakeFailures.Inc(reason)
+}
+
+// request records a handled request. Unknown commands share one label so
+// clients can not create series at will.
+func (m *serverMetrics) request(command string, err error, took time.Duration) {
+	if _, ok := handlers[command]; !ok {
+		command = "unknown"
+	}
+
+	result := "ok"
+	if err != nil {
+		result = string(protocol.CodeInternal)
+		if perr, ok := err.(*protocol.Error); ok {
+			result = string(perr.Code)
+		}
+	}
+
+	m.requests.Inc(command, result)
+	m.requestDuration.Observe(took.Seconds(), command)
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/connection"
+	"github.com/joshvanl/go-whisper/pkg/store"
+	"github.com/joshvanl/go-whisper/pkg/uid"
+)
+
+const (
+	optionsFile = "server.json"
+)
+
+// Options holds server only settings, read from server.json in the server's
+// config directory. A missing file gives the defaults.
+type Options struct {
+	// UIDStrategy selects how new UIDs are allocated: random, sequential or
+	// handle (clients may choose their own).
+	UIDStrategy string `json:"uidStrategy"`
+
+	// UsernameChangeInterval is the minimum time between username changes
+	// for a single UID.
+	UsernameChangeInterval Duration `json:"usernameChangeInterval"`
+
+	// UsernameHoldPeriod is how long a released username stays reserved
+	// for its previous owner.
+	UsernameHoldPeriod Duration `json:"usernameHoldPeriod"`
+
+	// RateLimits are keyed by request, such as "uid query", with "*" for
+	// all other requests. Entries in the file replace the default for
+	// that request only.
+	RateLimits map[string]RateLimits `json:"rateLimits"`
+
+	// Registration is who may register: open to anyone, invite for
+	// clients with an invite code, or closed.
+	Registration string `json:"registration"`
+
+	// MetricsAddress is where metrics are served over HTTP, at /metrics.
+	// Metrics are not served if it is empty.
+	MetricsAddress string `json:"metricsAddress"`
+
+	// HealthAddress is where /healthz and /readyz are served over HTTP
+	// for process supervisors. It may be the same as MetricsAddress.
+	HealthAddress string `json:"healthAddress"`
+
+	// HandshakeTimeout bounds a connecting client's key exchange.
+	HandshakeTimeout Duration `json:"handshakeTimeout"`
+	// IdleTimeout is how long a client may send nothing, not even a
+	// keepalive, before it is disconnected.
+	IdleTimeout Duration `json:"idleTimeout"`
+	// WriteTimeout bounds sending a single response.
+	WriteTimeout Duration `json:"writeTimeout"`
+	// KeepaliveInterval is how often clients are pinged.
+	KeepaliveInterval Duration `json:"keepaliveInterval"`
+}
+
+// Duration is a time.Duration written as a string such as "24h" in the
+// options file.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("durations must be strings such as \"24h\": %v", err)
+	}
+
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = duration
+
+	return nil
+}
+
+func defaultOptions() *Options {
+	return &Options{
+		UIDStrategy:            uid.StrategyRandom,
+		UsernameChangeInterval: Duration{24 * time.Hour},
+		UsernameHoldPeriod:     Duration{30 * 24 * time.Hour},
+		RateLimits:             defaultRateLimits(),
+		Registration:           RegistrationOpen,
+		HandshakeTimeout:       Duration{connection.DefaultTimeouts.Handshake},
+		IdleTimeout:            Duration{connection.DefaultTimeouts.Idle},
+		WriteTimeout:           Duration{connection.DefaultTimeouts.Write},
+		KeepaliveInterval:      Duration{connection.DefaultTimeouts.Keepalive},
+	}
+}
+
+// timeouts are the connection timeouts of new client connections.
+func (o *Options) timeouts() connection.Timeouts {
+	return connection.Timeouts{
+		Handshake: o.HandshakeTimeout.Duration,
+		Idle:      o.IdleTimeout.Duration,
+		Write:     o.WriteTimeout.Duration,
+		Keepalive: o.KeepaliveInterval.Duration,
+	}
+}
+
+func ReadOptions(dir string) (*Options, error) {
+	opts := defaultOptions()
+
+	if _, err := store.ReadJSON(filepath.Join(dir, optionsFile), opts); err != nil {
+		return nil, fmt.Errorf("failed to read server options file: %v", err)
+	}
+
+	switch opts.Registration {
+	case RegistrationOpen, RegistrationInvite, RegistrationClosed:
+	default:
+		return nil, fmt.Errorf("unknown registration policy %q, expected %q, %q or %q",
+			opts.Registration, RegistrationOpen, RegistrationInvite, RegistrationClosed)
+	}
+
+	// Clients ping at the default keepalive interval, so a shorter idle
+	// timeout would disconnect them between pings.
+	if idle := opts.IdleTimeout.Duration; idle > 0 && idle <= connection.DefaultTimeouts.Keepalive {
+		return nil, fmt.Errorf("idle timeout %s must be longer than the client keepalive interval %s",
+			idle, connection.DefaultTimeouts.Keepalive)
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/protocol"
+)
+
+const (
+	// anyRequest is the rate limits key applying to requests without
+	// limits of their own.
+	anyRequest = "*"
+
+	limitByAddress = "address"
+	limitByUID     = "uid"
+)
+
+// RateLimits are the limits for one request type. Address limits apply to
+// every request from a source address, UID limits to signed requests from
+// an account and all its devices.
+type RateLimits struct {
+	PerAddress Limit `json:"perAddress"`
+	PerUID     Limit `json:"perUID"`
+}
+
+// Limit is a token bucket that refills one request every Every, holding at
+// most Burst. A zero Limit is unlimited.
+type Limit struct {
+	Every Duration `json:"every"`
+	Burst int      `json:"burst"`
+}
+
+func (l Limit) unlimited() bool {
+	return l.Every.Duration <= 0 || l.Burst <= 0
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+
+	// tripped is set once a request is refused, until one is allowed.
+	tripped bool
+}
+
+// limiter holds the token buckets of every rate limited source.
+type limiter struct {
+	mu     sync.Mutex
+	limits map[string]RateLimits
+
+	buckets map[string]*bucket
+	pruned  time.Time
+
+	// rejected counts requests refused, by request and limit kind.
+	rejected map[[2]string]uint64
+
+	// tripped, if set, is called when a source first has a request
+	// refused by a limit since it last had one allowed.
+	tripped func(command, kind, source string)
+}
+
+func newLimiter(limits map[string]RateLimits, now time.Time) *limiter {
+	return &limiter{
+		limits:   limits,
+		buckets:  make(map[string]*bucket),
+		pruned:   now,
+		rejected: make(map[[2]string]uint64),
+	}
+}
+
+// setLimits replaces the limits of future requests, keeping the state of
+// existing buckets.
+func (l *limiter) setLimits(limits map[string]RateLimits) {
+	l.mu.Lock()
+	l.limits = limits
+	l.mu.Unlock()
+}
+
+// allowAddress takes a token for command from the host of addr.
+func (l *limiter) allowAddress(command string, addr net.Addr, now time.Time) error {
+	host := addr.String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	return l.allow(command, limitByAddress, host, now)
+}
+
+// allowUID takes a token for command from the account id.
+func (l *limiter) allowUID(command, id string, now time.Time) error {
+	return l.allow(command, limitByUID, id, now)
+}
+
+func (l *limiter) allow(command, kind, source string, now time.Time) error {
+	tripped, err := l.take(command, kind, source, now)
+	if tripped && l.tripped != nil {
+		l.tripped(command, kind, source)
+	}
+
+	return err
+}
+
+// take is allow without calling tripped, also reporting whether the
+// refusal is the first since source last had a request allowed.
+func (l *limiter) take(command, kind, source string, now time.Time) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limits, ok := l.limits[command]
+	if !ok {
+		command = anyRequest
+		limits = l.limits[anyRequest]
+	}
+
+	limit := limits.PerAddress
+	if kind == limitByUID {
+		limit = limits.PerUID
+	}
+	if limit.unlimited() {
+		return false, nil
+	}
+
+	l.prune(now)
+
+	k := command + "\x00" + kind + "\x00" + source
+	b, ok := l.buckets[k]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		l.buckets[k] = b
+	}
+
+	b.tokens += float64(now.Sub(b.last)) / float64(limit.Every.Duration)
+	if b.tokens > float64(limit.Burst) {
+		b.tokens = float64(limit.Burst)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		l.rejected[[2]string{command, kind}]++
+
+		tripped := !b.tripped
+		b.tripped = true
+
+		return tripped, protocol.Errorf(protocol.CodeRateLimited, "too many %s requests from this %s", command, kind)
+	}
+	b.tokens--
+	b.tripped = false
+
+	return false, nil
+}
+
+// prune forgets buckets that have been idle long enough to refill, since
+// they behave the same as new ones.
+func (l *limiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < time.Minute {
+		return
+	}
+	l.pruned = now
+
+	var refill time.Duration
+	for _, limits := range l.limits {
+		for _, limit := range []Limit{limits.PerAddress, limits.PerUID} {
+			if d := limit.Every.Duration * time.Duration(limit.Burst); d > refill {
+				refill = d
+			}
+		}
+	}
+
+	for k, b := range l.buckets {
+		if now.Sub(b.last) > refill {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// rejections returns the number of requests refused by rate limits, keyed
+// by request and then by limit kind, "address" or "uid".
+func (l *limiter) rejections() map[string]map[string]uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rejected := make(map[string]map[string]uint64)
+	for k, n := range l.rejected {
+		if rejected[k[0]] == nil {
+			rejected[k[0]] = make(map[string]uint64)
+		}
+		rejected[k[0]][k[1]] = n
+	}
+
+	return rejected
+}
+
+// defaultRateLimits allow, per address, registration and link requests at a
+// sustained 1 a minute after a burst of 5, and uid and username queries at 1
+// a second after a burst of 30, also per account. Other requests are allowed
+// at 20 a second per address and 10 a second per account.
+func defaultRateLimits() map[string]RateLimits {
+	registration := RateLimits{
+		PerAddress: Limit{Every: Duration{time.Minute}, Burst: 5},
+	}
+
+	return map[string]RateLimits{
+		anyRequest: {
+			PerAddress: Limit{Every: Duration{50 * time.Millisecond}, Burst: 100},
+			PerUID:     Limit{Every: Duration{100 * time.Millisecond}, Burst: 50},
+		},
+		"registration challenge": registration,
+		"first connection":       registration,
+		"link request":           registration,
+		"uid query": {
+			PerAddress: Limit{Every: Duration{time.Second}, Burst: 30},
+			PerUID:     Limit{Every: Duration{time.Second}, Burst: 30},
+		},
+		"username query": {
+			PerAddress: Limit{Every: Duration{time.Second}, Burst: 30},
+			PerUID:     Limit{Every: Duration{time.Second}, Burst: 30},
+		},
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/protocol"
+)
+
+func Test_Limiter(t *testing.T) {
+	now := time.Now()
+	l := newLimiter(map[string]RateLimits{
+		anyRequest: {
+			PerUID: Limit{Every: Duration{time.Second}, Burst: 2},
+		},
+		"first connection": {
+			PerAddress: Limit{Every: Duration{time.Minute}, Burst: 1},
+		},
+	}, now)
+
+	addr := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}
+	sameHost := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5678}
+	otherHost := &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 1234}
+
+	if err := l.allowAddress("first connection", addr, now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := l.allowAddress("first connection", sameHost, now); !errors.Is(err, protocol.ErrRateLimited) {
+		t.Errorf("expected rate limited error from same host, got=%v", err)
+	}
+	if err := l.allowAddress("first connection", otherHost, now); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := l.allowAddress("first connection", addr, now.Add(time.Minute)); err != nil {
+		t.Errorf("expected bucket to refill, got=%v", err)
+	}
+
+	// Requests without their own limits share the "*" limits, which here
+	// only limit by uid.
+	for i := 0; i < 10; i++ {
+		if err := l.allowAddress("uid query", addr, now); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := l.allowUID("uid query", "1", now); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := l.allowUID("tree head", "1", now); !errors.Is(err, protocol.ErrRateLimited) {
+		t.Errorf("expected rate limited error, got=%v", err)
+	}
+	if err := l.allowUID("uid query", "2", now); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	rejected := l.rejections()
+	if rejected["first connection"][limitByAddress] != 1 || rejected[anyRequest][limitByUID] != 1 {
+		t.Errorf("unexpected rejection counts: %v", rejected)
+	}
+}
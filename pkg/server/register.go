@@ -0,0 +1,164 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/audit"
+	"github.com/joshvanl/go-whisper/pkg/connection"
+	"github.com/joshvanl/go-whisper/pkg/key"
+	"github.com/joshvanl/go-whisper/pkg/logging"
+	"github.com/joshvanl/go-whisper/pkg/protocol"
+	"github.com/joshvanl/go-whisper/pkg/uid"
+)
+
+const (
+	challengeSize    = 32
+	challengeTimeout = time.Minute
+)
+
+// challenge is a nonce issued to a connection that a registering client must
+// sign along with its key.
+type challenge struct {
+	nonce   []byte
+	expires time.Time
+}
+
+type challenges struct {
+	mu     sync.Mutex
+	issued map[*connection.Connection]*challenge
+}
+
+// registrationChallenge handles:
+//
+//	"registration challenge"
+//
+// and responds with a fresh nonce for the connection to sign in its
+// "first connection" request. Each connection holds at most one challenge.
+func (s *Server) registrationChallenge(conn *connection.Connection, recv [][]byte) error {
+	if len(recv) != 1 {
+		return newRequestError(protocol.CodeMalformed, "unexpected number of parameters, exp=1 got=%d", len(recv))
+	}
+
+	nonce := make([]byte, challengeSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate registration challenge: %v", err)
+	}
+
+	s.challenges.mu.Lock()
+	s.challenges.issued[conn] = &challenge{
+		nonce:   nonce,
+		expires: time.Now().Add(challengeTimeout),
+	}
+	s.challenges.mu.Unlock()
+
+	if err := s.writeSigned(conn, connection.Params([]byte("challenge"), nonce)); err != nil {
+		return fmt.Errorf("failed to write registration challenge: %v", err)
+	}
+
+	return nil
+}
+
+// take removes and returns whether nonce is the unexpired challenge issued
+// to conn, so each challenge is used at most once.
+func (c *challenges) take(conn *connection.Connection, nonce []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch, ok := c.issued[conn]
+	if !ok {
+		return false
+	}
+	delete(c.issued, conn)
+
+	return time.Now().Before(ch.expires) &&
+		subtle.ConstantTimeCompare(ch.nonce, nonce) == 1
+}
+
+func (c *challenges) forget(conn *connection.Connection) {
+	c.mu.Lock()
+	delete(c.issued, conn)
+	c.mu.Unlock()
+}
+
+// newClient handles:
+//
+//	"first connection", public key, challenge, [requested uid, [invite]],
+//	signature
+//
+// where the signature is by the registering key over the whole request, and
+// challenge was issued to this connection by "registration challenge". The
+// requested uid may be empty when only an invite is sent. It responds with
+// the new uid and the server public key, or an error response.
+func (s *Server) newClient(conn *connection.Connection, recv [][]byte) error {
+	if len(recv) < 4 || len(recv) > 6 {
+		return newRequestError(protocol.CodeMalformed, "unexpected number of parameters, exp=4 to 6 got=%d", len(recv))
+	}
+
+	pk, err := key.ParsePublicKey(recv[1])
+	if err != nil {
+		return newRequestError(protocol.CodeInvalidKey, "failed to parse public key: %v", err)
+	}
+
+	if err := key.CheckPublicKey(pk); err == key.ErrWeakKey {
+		return newRequestError(protocol.CodeWeakKey, "keys must be Ed25519 or RSA of at least %d bits", key.MinRSAKeySize)
+	} else if err != nil {
+		return newRequestError(protocol.CodeInvalidKey, "%v", err)
+	}
+
+	if !s.challenges.take(conn, recv[2]) {
+		return newRequestError(protocol.CodeBadChallenge, "registration challenge is unknown, expired or already used")
+	}
+
+	if err := s.key.VerifyPayload(pk, connection.Params(recv[:len(recv)-1]...), recv[len(recv)-1]); err != nil {
+		return s.badSignature(conn, string(recv[0]), "", "", "registration is not signed by the registering key")
+	}
+
+	// A fourth parameter is the uid the client would like to be given.
+	var requested uint64
+	if len(recv) >= 5 && len(recv[3]) > 0 {
+		requested, err = uid.Parse(string(recv[3]))
+		if err != nil {
+			return newRequestError(protocol.CodeMalformed, "failed to parse requested uid: %v", err)
+		}
+	}
+
+	var invite string
+	if len(recv) == 6 {
+		invite = string(recv[4])
+	}
+
+	var id string
+	register := func() error {
+		var err error
+		id, err = s.registry.Register(pk, requested)
+		switch err {
+		case nil:
+			return nil
+		case uid.ErrTaken, uid.ErrInvalid, uid.ErrNotAllowed, uid.ErrExhausted:
+			return newRequestError(protocol.CodeUIDUnavailable, "%v", err)
+		default:
+			return fmt.Errorf("failed to create new uid: %v", err)
+		}
+	}
+
+	if err := s.checkRegistration(invite, register); err != nil {
+		return err
+	}
+	s.metrics.registrations.Inc()
+	s.connLog(conn).WithField(logging.FieldUID, id).Infof("registered account")
+	s.record(conn, audit.Entry{
+		Event:  audit.EventRegistered,
+		UID:    id,
+		Detail: keyDetail(id, pk),
+	})
+
+	if err := s.writeSigned(conn, connection.Params([]byte(id), s.key.PublicKey())); err != nil {
+		return fmt.Errorf("failed to send payload to client: %v", err)
+	}
+
+	return nil
+}
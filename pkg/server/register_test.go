@@ -0,0 +1,215 @@
+package server
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/joshvanl/go-whisper/pkg/audit"
+	"github.com/joshvanl/go-whisper/pkg/connection"
+	"github.com/joshvanl/go-whisper/pkg/key"
+	"github.com/joshvanl/go-whisper/pkg/protocol"
+	"github.com/joshvanl/go-whisper/pkg/registry"
+	"github.com/joshvanl/go-whisper/pkg/uid"
+	"github.com/joshvanl/go-whisper/pkg/username"
+)
+
+func newTestServer(t *testing.T) (*Server, func()) {
+	dir, err := ioutil.TempDir("", "go-whisper-server")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	k, err := key.New(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := k.NewUIDs(0); err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	alloc, err := uid.NewAllocator(uid.StrategySequential)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r, err := registry.New(dir, k, alloc)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	usernames, err := username.NewDirectory(dir, username.Rules{})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s := &Server{
+		log:       logrus.NewEntry(logrus.New()),
+		dir:       dir,
+		key:       k,
+		registry:  r,
+		usernames: usernames,
+		links: links{
+			pending: make(map[string]*pendingLink),
+		},
+		challenges: challenges{
+			issued: make(map[*connection.Connection]*challenge),
+		},
+		replays: newReplays(time.Now()),
+		limits:  newLimiter(defaultRateLimits(), time.Now()),
+		options: defaultOptions(),
+		started: time.Now(),
+		sessions: sessions{
+			live: make(map[*connection.Connection]*Session),
+		},
+		audit: audit.Open(dir, k),
+	}
+	s.metrics = newServerMetrics(s)
+
+	return s, func() { os.RemoveAll(dir) }
+}
+
+// dialTestServer connects a client connection to s over loopback.
+func dialTestServer(t *testing.T, s *Server) *connection.Connection {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The key exchange reads whatever has arrived, so the client must not
+	// send a request until the server side has finished it.
+	ready := make(chan struct{})
+	go func() {
+		defer ln.Close()
+		defer close(ready)
+
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		conn, err := connection.New(c)
+		if err != nil {
+			return
+		}
+
+		ready <- struct{}{}
+		s.Handle(conn)
+	}()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	conn, err := connection.New(c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	<-ready
+
+	return conn
+}
+
+func Test_Registration(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	conn := dialTestServer(t, s)
+	defer conn.Close()
+
+	sk, err := key.GenerateType(key.TypeEd25519)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pk, err := key.MarshalPublicKey(sk.Public())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	register := func(nonce []byte) [][]byte {
+		send := connection.AppendParams(connection.Params([]byte("first connection"), pk), nonce)
+
+		sig, err := key.SignWith(sk, send)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if err := conn.Write(connection.AppendParams(send, sig)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		res, _, err := conn.Read()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		return res
+	}
+
+	challenge := func() []byte {
+		if err := conn.Write(connection.Params([]byte("registration challenge"))); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		res, _, err := conn.Read()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(res) != 3 || string(res[0]) != "challenge" {
+			t.Fatalf("unexpected challenge response: %q", res)
+		}
+
+		return res[1]
+	}
+
+	// Registering without a challenge from the server is refused.
+	res := register([]byte("made up"))
+	if string(res[0]) != "error" || protocol.Code(res[1]) != protocol.CodeBadChallenge {
+		t.Errorf("expected bad challenge error, got=%q", res)
+	}
+
+	nonce := challenge()
+	res = register(nonce)
+	if string(res[0]) == "error" || !s.registry.Exists(string(res[0])) {
+		t.Fatalf("expected registration, got=%q", res)
+	}
+
+	// Challenges can only be used once.
+	res = register(nonce)
+	if string(res[0]) != "error" || protocol.Code(res[1]) != protocol.CodeBadChallenge {
+		t.Errorf("expected bad challenge error on reuse, got=%q", res)
+	}
+
+	// A signature by a different key does not prove possession.
+	other, err := key.GenerateType(key.TypeEd25519)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	send := connection.AppendParams(connection.Params([]byte("first connection"), pk), challenge())
+	sig, err := key.SignWith(other, send)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := conn.Write(connection.AppendParams(send, sig)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res, _, err = conn.Read()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(res[0]) != "error" || protocol.Code(res[1]) != protocol.CodeBadSignature {
+		t.Errorf("expected bad signature error, got=%q", res)
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/protocol"
+)
+
+const (
+	// freshnessParams is the number of parameters, a timestamp and a
+	// nonce, that signed client requests carry before their signature.
+	freshnessParams = 2
+
+	// requestWindow is how far a request's timestamp may be from the
+	// server's clock. Nonces are remembered for as long as their request
+	// could still be accepted.
+	requestWindow = 5 * time.Minute
+
+	minNonceSize = 16
+)
+
+var (
+	ErrReplayed = protocol.Errorf(protocol.CodeReplayed, "request has already been seen")
+	ErrStale    = protocol.Errorf(protocol.CodeStale, "request timestamp is outside the accepted window")
+)
+
+// replays remembers the nonces of recently accepted signed requests so a
+// captured request can not be sent again. Nonces are chosen by the client
+// rather than issued by the server, so a request needs no extra round trip
+// and may be sent on any connection; the timestamp window bounds how long
+// each nonce must be remembered.
+//
+// Nonces are only held in memory. A request accepted in the window before a
+// restart can therefore be replayed once after it, until its timestamp
+// leaves the window. Refusing requests signed before the restart would close
+// that gap, but would also refuse honest clients whose clocks lag the
+// server's.
+type replays struct {
+	mu sync.Mutex
+
+	seen   map[string]time.Time
+	pruned time.Time
+}
+
+func newReplays(now time.Time) replays {
+	return replays{
+		seen:   make(map[string]time.Time),
+		pruned: now,
+	}
+}
+
+// check accepts a request from sender signed with timestamp, in unix
+// nanoseconds, and nonce, if it is fresh and its nonce has not been seen.
+func (r *replays) check(sender string, timestamp, nonce []byte, now time.Time) error {
+	ns, err := strconv.ParseInt(string(timestamp), 10, 64)
+	if err != nil {
+		return newRequestError(protocol.CodeMalformed, "failed to parse request timestamp: %v", err)
+	}
+	if len(nonce) < minNonceSize {
+		return newRequestError(protocol.CodeMalformed, "request nonce too short, exp>=%d got=%d", minNonceSize, len(nonce))
+	}
+
+	t := time.Unix(0, ns)
+	if t.Before(now.Add(-requestWindow)) || t.After(now.Add(requestWindow)) {
+		return ErrStale
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now.Sub(r.pruned) > requestWindow {
+		for k, expires := range r.seen {
+			if now.After(expires) {
+				delete(r.seen, k)
+			}
+		}
+		r.pruned = now
+	}
+
+	k := sender + "\x00" + string(nonce)
+	if _, ok := r.seen[k]; ok {
+		return ErrReplayed
+	}
+
+	// The nonce must be kept until the timestamp leaves the window.
+	r.seen[k] = t.Add(requestWindow)
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/key"
+	"github.com/joshvanl/go-whisper/pkg/protocol"
+)
+
+func Test_Replays(t *testing.T) {
+	now := time.Now()
+	r := newReplays(now)
+
+	stamp := func(t time.Time) []byte {
+		return []byte(strconv.FormatInt(t.UnixNano(), 10))
+	}
+	nonce := []byte("0123456789abcdef")
+
+	if err := r.check("1", stamp(now), nonce, now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := r.check("1", stamp(now), nonce, now); err != ErrReplayed {
+		t.Errorf("expected replayed error, got=%v", err)
+	}
+
+	// Nonces are per sender.
+	if err := r.check("1:2", stamp(now), nonce, now); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := r.check("1", stamp(now.Add(-requestWindow-time.Second)), []byte("fedcba9876543210"), now); err != ErrStale {
+		t.Errorf("expected stale error for old request, got=%v", err)
+	}
+
+	if err := r.check("1", stamp(now.Add(requestWindow+time.Second)), []byte("fedcba9876543210"), now); err != ErrStale {
+		t.Errorf("expected stale error for future request, got=%v", err)
+	}
+
+	// Once the window has passed the nonce is forgotten, but the request is
+	// then stale anyway.
+	later := now.Add(2 * requestWindow)
+	if err := r.check("1", stamp(later), []byte("another nonce 16"), later); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, ok := r.seen["1\x00"+string(nonce)]; ok {
+		t.Errorf("expected expired nonce to be pruned")
+	}
+
+	// A client whose clock lags the server's is served straight after the
+	// server starts.
+	r = newReplays(now)
+	if err := r.check("1", stamp(now.Add(-time.Minute)), nonce, now); err != nil {
+		t.Errorf("unexpected error for request signed before start: %v", err)
+	}
+}
+
+func Test_ReplayedRequest(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	conn := dialTestServer(t, s)
+	defer conn.Close()
+
+	sk, err := key.GenerateType(key.TypeEd25519)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	id, err := s.registry.Register(sk.Public(), 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The same request, nonce and all, is only served once.
+	nonce := []byte("0123456789abcdef")
+
+	res := signedRequestNonce(t, conn, sk, nonce, "list devices", id)
+	if string(res[0]) != "devices" {
+		t.Fatalf("expected devices response, got=%q", res)
+	}
+
+	res = signedRequestNonce(t, conn, sk, nonce, "list devices", id)
+	if string(res[0]) != "error" || protocol.Code(res[1]) != protocol.CodeReplayed {
+		t.Errorf("expected replayed error response, got=%q", res)
+	}
+}
@@ -3,12 +3,19 @@ package server
 import (
 	"fmt"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
+	"github.com/joshvanl/go-whisper/pkg/audit"
 	"github.com/joshvanl/go-whisper/pkg/config"
 	"github.com/joshvanl/go-whisper/pkg/connection"
 	"github.com/joshvanl/go-whisper/pkg/key"
+	"github.com/joshvanl/go-whisper/pkg/logging"
+	"github.com/joshvanl/go-whisper/pkg/registry"
+	"github.com/joshvanl/go-whisper/pkg/uid"
+	"github.com/joshvanl/go-whisper/pkg/username"
 )
 
 type Server struct {
@@ -17,12 +24,31 @@ type Server struct {
 	addr string
 	dir  string
 
-	clientUids map[string]bool
+	registry  *registry.Registry
+	usernames *username.Directory
 
 	key  *key.Key
 	conn net.Conn
 
+	listenerMu sync.Mutex
+	listener   net.Listener
+
 	config *config.Config
+
+	optionsMu sync.RWMutex
+	options   *Options
+
+	links      links
+	challenges challenges
+	replays    replays
+	limits     *limiter
+
+	invitesMu sync.Mutex
+
+	started  time.Time
+	sessions sessions
+	metrics  *serverMetrics
+	audit    *audit.Log
 }
 
 func New(addr string, dir string, log *logrus.Entry) (*Server, error) {
@@ -38,6 +64,18 @@ func New(addr string, dir string, log *logrus.Entry) (*Server, error) {
 		addr: addr,
 		dir:  dir,
 		key:  k,
+		links: links{
+			pending: make(map[string]*pendingLink),
+		},
+		challenges: challenges{
+			issued: make(map[*connection.Connection]*challenge),
+		},
+		replays: newReplays(time.Now()),
+		started: time.Now(),
+		audit:   audit.Open(dir, k),
+		sessions: sessions{
+			live: make(map[*connection.Connection]*Session),
+		},
 	}
 
 	log.Infof("Retrieving local server config...")
@@ -47,6 +85,15 @@ func New(addr string, dir string, log *logrus.Entry) (*Server, error) {
 	}
 	server.config = config
 
+	options, err := ReadOptions(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read options: %v", err)
+	}
+	server.options = options
+	server.limits = newLimiter(options.RateLimits, time.Now())
+	server.limits.tripped = server.rateLimited
+	server.metrics = newServerMetrics(server)
+
 	if addr != "" {
 		server.addr = addr
 	} else {
@@ -58,35 +105,83 @@ func New(addr string, dir string, log *logrus.Entry) (*Server, error) {
 		return nil, err
 	}
 
-	clientUids, err := server.key.UIDsFromFile()
+	alloc, err := uid.NewAllocator(server.options.UIDStrategy)
+	if err != nil {
+		return nil, err
+	}
+
+	server.registry, err = registry.New(dir, server.key, alloc)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := server.checkServerKey(); err != nil {
+		return nil, err
+	}
+
+	log.Infof("Retrieving local usernames...")
+	server.usernames, err = username.NewDirectory(dir, username.Rules{
+		ChangeInterval: server.options.UsernameChangeInterval.Duration,
+		HoldPeriod:     server.options.UsernameHoldPeriod.Duration,
+	})
 	if err != nil {
 		return nil, err
 	}
-	server.clientUids = clientUids
 
 	return server, nil
 }
 
 func (s *Server) Serve() error {
+	control, err := s.listenControl()
+	if err != nil {
+		return err
+	}
+	defer control.Close()
+	go s.serveControl(control)
+
+	listeners, err := s.listenHTTP()
+	if err != nil {
+		return err
+	}
+	for _, l := range listeners {
+		defer l.Close()
+		go s.serveHTTP(l)
+	}
+
 	ln, err := net.Listen("tcp", s.addr)
 	if err != nil {
 		return fmt.Errorf("failed to serve address: %v", err)
 	}
 
+	s.listenerMu.Lock()
+	s.listener = ln
+	s.listenerMu.Unlock()
+
 	for {
 
 		c, err := ln.Accept()
 		if err != nil {
-			fmt.Printf("failed to accept connection: %v", err)
+			s.log.WithError(err).Errorf("failed to accept connection")
 			continue
 		}
 
-		conn, err := connection.New(c)
-		if err != nil {
-			return err
-		}
+		go s.accept(c)
+	}
 
-		go s.Handle(conn)
+}
+
+// accept exchanges keys with a new client and serves it. A failed exchange
+// only drops that client.
+func (s *Server) accept(c net.Conn) {
+	id := logging.NewSessionID()
+	log := s.sessionLog(id, c.RemoteAddr())
+
+	conn, err := connection.NewWithTimeouts(c, log, s.currentOptions().timeouts())
+	s.metrics.handshake(err)
+	if err != nil {
+		c.Close()
+		return
 	}
 
+	s.handle(conn, id, log)
 }
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/joshvanl/go-whisper/pkg/connection"
+	"github.com/joshvanl/go-whisper/pkg/logging"
+)
+
+// Session describes a live client connection to an admin.
+type Session struct {
+	// ID is the session field of the connection's log lines.
+	ID      string    `json:"id"`
+	Address string    `json:"address"`
+	Started time.Time `json:"started"`
+
+	// UID and Device are of the last signed request on the connection,
+	// and empty until one is verified.
+	UID    string `json:"uid,omitempty"`
+	Device string `json:"device,omitempty"`
+
+	Requests uint64    `json:"requests"`
+	LastSeen time.Time `json:"lastSeen"`
+
+	log *logrus.Entry
+}
+
+// sessions tracks the server's live connections so admins can list and kick
+// them.
+type sessions struct {
+	mu   sync.Mutex
+	live map[*connection.Connection]*Session
+}
+
+func (s *sessions) open(conn *connection.Connection, id string, log *logrus.Entry, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.live[conn] = &Session{
+		ID:       id,
+		Address:  conn.RemoteAddr().String(),
+		Started:  now,
+		LastSeen: now,
+		log:      log,
+	}
+}
+
+func (s *sessions) close(conn *connection.Connection) {
+	s.mu.Lock()
+	delete(s.live, conn)
+	s.mu.Unlock()
+}
+
+func (s *sessions) request(conn *connection.Connection, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if session, ok := s.live[conn]; ok {
+		session.Requests++
+		session.LastSeen = now
+	}
+}
+
+// identify records that conn has sent a verified request from id, adding
+// it to the session's log lines.
+func (s *sessions) identify(conn *connection.Connection, id, device string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.live[conn]
+	if !ok || (session.UID == id && session.Device == device) {
+		return
+	}
+
+	session.UID, session.Device = id, device
+	session.log = session.log.WithField(logging.FieldUID, id)
+	if device != "" {
+		session.log = session.log.WithField(logging.FieldDevice, device)
+	}
+}
+
+// log returns the logger of conn's session, or nil if it has none.
+func (s *sessions) log(conn *connection.Connection) *logrus.Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if session, ok := s.live[conn]; ok {
+		return session.log
+	}
+
+	return nil
+}
+
+// get returns a copy of conn's session.
+func (s *sessions) get(conn *connection.Connection) (Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.live[conn]
+	if !ok {
+		return Session{}, false
+	}
+
+	return *session, true
+}
+
+// list returns a copy of every live session, oldest first.
+func (s *sessions) list() []Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Session, 0, len(s.live))
+	for _, session := range s.live {
+		list = append(list, *session)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Started.Before(list[j].Started)
+	})
+
+	return list
+}
+
+// kick closes every connection identified as id, returning how many were
+// closed. Their handlers return once their next read fails.
+func (s *sessions) kick(id string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var kicked int
+	for conn, session := range s.live {
+		if session.UID == id {
+			conn.Close()
+			kicked++
+		}
+	}
+
+	return kicked
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/connection"
+	"github.com/joshvanl/go-whisper/pkg/protocol"
+	"github.com/joshvanl/go-whisper/pkg/transparency"
+)
+
+// treeHead handles:
+//
+//	"tree head", uid, [seen tree size], timestamp, nonce, signature
+//
+// and responds with the signed head of the key transparency log and the
+// consistency proof from the tree size the client last saw.
+func (s *Server) treeHead(conn *connection.Connection, recv [][]byte) error {
+	if len(recv) != 5 && len(recv) != 6 {
+		return newRequestError(protocol.CodeMalformed, "unexpected number of parameters, exp=5 or 6 got=%d", len(recv))
+	}
+
+	if _, err := s.verifyClient(conn, recv); err != nil {
+		return err
+	}
+
+	seen, err := seenTreeSize(recv, 6)
+	if err != nil {
+		return err
+	}
+
+	head, consistency, err := s.registry.TreeHead(time.Now(), seen)
+	if err != nil {
+		return err
+	}
+
+	if err := s.signTreeHead(head); err != nil {
+		return err
+	}
+
+	message := appendTreeHead(connection.Params([]byte("tree head")), head)
+	message = connection.AppendParams(message, transparency.EncodeProof(consistency))
+
+	if err := s.writeSigned(conn, message); err != nil {
+		return fmt.Errorf("failed to write to tree head request: %v", err)
+	}
+
+	return nil
+}
+
+// appendKey appends to message the signed tree head of the log, the index
+// and inclusion proof of id's current key, and the consistency proof from
+// the tree size seen. Then follows the key itself and the old key and
+// signature of each of its key transitions, oldest first.
+func (s *Server) appendKey(message []byte, id string, seen uint64) ([]byte, error) {
+	proof, err := s.registry.Proof(id, seen)
+	if err != nil {
+		return nil, err
+	}
+
+	head := &transparency.TreeHead{
+		Size: proof.Size,
+		Root: proof.Root,
+		Time: time.Now(),
+	}
+	if err := s.signTreeHead(head); err != nil {
+		return nil, err
+	}
+
+	message = appendTreeHead(message, head)
+	message = connection.AppendParams(message, []byte(strconv.FormatUint(proof.Index, 10)))
+	message = connection.AppendParams(message, transparency.EncodeProof(proof.Inclusion))
+	message = connection.AppendParams(message, transparency.EncodeProof(proof.Consistency))
+
+	message = connection.AppendParams(message, proof.Key)
+	for _, t := range proof.Transitions {
+		message = connection.AppendParams(message, t.Old)
+		message = connection.AppendParams(message, t.Signature)
+	}
+
+	return message, nil
+}
+
+func (s *Server) signTreeHead(head *transparency.TreeHead) error {
+	sig, err := s.key.SignMessage(head.Message())
+	if err != nil {
+		return fmt.Errorf("failed to sign tree head: %v", err)
+	}
+	head.Signature = sig
+
+	return nil
+}
+
+// appendTreeHead appends the size, root, time and signature of head.
+func appendTreeHead(message []byte, head *transparency.TreeHead) []byte {
+	message = connection.AppendParams(message, []byte(strconv.FormatUint(head.Size, 10)))
+	message = connection.AppendParams(message, head.Root)
+	message = connection.AppendParams(message, []byte(strconv.FormatInt(head.Time.UnixNano(), 10)))
+	return connection.AppendParams(message, head.Signature)
+}
+
+// seenTreeSize returns the optional tree size a client sent as the second to
+// last parameter of a request of n parameters, or 0 if it was not sent.
+func seenTreeSize(recv [][]byte, n int) (uint64, error) {
+	if len(recv) != n {
+		return 0, nil
+	}
+
+	seen, err := strconv.ParseUint(string(recv[n-2-freshnessParams]), 10, 64)
+	if err != nil {
+		return 0, newRequestError(protocol.CodeMalformed, "failed to parse seen tree size: %v", err)
+	}
+
+	return seen, nil
+}
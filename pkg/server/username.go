@@ -0,0 +1,76 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/connection"
+	"github.com/joshvanl/go-whisper/pkg/protocol"
+	"github.com/joshvanl/go-whisper/pkg/username"
+)
+
+// claimUsername handles:
+//
+//	"claim username", uid, username, timestamp, nonce, signature
+func (s *Server) claimUsername(conn *connection.Connection, recv [][]byte) error {
+	if len(recv) != 6 {
+		return newRequestError(protocol.CodeMalformed, "unexpected number of parameters, exp=6 got=%d", len(recv))
+	}
+
+	clientUid, err := s.verifyPrimary(conn, recv)
+	if err != nil {
+		return err
+	}
+
+	err = s.usernames.Claim(clientUid, string(recv[2]), time.Now())
+	switch {
+	case err == nil:
+	case err == username.ErrTaken || err == username.ErrTooSoon || username.Validate(string(recv[2])) != nil:
+		return newRequestError(protocol.CodeRejected, "%v", err)
+	default:
+		return fmt.Errorf("failed to claim username: %v", err)
+	}
+
+	if err := s.writeSigned(conn, connection.Params([]byte("username claimed"))); err != nil {
+		return fmt.Errorf("failed to write to username claim: %v", err)
+	}
+
+	return nil
+}
+
+// usernameQuery handles:
+//
+//	"username query", uid, username, [seen tree size], timestamp, nonce,
+//	signature
+//
+// and responds with the uid and public key holding that username.
+func (s *Server) usernameQuery(conn *connection.Connection, recv [][]byte) error {
+	if len(recv) != 6 && len(recv) != 7 {
+		return newRequestError(protocol.CodeMalformed, "unexpected number of parameters, exp=6 or 7 got=%d", len(recv))
+	}
+
+	if _, err := s.verifyClient(conn, recv); err != nil {
+		return err
+	}
+
+	seen, err := seenTreeSize(recv, 7)
+	if err != nil {
+		return err
+	}
+
+	id, err := s.usernames.Lookup(string(recv[2]))
+	if err != nil || !s.registry.Exists(id) {
+		return newRequestError(protocol.CodeUnknownUID, "username %s does not exist", recv[2])
+	}
+
+	message, err := s.appendKey(connection.Params([]byte("username found"), []byte(id)), id, seen)
+	if err != nil {
+		return err
+	}
+
+	if err := s.writeSigned(conn, message); err != nil {
+		return fmt.Errorf("failed to write to username query: %v", err)
+	}
+
+	return nil
+}
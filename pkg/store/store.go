@@ -0,0 +1,85 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// readErrors and writeErrors count failed reads and writes, other than of
+// missing files, since the process started.
+var readErrors, writeErrors uint64
+
+// Errors returns the number of failed reads and writes since the process
+// started.
+func Errors() (reads, writes uint64) {
+	return atomic.LoadUint64(&readErrors), atomic.LoadUint64(&writeErrors)
+}
+
+// ReadJSON decodes the JSON file at path into v. A missing file is not an
+// error; it leaves v untouched and returns false.
+func ReadJSON(path string, v interface{}) (bool, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		atomic.AddUint64(&readErrors, 1)
+		return false, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+
+	if err := json.Unmarshal(b, v); err != nil {
+		atomic.AddUint64(&readErrors, 1)
+		return false, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	return true, nil
+}
+
+// WriteJSON encodes v to path. The file is written next to path and renamed
+// into place so readers never see a partial write.
+func WriteJSON(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		atomic.AddUint64(&writeErrors, 1)
+		return fmt.Errorf("failed to encode %s: %v", path, err)
+	}
+
+	return WriteFile(path, b)
+}
+
+// WriteFile atomically replaces path with b, with file mode 0600.
+func WriteFile(path string, b []byte) error {
+	if err := writeFile(path, b); err != nil {
+		atomic.AddUint64(&writeErrors, 1)
+		return err
+	}
+
+	return nil
+}
+
+func writeFile(path string, b []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %v", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to move %s into place: %v", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package transparency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// The log is a Merkle tree as described in RFC 6962: leaves are hashed as
+// SHA-256(0x00 || data) and interior nodes as SHA-256(0x01 || left || right).
+// Functions here work on leaf hashes, not leaf data.
+const (
+	HashSize = sha256.Size
+)
+
+var (
+	ErrInvalidProof = errors.New("invalid merkle proof")
+)
+
+// LeafHash returns the hash of a leaf holding data.
+func LeafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// RootHash returns the root of the tree with the given leaf hashes.
+func RootHash(leaves [][]byte) []byte {
+	return NewTree(leaves).Root()
+}
+
+// InclusionProof returns the audit path of leaf index in the tree of leaves.
+func InclusionProof(leaves [][]byte, index uint64) ([][]byte, error) {
+	return NewTree(leaves).InclusionProof(index)
+}
+
+// ConsistencyProof returns the proof that the tree of the first size leaves
+// is a prefix of the tree of all leaves.
+func ConsistencyProof(leaves [][]byte, size uint64) ([][]byte, error) {
+	return NewTree(leaves).ConsistencyProof(size)
+}
+
+// VerifyInclusion checks that leaf is at index in the tree of size with root.
+func VerifyInclusion(leaf []byte, index, size uint64, proof [][]byte, root []byte) error {
+	if index >= size {
+		return fmt.Errorf("%v: leaf index %d out of range of tree size %d", ErrInvalidProof, index, size)
+	}
+
+	fn, sn := index, size-1
+	r := leaf
+	for _, p := range proof {
+		if sn == 0 {
+			return fmt.Errorf("%v: inclusion proof too long", ErrInvalidProof)
+		}
+
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 || !bytes.Equal(r, root) {
+		return fmt.Errorf("%v: inclusion proof does not match root", ErrInvalidProof)
+	}
+
+	return nil
+}
+
+// VerifyConsistency checks that the tree of size1 with root1 is a prefix of
+// the tree of size2 with root2.
+func VerifyConsistency(size1, size2 uint64, root1, root2 []byte, proof [][]byte) error {
+	switch {
+	case size1 > size2:
+		return fmt.Errorf("%v: tree shrank from %d to %d", ErrInvalidProof, size1, size2)
+
+	case size1 == size2:
+		if len(proof) != 0 || !bytes.Equal(root1, root2) {
+			return fmt.Errorf("%v: different roots for tree size %d", ErrInvalidProof, size1)
+		}
+		return nil
+
+	case size1 == 0:
+		if len(proof) != 0 {
+			return fmt.Errorf("%v: unexpected proof from empty tree", ErrInvalidProof)
+		}
+		return nil
+	}
+
+	if len(proof) == 0 {
+		return fmt.Errorf("%v: empty consistency proof", ErrInvalidProof)
+	}
+
+	// A complete subtree is not included in the proof.
+	if size1&(size1-1) == 0 {
+		proof = append([][]byte{root1}, proof...)
+	}
+
+	fn, sn := size1-1, size2-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return fmt.Errorf("%v: consistency proof too long", ErrInvalidProof)
+		}
+
+		if fn&1 == 1 || fn == sn {
+			fr = nodeHash(c, fr)
+			sr = nodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = nodeHash(sr, c)
+		}
+
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 || !bytes.Equal(fr, root1) || !bytes.Equal(sr, root2) {
+		return fmt.Errorf("%v: consistency proof does not match roots", ErrInvalidProof)
+	}
+
+	return nil
+}
+
+// split returns the largest power of two less than n.
+func split(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
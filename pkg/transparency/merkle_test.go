@@ -0,0 +1,120 @@
+package transparency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+func testLeaves(n int) [][]byte {
+	var leaves [][]byte
+	for i := 0; i < n; i++ {
+		leaves = append(leaves, LeafHash([]byte(fmt.Sprintf("leaf %d", i))))
+	}
+	return leaves
+}
+
+// rootHash is the RFC 6962 definition of the root, recomputed from every leaf.
+func rootHash(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		h := sha256.Sum256(nil)
+		return h[:]
+	case 1:
+		return leaves[0]
+	}
+
+	k := split(len(leaves))
+	return nodeHash(rootHash(leaves[:k]), rootHash(leaves[k:]))
+}
+
+func Test_Tree(t *testing.T) {
+	leaves := testLeaves(70)
+
+	tree := new(Tree)
+	for n := 0; n <= len(leaves); n++ {
+		if tree.Size() != uint64(n) {
+			t.Fatalf("unexpected tree size, exp=%d got=%d", n, tree.Size())
+		}
+		if !bytes.Equal(tree.Root(), rootHash(leaves[:n])) {
+			t.Errorf("n=%d: unexpected root", n)
+		}
+
+		if n < len(leaves) {
+			tree.Append(leaves[n])
+		}
+	}
+}
+
+func Test_Inclusion(t *testing.T) {
+	for n := 1; n <= 33; n++ {
+		leaves := testLeaves(n)
+		root := RootHash(leaves)
+
+		for i := 0; i < n; i++ {
+			proof, err := InclusionProof(leaves, uint64(i))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := VerifyInclusion(leaves[i], uint64(i), uint64(n), proof, root); err != nil {
+				t.Errorf("n=%d i=%d: unexpected error: %v", n, i, err)
+			}
+
+			if err := VerifyInclusion(LeafHash([]byte("other")), uint64(i), uint64(n), proof, root); err == nil {
+				t.Errorf("n=%d i=%d: expected error for wrong leaf", n, i)
+			}
+		}
+	}
+}
+
+func Test_Consistency(t *testing.T) {
+	for n := 1; n <= 33; n++ {
+		leaves := testLeaves(n)
+		root := RootHash(leaves)
+
+		for m := 0; m <= n; m++ {
+			proof, err := ConsistencyProof(leaves, uint64(m))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			oldRoot := RootHash(leaves[:m])
+			if err := VerifyConsistency(uint64(m), uint64(n), oldRoot, root, proof); err != nil {
+				t.Errorf("m=%d n=%d: unexpected error: %v", m, n, err)
+			}
+
+			if m == 0 {
+				continue
+			}
+
+			// A log that rewrote history must not verify.
+			forked := append(testLeaves(m-1), LeafHash([]byte("forked")))
+			if err := VerifyConsistency(uint64(m), uint64(n), RootHash(forked), root, proof); err == nil {
+				t.Errorf("m=%d n=%d: expected error for forked tree", m, n)
+			}
+		}
+	}
+}
+
+func Test_DecodeProof(t *testing.T) {
+	leaves := testLeaves(7)
+	proof, err := InclusionProof(leaves, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := DecodeProof(EncodeProof(proof))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := VerifyInclusion(leaves[3], 3, 7, got, RootHash(leaves)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if _, err := DecodeProof(make([]byte, HashSize+1)); err == nil {
+		t.Errorf("expected error for truncated proof")
+	}
+}
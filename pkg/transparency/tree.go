@@ -0,0 +1,124 @@
+package transparency
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/bits"
+)
+
+// Tree is a Merkle tree that keeps the hash of every complete subtree, so
+// its root and proofs are found from O(log n) stored hashes rather than by
+// hashing every leaf. It is not safe for concurrent use while appending.
+type Tree struct {
+	// levels[h][i] is the hash of the complete subtree of 2^h leaves
+	// starting at leaf i*2^h.
+	levels [][][]byte
+}
+
+// NewTree returns the tree with the given leaf hashes.
+func NewTree(leaves [][]byte) *Tree {
+	t := new(Tree)
+	for _, leaf := range leaves {
+		t.Append(leaf)
+	}
+	return t
+}
+
+// Append adds a leaf hash to the tree.
+func (t *Tree) Append(leaf []byte) {
+	hash := leaf
+	for h := 0; ; h++ {
+		if h == len(t.levels) {
+			t.levels = append(t.levels, nil)
+		}
+		t.levels[h] = append(t.levels[h], hash)
+
+		n := len(t.levels[h])
+		if n%2 == 1 {
+			return
+		}
+		hash = nodeHash(t.levels[h][n-2], t.levels[h][n-1])
+	}
+}
+
+// Size returns the number of leaves in the tree.
+func (t *Tree) Size() uint64 {
+	if len(t.levels) == 0 {
+		return 0
+	}
+	return uint64(len(t.levels[0]))
+}
+
+// Root returns the root hash of the tree.
+func (t *Tree) Root() []byte {
+	return t.hash(0, int(t.Size()))
+}
+
+// InclusionProof returns the audit path of leaf index.
+func (t *Tree) InclusionProof(index uint64) ([][]byte, error) {
+	if index >= t.Size() {
+		return nil, fmt.Errorf("leaf index %d out of range of tree size %d", index, t.Size())
+	}
+
+	return t.inclusionProof(0, int(t.Size()), int(index)), nil
+}
+
+// ConsistencyProof returns the proof that the tree of its first size leaves
+// is a prefix of this one.
+func (t *Tree) ConsistencyProof(size uint64) ([][]byte, error) {
+	if size > t.Size() {
+		return nil, fmt.Errorf("tree size %d larger than log size %d", size, t.Size())
+	}
+
+	if size == 0 || size == t.Size() {
+		return nil, nil
+	}
+
+	return t.subProof(0, int(t.Size()), int(size), true), nil
+}
+
+// hash returns the root of the n leaves from start. Every subtree split off
+// as in RFC 6962 starts at a multiple of its size rounded up to a power of
+// two, so a complete one is always stored.
+func (t *Tree) hash(start, n int) []byte {
+	switch {
+	case n == 0:
+		h := sha256.Sum256(nil)
+		return h[:]
+	case n&(n-1) == 0:
+		h := bits.TrailingZeros(uint(n))
+		return t.levels[h][start>>h]
+	}
+
+	k := split(n)
+	return nodeHash(t.hash(start, k), t.hash(start+k, n-k))
+}
+
+func (t *Tree) inclusionProof(start, n, m int) [][]byte {
+	if n <= 1 {
+		return nil
+	}
+
+	k := split(n)
+	if m < k {
+		return append(t.inclusionProof(start, k, m), t.hash(start+k, n-k))
+	}
+
+	return append(t.inclusionProof(start+k, n-k, m-k), t.hash(start, k))
+}
+
+func (t *Tree) subProof(start, n, m int, complete bool) [][]byte {
+	if m == n {
+		if complete {
+			return nil
+		}
+		return [][]byte{t.hash(start, n)}
+	}
+
+	k := split(n)
+	if m <= k {
+		return append(t.subProof(start, k, m, complete), t.hash(start+k, n-k))
+	}
+
+	return append(t.subProof(start+k, n-k, m-k, false), t.hash(start, k))
+}
@@ -0,0 +1,52 @@
+package transparency
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// TreeHead is the server's signed statement of the log's size and root at a
+// point in time.
+type TreeHead struct {
+	Size      uint64    `json:"size"`
+	Root      []byte    `json:"root"`
+	Time      time.Time `json:"time"`
+	Signature []byte    `json:"signature,omitempty"`
+}
+
+// Message returns the bytes the server signs for the tree head.
+func (t *TreeHead) Message() []byte {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint64(b, t.Size)
+	binary.BigEndian.PutUint64(b[8:], uint64(t.Time.UnixNano()))
+
+	return bytes.Join([][]byte{[]byte("tree head"), b, t.Root}, []byte{0})
+}
+
+// Binding returns the leaf data logging that uid holds pk, given in its wire
+// encoding. A nil pk logs the deletion of uid.
+func Binding(uid string, pk []byte) []byte {
+	return bytes.Join([][]byte{[]byte(uid), pk}, []byte{0})
+}
+
+// EncodeProof joins proof hashes into a single parameter for the wire.
+func EncodeProof(proof [][]byte) []byte {
+	return bytes.Join(proof, nil)
+}
+
+// DecodeProof splits a parameter written by EncodeProof.
+func DecodeProof(b []byte) ([][]byte, error) {
+	if len(b)%HashSize != 0 {
+		return nil, fmt.Errorf("proof length %d is not a multiple of %d", len(b), HashSize)
+	}
+
+	var proof [][]byte
+	for len(b) > 0 {
+		proof = append(proof, b[:HashSize])
+		b = b[HashSize:]
+	}
+
+	return proof, nil
+}
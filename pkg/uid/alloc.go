@@ -0,0 +1,141 @@
+package uid
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+)
+
+const (
+	StrategyRandom     = "random"
+	StrategySequential = "sequential"
+	StrategyHandle     = "handle"
+
+	maxBody = Max / 10
+)
+
+var (
+	Strategies = []string{StrategyRandom, StrategySequential, StrategyHandle}
+
+	ErrTaken      = errors.New("uid is already taken")
+	ErrInvalid    = errors.New("requested uid is not valid")
+	ErrNotAllowed = errors.New("server does not allow choosing a uid")
+	ErrExhausted  = errors.New("no free uids left")
+)
+
+// Allocator picks new UIDs. taken reports whether a UID is already in use;
+// requested is the UID the client asked for, or 0 if it did not ask for one.
+// Every UID returned carries a valid check digit.
+type Allocator interface {
+	Allocate(taken func(uint64) bool, requested uint64) (uint64, error)
+}
+
+// Resumer is an Allocator that carries on from the highest UID already in
+// use, so that its order survives a restart.
+type Resumer interface {
+	Resume(highest uint64)
+}
+
+// NewAllocator returns the Allocator for the named strategy.
+func NewAllocator(strategy string) (Allocator, error) {
+	switch strategy {
+	case "", StrategyRandom:
+		return new(Random), nil
+	case StrategySequential:
+		return new(Sequential), nil
+	case StrategyHandle:
+		return new(Handle), nil
+	}
+
+	return nil, fmt.Errorf("unknown uid allocation strategy %q, expected one of %v", strategy, Strategies)
+}
+
+// Random allocates uniformly random UIDs.
+type Random struct{}
+
+func (r *Random) Allocate(taken func(uint64) bool, requested uint64) (uint64, error) {
+	if requested != 0 {
+		return 0, ErrNotAllowed
+	}
+
+	for {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(maxBody)))
+		if err != nil {
+			return 0, fmt.Errorf("failed to generate random number; %v", err)
+		}
+
+		// a zero body would give the server's reserved uid.
+		if n.Sign() == 0 {
+			continue
+		}
+
+		uid := WithCheckDigit(n.Uint64())
+		if !taken(uid) {
+			return uid, nil
+		}
+	}
+}
+
+// Sequential allocates the lowest free UID above the last one it handed out,
+// or above the highest in use when it was resumed.
+type Sequential struct {
+	mu   sync.Mutex
+	next uint64
+}
+
+func (s *Sequential) Allocate(taken func(uint64) bool, requested uint64) (uint64, error) {
+	if requested != 0 {
+		return 0, ErrNotAllowed
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.next == 0 {
+		s.next = 1
+	}
+
+	for ; s.next <= maxBody; s.next++ {
+		uid := WithCheckDigit(s.next)
+		if !taken(uid) {
+			s.next++
+			return uid, nil
+		}
+	}
+
+	return 0, ErrExhausted
+}
+
+// Resume moves the next UID handed out past highest. It never moves it back.
+func (s *Sequential) Resume(highest uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if next := highest/10 + 1; next > s.next {
+		s.next = next
+	}
+}
+
+// Handle lets clients choose their own UID, falling back to a random one
+// when the client does not ask for one.
+type Handle struct {
+	Random
+}
+
+func (h *Handle) Allocate(taken func(uint64) bool, requested uint64) (uint64, error) {
+	if requested == 0 {
+		return h.Random.Allocate(taken, 0)
+	}
+
+	if requested > Max || !Valid(requested) {
+		return 0, ErrInvalid
+	}
+
+	if taken(requested) {
+		return 0, ErrTaken
+	}
+
+	return requested, nil
+}
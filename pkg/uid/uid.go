@@ -0,0 +1,105 @@
+package uid
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Digits is the number of digits in a formatted UID, including the
+	// trailing check digit.
+	Digits = 11
+
+	// Max is the largest UID that fits in Digits.
+	Max = 99999999999
+
+	// Server is the UID reserved for the server's own key.
+	Server = 0
+)
+
+var (
+	ErrChecksum = errors.New("uid checksum does not match")
+)
+
+// Format returns the canonical display form of uid: zero padded to Digits.
+func Format(uid uint64) string {
+	return fmt.Sprintf("%0*d", Digits, uid)
+}
+
+// Key returns the form of uid used for file names and on the wire. It has
+// no leading zeros.
+func Key(uid uint64) string {
+	return strconv.FormatUint(uid, 10)
+}
+
+// Parse accepts a UID with or without zero padding, ignoring spaces and
+// dashes used to group digits. It does not check the checksum.
+func Parse(s string) (uint64, error) {
+	s = strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, s)
+
+	if len(s) == 0 || len(s) > Digits {
+		return 0, fmt.Errorf("uids must be at most %d digits", Digits)
+	}
+
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return 0, fmt.Errorf("uids must only contain digits")
+		}
+	}
+
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// ParseValid is Parse followed by a checksum validation.
+func ParseValid(s string) (uint64, error) {
+	uid, err := Parse(s)
+	if err != nil {
+		return 0, err
+	}
+
+	if !Valid(uid) {
+		return 0, ErrChecksum
+	}
+
+	return uid, nil
+}
+
+// Valid reports whether the last digit of uid is the Luhn check digit of the
+// remaining digits. UIDs issued before check digits were added have none,
+// so an existing UID failing Valid is not necessarily a typo.
+func Valid(uid uint64) bool {
+	return CheckDigit(uid/10) == uid%10
+}
+
+// WithCheckDigit appends the Luhn check digit to body.
+func WithCheckDigit(body uint64) uint64 {
+	return body*10 + CheckDigit(body)
+}
+
+// CheckDigit computes the Luhn check digit for body.
+func CheckDigit(body uint64) uint64 {
+	var sum uint64
+	double := true
+
+	for ; body > 0; body /= 10 {
+		d := body % 10
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+
+		sum += d
+		double = !double
+	}
+
+	return (10 - sum%10) % 10
+}
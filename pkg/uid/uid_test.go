@@ -0,0 +1,86 @@
+package uid
+
+import (
+	"testing"
+)
+
+func Test_CheckDigit(t *testing.T) {
+	// Standard Luhn example: 7992739871 has check digit 3.
+	if d := CheckDigit(7992739871); d != 3 {
+		t.Errorf("unexpected check digit, exp=3 got=%d", d)
+	}
+
+	if !Valid(79927398713) {
+		t.Errorf("expected 79927398713 to be valid")
+	}
+
+	// Any single digit typo must be caught.
+	for _, typo := range []uint64{79927398703, 79927398613, 89927398713} {
+		if Valid(typo) {
+			t.Errorf("expected %d to be invalid", typo)
+		}
+	}
+
+	// Swapping adjacent digits must be caught.
+	if Valid(97927398713) {
+		t.Errorf("expected transposed uid to be invalid")
+	}
+}
+
+func Test_FormatParse(t *testing.T) {
+	n := WithCheckDigit(42)
+
+	if s := Format(n); s != "00000000422" {
+		t.Errorf("unexpected format, exp=00000000422 got=%s", s)
+	}
+
+	if s := Key(n); s != "422" {
+		t.Errorf("unexpected key, exp=422 got=%s", s)
+	}
+
+	for _, s := range []string{"00000000422", "422", "000-0000-0422", "0000 0000 422"} {
+		got, err := ParseValid(s)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %v", s, err)
+			continue
+		}
+		if got != n {
+			t.Errorf("unexpected uid parsing %q, exp=%d got=%d", s, n, got)
+		}
+	}
+
+	for _, s := range []string{"", "abc", "123456789012", "0000000042x"} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("expected error parsing %q", s)
+		}
+	}
+
+	if _, err := ParseValid("00000000423"); err != ErrChecksum {
+		t.Errorf("expected checksum error, got=%v", err)
+	}
+}
+
+func Test_Sequential(t *testing.T) {
+	s := new(Sequential)
+	taken := map[uint64]bool{WithCheckDigit(1): true}
+
+	n, err := s.Allocate(func(n uint64) bool { return taken[n] }, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if n != WithCheckDigit(2) {
+		t.Errorf("unexpected uid, exp=%d got=%d", WithCheckDigit(2), n)
+	}
+
+	if _, err := s.Allocate(func(uint64) bool { return false }, n); err != ErrNotAllowed {
+		t.Errorf("expected not allowed error, got=%v", err)
+	}
+
+	// Resuming carries on past the highest uid in use, and never goes back.
+	s.Resume(WithCheckDigit(41))
+	s.Resume(WithCheckDigit(7))
+	if n, err := s.Allocate(func(uint64) bool { return false }, 0); err != nil || n != WithCheckDigit(42) {
+		t.Errorf("unexpected uid after resume, exp=%d got=%d err=%v", WithCheckDigit(42), n, err)
+	}
+}
@@ -0,0 +1,185 @@
+package username
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/joshvanl/go-whisper/pkg/store"
+)
+
+const (
+	directoryFile = "usernames.json"
+)
+
+var (
+	ErrTaken    = errors.New("username is already taken")
+	ErrTooSoon  = errors.New("username was changed too recently")
+	ErrNotFound = errors.New("username not found")
+)
+
+// Rules controls how usernames may change hands.
+type Rules struct {
+	// ChangeInterval is the minimum time between a UID claiming two
+	// different usernames.
+	ChangeInterval time.Duration
+
+	// HoldPeriod is how long a released username stays reserved for the
+	// UID that released it, so nobody else can pick it up and impersonate
+	// the previous owner.
+	HoldPeriod time.Duration
+}
+
+type entry struct {
+	UID      string    `json:"uid"`
+	Claimed  time.Time `json:"claimed"`
+	Released time.Time `json:"released,omitempty"`
+}
+
+// Directory maps usernames to UIDs. It is persisted to usernames.json in the
+// server directory on every change.
+type Directory struct {
+	mu    sync.RWMutex
+	path  string
+	rules Rules
+
+	names map[string]*entry
+	uids  map[string]string
+}
+
+func NewDirectory(dir string, rules Rules) (*Directory, error) {
+	d := &Directory{
+		path:  filepath.Join(dir, directoryFile),
+		rules: rules,
+		names: make(map[string]*entry),
+		uids:  make(map[string]string),
+	}
+
+	if _, err := store.ReadJSON(d.path, &d.names); err != nil {
+		return nil, fmt.Errorf("failed to read username directory: %v", err)
+	}
+
+	for name, e := range d.names {
+		if e.Released.IsZero() {
+			d.uids[e.UID] = name
+		}
+	}
+
+	return d, nil
+}
+
+// Claim gives name to uid, releasing any username uid held before.
+func (d *Directory) Claim(uid, name string, now time.Time) error {
+	if err := Validate(name); err != nil {
+		return err
+	}
+	name = Canonical(name)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	current, hasCurrent := d.uids[uid]
+	if hasCurrent && current == name {
+		return nil
+	}
+
+	if e, ok := d.names[name]; ok && e.UID != uid {
+		if e.Released.IsZero() || now.Sub(e.Released) < d.rules.HoldPeriod {
+			return ErrTaken
+		}
+	}
+
+	if hasCurrent {
+		if now.Sub(d.names[current].Claimed) < d.rules.ChangeInterval {
+			return ErrTooSoon
+		}
+
+		d.names[current].Released = now
+	}
+
+	d.names[name] = &entry{
+		UID:     uid,
+		Claimed: now,
+	}
+	d.uids[uid] = name
+
+	return d.write()
+}
+
+// Release frees the username held by uid, if any. The name is still held
+// for HoldPeriod.
+func (d *Directory) Release(uid string, now time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	name, ok := d.uids[uid]
+	if !ok {
+		return nil
+	}
+
+	d.names[name].Released = now
+	delete(d.uids, uid)
+
+	return d.write()
+}
+
+// Lookup returns the UID currently holding name.
+func (d *Directory) Lookup(name string) (string, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	e, ok := d.names[Canonical(name)]
+	if !ok || !e.Released.IsZero() {
+		return "", ErrNotFound
+	}
+
+	return e.UID, nil
+}
+
+// Username returns the username currently held by uid.
+func (d *Directory) Username(uid string) (string, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	name, ok := d.uids[uid]
+	return name, ok
+}
+
+// SetRules replaces the rules applied to future changes.
+func (d *Directory) SetRules(rules Rules) {
+	d.mu.Lock()
+	d.rules = rules
+	d.mu.Unlock()
+}
+
+// Compact forgets released usernames whose hold period has passed, returning
+// how many were removed. Anyone may claim them either way.
+func (d *Directory) Compact(now time.Time) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var removed int
+	for name, e := range d.names {
+		if !e.Released.IsZero() && now.Sub(e.Released) >= d.rules.HoldPeriod {
+			delete(d.names, name)
+			removed++
+		}
+	}
+
+	if removed == 0 {
+		return 0, nil
+	}
+
+	return removed, d.write()
+}
+
+// write must be called with the directory lock held.
+func (d *Directory) write() error {
+	if err := store.WriteJSON(d.path, d.names); err != nil {
+		return fmt.Errorf("failed to write username directory: %v", err)
+	}
+
+	return nil
+}
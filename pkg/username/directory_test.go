@@ -0,0 +1,92 @@
+package username
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_Validate(t *testing.T) {
+	for _, name := range []string{"alice", "Bob_99", "c.d.e"} {
+		if err := Validate(name); err != nil {
+			t.Errorf("unexpected error validating %q: %v", name, err)
+		}
+	}
+
+	for _, name := range []string{"", "ab", "1alice", "alice!", "admin", "a234567890123456789012345"} {
+		if err := Validate(name); err == nil {
+			t.Errorf("expected error validating %q", name)
+		}
+	}
+}
+
+func Test_Directory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-whisper-username")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	rules := Rules{
+		ChangeInterval: time.Hour,
+		HoldPeriod:     24 * time.Hour,
+	}
+
+	d, err := NewDirectory(dir, rules)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	now := time.Now()
+
+	if err := d.Claim("1", "Alice", now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := d.Claim("2", "alice", now); err != ErrTaken {
+		t.Errorf("expected taken error, got=%v", err)
+	}
+
+	if err := d.Claim("1", "alicia", now.Add(time.Minute)); err != ErrTooSoon {
+		t.Errorf("expected too soon error, got=%v", err)
+	}
+
+	if err := d.Claim("1", "alicia", now.Add(2*time.Hour)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := d.Lookup("alice"); err != ErrNotFound {
+		t.Errorf("expected released name to not be found, got=%v", err)
+	}
+
+	// alice is still held for uid 1, but only for the hold period.
+	if err := d.Claim("2", "alice", now.Add(3*time.Hour)); err != ErrTaken {
+		t.Errorf("expected held name to be taken, got=%v", err)
+	}
+
+	if err := d.Claim("2", "alice", now.Add(30*time.Hour)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Reload from disk.
+	d, err = NewDirectory(dir, rules)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for name, exp := range map[string]string{"alicia": "1", "ALICE": "2"} {
+		uid, err := d.Lookup(name)
+		if err != nil {
+			t.Errorf("unexpected error looking up %q: %v", name, err)
+			continue
+		}
+		if uid != exp {
+			t.Errorf("unexpected uid for %q, exp=%s got=%s", name, exp, uid)
+		}
+	}
+
+	if name, ok := d.Username("1"); !ok || name != "alicia" {
+		t.Errorf("unexpected username for uid 1, got=%q", name)
+	}
+}
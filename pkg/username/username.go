@@ -0,0 +1,56 @@
+package username
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	MinLength = 3
+	MaxLength = 24
+)
+
+var (
+	// Usernames must start with a letter so they can never be mistaken for
+	// a UID.
+	valid = regexp.MustCompile("^[a-z][a-z0-9_.]*$")
+
+	// Reserved names can never be claimed.
+	Reserved = map[string]bool{
+		"admin":   true,
+		"root":    true,
+		"server":  true,
+		"support": true,
+		"system":  true,
+		"whisper": true,
+	}
+
+	ErrReserved = errors.New("username is reserved")
+)
+
+// Canonical returns the form of name used for storage and comparison.
+// Usernames are case insensitive.
+func Canonical(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// Validate checks that name, once made canonical, is an acceptable username.
+func Validate(name string) error {
+	name = Canonical(name)
+
+	if len(name) < MinLength || len(name) > MaxLength {
+		return fmt.Errorf("usernames must be between %d and %d characters", MinLength, MaxLength)
+	}
+
+	if !valid.MatchString(name) {
+		return errors.New("usernames must start with a letter and only contain letters, digits, '_' and '.'")
+	}
+
+	if Reserved[name] {
+		return ErrReserved
+	}
+
+	return nil
+}